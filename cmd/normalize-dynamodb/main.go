@@ -0,0 +1,25 @@
+// Command normalize-dynamodb converts a DynamoDB table from the single table layout, where every
+// subscription held a copy of its repo, to the subscriptions and repositories tables the bot uses now.
+// It reads the same env vars as the bot and can be run again if it was interrupted.
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/chofnar/release-bot/internal/database/dynamodb"
+	"github.com/chofnar/release-bot/internal/server/logger"
+)
+
+func main() {
+	logger := logger.New()
+	defer logger.Sync()
+
+	driver := (&dynamodb.DriverFactory{}).Create(*logger).(*dynamodb.Driver)
+
+	err := driver.Normalize(context.Background())
+	if err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+}
@@ -6,7 +6,11 @@ require (
 	github.com/aws/aws-sdk-go-v2 v1.36.1
 	github.com/deckarep/golang-set/v2 v2.7.0
 	github.com/hasura/go-graphql-client v0.13.1
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.24
 	github.com/mymmrac/telego v0.32.0
+	golang.org/x/mod v0.22.0
+	golang.org/x/time v0.11.0
 )
 
 require (
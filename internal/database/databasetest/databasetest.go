@@ -0,0 +1,1010 @@
+// Package databasetest holds the conformance suite every database.Database driver has to pass.
+//
+// A driver wires it up from its own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		databasetest.Run(t, func(t *testing.T) database.Database {
+//			return newEmptyDriver(t)
+//		})
+//	}
+package databasetest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chofnar/release-bot/internal/database"
+	"github.com/chofnar/release-bot/internal/errors"
+	"github.com/chofnar/release-bot/internal/server/repo"
+)
+
+var ctx = context.Background()
+
+// Factory returns a driver backed by empty storage. It is called once per subtest.
+type Factory func(t *testing.T) database.Database
+
+func Run(t *testing.T, newDB Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, db database.Database)
+	}{
+		{"AddAndGet", testAddAndGet},
+		{"GetReposUnknownChat", testGetReposUnknownChat},
+		{"DuplicateAdd", testDuplicateAdd},
+		{"SameRepoDifferentChats", testSameRepoDifferentChats},
+		{"RemoveRepo", testRemoveRepo},
+		{"RemoveMissingRepo", testRemoveMissingRepo},
+		{"CheckExisting", testCheckExisting},
+		{"SetPreReleaseRetrieve", testSetPreReleaseRetrieve},
+		{"SetPreReleaseRetrieveMissing", testSetPreReleaseRetrieveMissing},
+		{"SetTrackTags", testSetTrackTags},
+		{"SetTrackTagsMissing", testSetTrackTagsMissing},
+		{"SetUpdateLevel", testSetUpdateLevel},
+		{"SetUpdateLevelMissing", testSetUpdateLevelMissing},
+		{"SetTagFilters", testSetTagFilters},
+		{"SetLastTag", testSetLastTag},
+		{"SetTagFiltersMissing", testSetTagFiltersMissing},
+		{"AddKeepsRelease", testAddKeepsRelease},
+		{"RemoveLastSubscriber", testRemoveLastSubscriber},
+		{"UpdateRelease", testUpdateRelease},
+		{"UpdateReleaseMissing", testUpdateReleaseMissing},
+		{"CarriedOver", testCarriedOver},
+		{"GetSubscribers", testGetSubscribers},
+		{"IterWatched", testIterWatched},
+		{"AllRepos", testAllRepos},
+		{"IterRepos", testIterRepos},
+		{"IterReposStopsEarly", testIterReposStopsEarly},
+		{"IterReposWriteWhileIterating", testIterReposWriteWhileIterating},
+		{"ChatSettings", testChatSettings},
+		{"SetDelivery", testSetDelivery},
+		{"SetQuietHours", testSetQuietHours},
+		{"PendingNotifications", testPendingNotifications},
+		{"Outbox", testOutbox},
+		{"MoveChat", testMoveChat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newDB(t))
+		})
+	}
+}
+
+func sampleRepo(id string) repo.Repo {
+	return repo.Repo{
+		RepoID: id,
+		Name:   "name-" + id,
+		Owner:  "owner-" + id,
+		Link:   "https://github.com/owner-" + id + "/name-" + id,
+		Release: repo.Release{
+			CurrentReleaseTagName: "v1.0.0",
+			CurrentReleaseID:      "release-" + id,
+			StableReleaseTagName:  "v1.0.0",
+			StableReleaseID:       "release-" + id,
+			PrereleaseTagName:     "v1.0.0-rc1",
+			PrereleaseID:          "prerelease-" + id,
+			LatestTagName:         "v1.0.0",
+			TagsDigest:            "tags-" + id,
+		},
+	}
+}
+
+func mustAdd(t *testing.T, db database.Database, chatID string, r repo.Repo) {
+	t.Helper()
+	if err := db.AddRepo(ctx, chatID, &r); err != nil {
+		t.Fatalf("AddRepo(%q, %q): %v", chatID, r.RepoID, err)
+	}
+}
+
+func mustGet(t *testing.T, db database.Database, chatID string) []repo.Repo {
+	t.Helper()
+	repos, err := db.GetRepos(ctx, chatID)
+	if err != nil {
+		t.Fatalf("GetRepos(%q): %v", chatID, err)
+	}
+	return repos
+}
+
+func testAddAndGet(t *testing.T, db database.Database) {
+	added := sampleRepo("R_1")
+	mustAdd(t, db, "1", added)
+
+	repos := mustGet(t, db, "1")
+	if len(repos) != 1 {
+		t.Fatalf("got %d repos, want 1", len(repos))
+	}
+
+	got := repos[0]
+	if got.RepoID != added.RepoID || got.Name != added.Name || got.Owner != added.Owner || got.Link != added.Link {
+		t.Errorf("got %+v, want %+v", got, added)
+	}
+	if got.Release != added.Release {
+		t.Errorf("got release %+v, want %+v", got.Release, added.Release)
+	}
+	if got.ShouldNotifyPrerelease {
+		t.Error("new subscriptions must not notify about prereleases")
+	}
+	if got.TrackTags || got.UpdateLevel != repo.UpdateLevelAll {
+		t.Errorf("new subscription got TrackTags %v, UpdateLevel %q, want releases at every level", got.TrackTags, got.UpdateLevel)
+	}
+	if got.IncludeTags != "" || got.ExcludeTags != "" || got.LastTagName != added.LatestTagName {
+		t.Errorf("new subscription got filters %q/%q and last tag %q, want no filters and %q", got.IncludeTags, got.ExcludeTags, got.LastTagName, added.LatestTagName)
+	}
+}
+
+func testGetReposUnknownChat(t *testing.T, db database.Database) {
+	repos := mustGet(t, db, "unknown")
+	if len(repos) != 0 {
+		t.Errorf("got %d repos for an unknown chat, want 0", len(repos))
+	}
+}
+
+func testDuplicateAdd(t *testing.T, db database.Database) {
+	first := sampleRepo("R_1")
+	mustAdd(t, db, "1", first)
+
+	second := sampleRepo("R_1")
+	second.CurrentReleaseTagName = "v2.0.0"
+	err := db.AddRepo(ctx, "1", &second)
+	if err != errors.ErrRepoExists {
+		t.Fatalf("duplicate AddRepo returned %v, want %v", err, errors.ErrRepoExists)
+	}
+
+	repos := mustGet(t, db, "1")
+	if len(repos) != 1 {
+		t.Fatalf("got %d repos after duplicate add, want 1", len(repos))
+	}
+	if repos[0].CurrentReleaseTagName != first.CurrentReleaseTagName {
+		t.Errorf("duplicate add overwrote the subscription: got tag %q, want %q", repos[0].CurrentReleaseTagName, first.CurrentReleaseTagName)
+	}
+}
+
+func testSameRepoDifferentChats(t *testing.T, db database.Database) {
+	mustAdd(t, db, "1", sampleRepo("R_1"))
+	mustAdd(t, db, "2", sampleRepo("R_1"))
+
+	for _, chatID := range []string{"1", "2"} {
+		if repos := mustGet(t, db, chatID); len(repos) != 1 {
+			t.Errorf("chat %s has %d repos, want 1", chatID, len(repos))
+		}
+	}
+}
+
+func testRemoveRepo(t *testing.T, db database.Database) {
+	mustAdd(t, db, "1", sampleRepo("R_1"))
+	mustAdd(t, db, "1", sampleRepo("R_2"))
+	mustAdd(t, db, "2", sampleRepo("R_1"))
+
+	if err := db.RemoveRepo(ctx, "1", "R_1"); err != nil {
+		t.Fatalf("RemoveRepo: %v", err)
+	}
+
+	repos := mustGet(t, db, "1")
+	if len(repos) != 1 || repos[0].RepoID != "R_2" {
+		t.Errorf("chat 1 has %+v after removal, want only R_2", repos)
+	}
+
+	if repos := mustGet(t, db, "2"); len(repos) != 1 {
+		t.Errorf("removal leaked into chat 2: got %d repos, want 1", len(repos))
+	}
+}
+
+func testRemoveMissingRepo(t *testing.T, db database.Database) {
+	if err := db.RemoveRepo(ctx, "1", "R_missing"); err != nil {
+		t.Errorf("RemoveRepo on an unknown chat returned %v, want nil", err)
+	}
+
+	mustAdd(t, db, "1", sampleRepo("R_1"))
+	if err := db.RemoveRepo(ctx, "1", "R_missing"); err != nil {
+		t.Errorf("RemoveRepo on an unknown repo returned %v, want nil", err)
+	}
+
+	if repos := mustGet(t, db, "1"); len(repos) != 1 {
+		t.Errorf("got %d repos, want 1", len(repos))
+	}
+}
+
+func testCheckExisting(t *testing.T, db database.Database) {
+	mustAdd(t, db, "1", sampleRepo("R_1"))
+
+	cases := []struct {
+		chatID, repoID string
+		want           bool
+	}{
+		{"1", "R_1", true},
+		{"1", "R_2", false},
+		{"2", "R_1", false},
+	}
+
+	for _, c := range cases {
+		got, err := db.CheckExisting(ctx, c.chatID, c.repoID)
+		if err != nil {
+			t.Fatalf("CheckExisting(%q, %q): %v", c.chatID, c.repoID, err)
+		}
+		if got != c.want {
+			t.Errorf("CheckExisting(%q, %q) = %v, want %v", c.chatID, c.repoID, got, c.want)
+		}
+	}
+}
+
+func testSetPreReleaseRetrieve(t *testing.T, db database.Database) {
+	mustAdd(t, db, "1", sampleRepo("R_1"))
+	mustAdd(t, db, "2", sampleRepo("R_1"))
+
+	for _, value := range []bool{true, false, true} {
+		if err := db.SetPreReleaseRetrieve(ctx, "1", "R_1", value); err != nil {
+			t.Fatalf("SetPreReleaseRetrieve(%v): %v", value, err)
+		}
+
+		if got := mustGet(t, db, "1")[0].ShouldNotifyPrerelease; got != value {
+			t.Errorf("ShouldNotifyPrerelease = %v, want %v", got, value)
+		}
+	}
+
+	if mustGet(t, db, "2")[0].ShouldNotifyPrerelease {
+		t.Error("setting leaked into another chat")
+	}
+}
+
+func testSetPreReleaseRetrieveMissing(t *testing.T, db database.Database) {
+	err := db.SetPreReleaseRetrieve(ctx, "1", "R_missing", true)
+	if err != errors.ErrRepoNotFound {
+		t.Errorf("SetPreReleaseRetrieve on a missing repo returned %v, want %v", err, errors.ErrRepoNotFound)
+	}
+
+	if repos := mustGet(t, db, "1"); len(repos) != 0 {
+		t.Errorf("SetPreReleaseRetrieve created %d repos, want 0", len(repos))
+	}
+}
+
+func testSetTrackTags(t *testing.T, db database.Database) {
+	mustAdd(t, db, "1", sampleRepo("R_1"))
+	mustAdd(t, db, "2", sampleRepo("R_1"))
+	if err := db.SetPreReleaseRetrieve(ctx, "1", "R_1", true); err != nil {
+		t.Fatalf("SetPreReleaseRetrieve: %v", err)
+	}
+
+	for _, value := range []bool{true, false, true} {
+		if err := db.SetTrackTags(ctx, "1", "R_1", value); err != nil {
+			t.Fatalf("SetTrackTags(%v): %v", value, err)
+		}
+
+		if got := mustGet(t, db, "1")[0].TrackTags; got != value {
+			t.Errorf("TrackTags = %v, want %v", got, value)
+		}
+	}
+
+	if !mustGet(t, db, "1")[0].ShouldNotifyPrerelease {
+		t.Error("SetTrackTags reset the prerelease setting")
+	}
+
+	subscribers, err := db.GetSubscribers(ctx, "R_1")
+	if err != nil {
+		t.Fatalf("GetSubscribers: %v", err)
+	}
+	if len(subscribers) != 2 || !subscribers[0].TrackTags || subscribers[1].TrackTags {
+		t.Errorf("GetSubscribers = %+v, want only chat 1 tracking tags", subscribers)
+	}
+}
+
+func testSetTrackTagsMissing(t *testing.T, db database.Database) {
+	err := db.SetTrackTags(ctx, "1", "R_missing", true)
+	if err != errors.ErrRepoNotFound {
+		t.Errorf("SetTrackTags on a missing repo returned %v, want %v", err, errors.ErrRepoNotFound)
+	}
+}
+
+func testSetUpdateLevel(t *testing.T, db database.Database) {
+	mustAdd(t, db, "1", sampleRepo("R_1"))
+	mustAdd(t, db, "2", sampleRepo("R_1"))
+
+	for _, level := range []repo.UpdateLevel{repo.UpdateLevelMajor, repo.UpdateLevelPatch} {
+		if err := db.SetUpdateLevel(ctx, "1", "R_1", level); err != nil {
+			t.Fatalf("SetUpdateLevel(%q): %v", level, err)
+		}
+
+		if got := mustGet(t, db, "1")[0].UpdateLevel; got != level {
+			t.Errorf("UpdateLevel = %q, want %q", got, level)
+		}
+	}
+
+	subscribers, err := db.GetSubscribers(ctx, "R_1")
+	if err != nil {
+		t.Fatalf("GetSubscribers: %v", err)
+	}
+	if len(subscribers) != 2 || subscribers[0].UpdateLevel != repo.UpdateLevelPatch || subscribers[1].UpdateLevel != repo.UpdateLevelAll {
+		t.Errorf("GetSubscribers = %+v, want only chat 1 at the patch level", subscribers)
+	}
+}
+
+func testSetUpdateLevelMissing(t *testing.T, db database.Database) {
+	err := db.SetUpdateLevel(ctx, "1", "R_missing", repo.UpdateLevelMajor)
+	if err != errors.ErrRepoNotFound {
+		t.Errorf("SetUpdateLevel on a missing repo returned %v, want %v", err, errors.ErrRepoNotFound)
+	}
+}
+
+func testSetTagFilters(t *testing.T, db database.Database) {
+	mustAdd(t, db, "1", sampleRepo("R_1"))
+	mustAdd(t, db, "2", sampleRepo("R_1"))
+
+	if err := db.SetTagFilters(ctx, "1", "R_1", `^service/dynamodb/`, `-rc`); err != nil {
+		t.Fatalf("SetTagFilters: %v", err)
+	}
+
+	got := mustGet(t, db, "1")[0]
+	if got.IncludeTags != `^service/dynamodb/` || got.ExcludeTags != `-rc` {
+		t.Errorf("filters = %q/%q, want the ones set", got.IncludeTags, got.ExcludeTags)
+	}
+	if got.LastTagName != "" {
+		t.Errorf("LastTagName = %q, want it forgotten", got.LastTagName)
+	}
+
+	other := mustGet(t, db, "2")[0]
+	if other.IncludeTags != "" || other.ExcludeTags != "" || other.LastTagName != "v1.0.0" {
+		t.Errorf("filters leaked into another chat: %+v", other)
+	}
+
+	if err := db.SetTagFilters(ctx, "1", "R_1", "", ""); err != nil {
+		t.Fatalf("SetTagFilters: %v", err)
+	}
+	if got := mustGet(t, db, "1")[0]; got.IncludeTags != "" || got.ExcludeTags != "" {
+		t.Errorf("filters = %q/%q, want them dropped", got.IncludeTags, got.ExcludeTags)
+	}
+}
+
+func testSetLastTag(t *testing.T, db database.Database) {
+	mustAdd(t, db, "1", sampleRepo("R_1"))
+	mustAdd(t, db, "2", sampleRepo("R_1"))
+
+	if err := db.SetLastTag(ctx, "1", "R_1", "v1.1.0"); err != nil {
+		t.Fatalf("SetLastTag: %v", err)
+	}
+
+	subscribers, err := db.GetSubscribers(ctx, "R_1")
+	if err != nil {
+		t.Fatalf("GetSubscribers: %v", err)
+	}
+	if len(subscribers) != 2 || subscribers[0].LastTagName != "v1.1.0" || subscribers[1].LastTagName != "v1.0.0" {
+		t.Errorf("GetSubscribers = %+v, want only chat 1 at v1.1.0", subscribers)
+	}
+	if got := mustGet(t, db, "1")[0].LatestTagName; got != "v1.0.0" {
+		t.Errorf("SetLastTag changed the repo's latest tag to %q", got)
+	}
+}
+
+func testSetTagFiltersMissing(t *testing.T, db database.Database) {
+	if err := db.SetTagFilters(ctx, "1", "R_missing", "x", ""); err != errors.ErrRepoNotFound {
+		t.Errorf("SetTagFilters on a missing repo returned %v, want %v", err, errors.ErrRepoNotFound)
+	}
+	if err := db.SetLastTag(ctx, "1", "R_missing", "v1.0.0"); err != errors.ErrRepoNotFound {
+		t.Errorf("SetLastTag on a missing repo returned %v, want %v", err, errors.ErrRepoNotFound)
+	}
+}
+
+func testChatSettings(t *testing.T, db database.Database) {
+	settings, err := db.GetChatSettings(ctx, "1")
+	if err != nil {
+		t.Fatalf("GetChatSettings: %v", err)
+	}
+	if settings != (repo.ChatSettings{ChatID: "1"}) {
+		t.Errorf("GetChatSettings of a new chat = %+v, want the zero settings", settings)
+	}
+
+	if err := db.SetTemplate(ctx, "1", "{{.Tag}}"); err != nil {
+		t.Fatalf("SetTemplate: %v", err)
+	}
+	if err := db.SetTemplate(ctx, "1", "{{.Repo}} {{.Tag}}"); err != nil {
+		t.Fatalf("SetTemplate again: %v", err)
+	}
+
+	settings, err = db.GetChatSettings(ctx, "1")
+	if err != nil {
+		t.Fatalf("GetChatSettings: %v", err)
+	}
+	if settings.ChatID != "1" || settings.Template != "{{.Repo}} {{.Tag}}" {
+		t.Errorf("GetChatSettings = %+v, want the second template", settings)
+	}
+
+	if other, _ := db.GetChatSettings(ctx, "2"); other.Template != "" {
+		t.Errorf("chat 2 got the template of chat 1: %+v", other)
+	}
+
+	// settings don't depend on subscriptions
+	mustAdd(t, db, "1", sampleRepo("R_1"))
+	if err := db.RemoveRepo(ctx, "1", "R_1"); err != nil {
+		t.Fatalf("RemoveRepo: %v", err)
+	}
+	if settings, _ = db.GetChatSettings(ctx, "1"); settings.Template != "{{.Repo}} {{.Tag}}" {
+		t.Errorf("RemoveRepo dropped the chat settings: %+v", settings)
+	}
+}
+
+func testSetDelivery(t *testing.T, db database.Database) {
+	if err := db.SetTemplate(ctx, "1", "{{.Tag}}"); err != nil {
+		t.Fatalf("SetTemplate: %v", err)
+	}
+	if err := db.SetDelivery(ctx, "1", repo.DeliveryWeekly, 18, "Europe/Berlin"); err != nil {
+		t.Fatalf("SetDelivery: %v", err)
+	}
+	if err := db.SetDelivery(ctx, "2", repo.DeliveryDaily, 0, ""); err != nil {
+		t.Fatalf("SetDelivery on a new chat: %v", err)
+	}
+
+	want := repo.ChatSettings{ChatID: "1", Template: "{{.Tag}}", Delivery: repo.DeliveryWeekly, DigestHour: 18, TimeZone: "Europe/Berlin"}
+	if settings, err := db.GetChatSettings(ctx, "1"); err != nil || settings != want {
+		t.Errorf("GetChatSettings = %+v, %v, want %+v", settings, err, want)
+	}
+
+	want = repo.ChatSettings{ChatID: "2", Delivery: repo.DeliveryDaily}
+	if settings, err := db.GetChatSettings(ctx, "2"); err != nil || settings != want {
+		t.Errorf("GetChatSettings = %+v, %v, want %+v", settings, err, want)
+	}
+}
+
+func testSetQuietHours(t *testing.T, db database.Database) {
+	if err := db.SetDelivery(ctx, "1", repo.DeliveryDaily, 9, "UTC"); err != nil {
+		t.Fatalf("SetDelivery: %v", err)
+	}
+	if err := db.SetQuietHours(ctx, "1", 22, 7, repo.QuietSilent); err != nil {
+		t.Fatalf("SetQuietHours: %v", err)
+	}
+	if err := db.SetTimeZone(ctx, "1", "America/New_York"); err != nil {
+		t.Fatalf("SetTimeZone: %v", err)
+	}
+	if err := db.SetTimeZone(ctx, "2", "Asia/Tokyo"); err != nil {
+		t.Fatalf("SetTimeZone on a new chat: %v", err)
+	}
+
+	want := repo.ChatSettings{
+		ChatID: "1", Delivery: repo.DeliveryDaily, DigestHour: 9, TimeZone: "America/New_York",
+		QuietFrom: 22, QuietUntil: 7, QuietMode: repo.QuietSilent,
+	}
+	if settings, err := db.GetChatSettings(ctx, "1"); err != nil || settings != want {
+		t.Errorf("GetChatSettings = %+v, %v, want %+v", settings, err, want)
+	}
+
+	want = repo.ChatSettings{ChatID: "2", TimeZone: "Asia/Tokyo"}
+	if settings, err := db.GetChatSettings(ctx, "2"); err != nil || settings != want {
+		t.Errorf("GetChatSettings = %+v, %v, want %+v", settings, err, want)
+	}
+}
+
+func testPendingNotifications(t *testing.T, db database.Database) {
+	queuedAt := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	notification := func(chatID, repoID, tag string, minutes int) repo.PendingNotification {
+		return repo.PendingNotification{
+			ChatID:       chatID,
+			RepoID:       repoID,
+			TagName:      tag,
+			RepoName:     "name-" + repoID,
+			RepoLink:     "https://github.com/owner/name-" + repoID,
+			IsPrerelease: tag == "v2.0.0-rc.1",
+			IsTag:        repoID == "R_2",
+			QueuedAt:     queuedAt.Add(time.Duration(minutes) * time.Minute),
+		}
+	}
+
+	for _, queued := range []repo.PendingNotification{
+		notification("1", "R_1", "v1.1.0", 2),
+		notification("1", "R_1", "v2.0.0-rc.1", 1),
+		notification("2", "R_2", "v0.1.0", 0),
+		// queued again, the first one stays
+		notification("1", "R_1", "v1.1.0", 5),
+	} {
+		if err := db.QueueNotification(ctx, queued); err != nil {
+			t.Fatalf("QueueNotification: %v", err)
+		}
+	}
+
+	pending, err := db.PendingNotifications(ctx)
+	if err != nil {
+		t.Fatalf("PendingNotifications: %v", err)
+	}
+	want := []repo.PendingNotification{
+		notification("1", "R_1", "v2.0.0-rc.1", 1),
+		notification("1", "R_1", "v1.1.0", 2),
+		notification("2", "R_2", "v0.1.0", 0),
+	}
+	if len(pending) != len(want) {
+		t.Fatalf("PendingNotifications = %+v, want %+v", pending, want)
+	}
+	for i := range want {
+		got := pending[i]
+		if !got.QueuedAt.Equal(want[i].QueuedAt) {
+			t.Errorf("notification %d queued at %v, want %v", i, got.QueuedAt, want[i].QueuedAt)
+		}
+		got.QueuedAt = want[i].QueuedAt
+		if got != want[i] {
+			t.Errorf("notification %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+
+	// R_3 was never queued
+	if err := db.RemovePending(ctx, "1", []repo.PendingNotification{want[0], notification("1", "R_3", "v1.0.0", 0)}); err != nil {
+		t.Fatalf("RemovePending: %v", err)
+	}
+
+	pending, err = db.PendingNotifications(ctx)
+	if err != nil {
+		t.Fatalf("PendingNotifications: %v", err)
+	}
+	if len(pending) != 2 || pending[0].TagName != "v1.1.0" || pending[1].ChatID != "2" {
+		t.Errorf("PendingNotifications after RemovePending = %+v", pending)
+	}
+}
+
+// iterRepoCount is large enough to span several pages of the SQL drivers.
+const iterRepoCount = 1200
+
+func testAddKeepsRelease(t *testing.T, db database.Database) {
+	mustAdd(t, db, "1", sampleRepo("R_1"))
+	if err := db.UpdateRelease(ctx, "R_1", repo.Release{CurrentReleaseTagName: "v2.0.0", CurrentReleaseID: "release-R_1-2"}); err != nil {
+		t.Fatalf("UpdateRelease: %v", err)
+	}
+
+	// a new subscriber sends whatever GitHub returned when it validated the repo
+	stale := sampleRepo("R_1")
+	stale.Name = "renamed"
+	mustAdd(t, db, "2", stale)
+
+	got := mustGet(t, db, "2")[0]
+	if got.CurrentReleaseID != "release-R_1-2" {
+		t.Errorf("AddRepo overwrote the last seen release: got %q, want %q", got.CurrentReleaseID, "release-R_1-2")
+	}
+	if got.Name != "renamed" {
+		t.Errorf("AddRepo did not refresh the name: got %q, want %q", got.Name, "renamed")
+	}
+}
+
+func testRemoveLastSubscriber(t *testing.T, db database.Database) {
+	mustAdd(t, db, "1", sampleRepo("R_1"))
+	mustAdd(t, db, "2", sampleRepo("R_1"))
+
+	for _, chatID := range []string{"1", "2"} {
+		if err := db.RemoveRepo(ctx, chatID, "R_1"); err != nil {
+			t.Fatalf("RemoveRepo(%q): %v", chatID, err)
+		}
+	}
+
+	for r, err := range db.IterWatched(ctx) {
+		if err != nil {
+			t.Fatalf("IterWatched: %v", err)
+		}
+		t.Errorf("%s is still watched after its last subscriber left", r.RepoID)
+	}
+
+	err := db.UpdateRelease(ctx, "R_1", sampleRepo("R_1").Release)
+	if err != errors.ErrRepoNotFound {
+		t.Errorf("UpdateRelease on a removed repo returned %v, want %v", err, errors.ErrRepoNotFound)
+	}
+}
+
+func testUpdateRelease(t *testing.T, db database.Database) {
+	mustAdd(t, db, "1", sampleRepo("R_1"))
+	mustAdd(t, db, "2", sampleRepo("R_1"))
+	mustAdd(t, db, "2", sampleRepo("R_2"))
+	if err := db.SetPreReleaseRetrieve(ctx, "1", "R_1", true); err != nil {
+		t.Fatalf("SetPreReleaseRetrieve: %v", err)
+	}
+
+	release := repo.Release{
+		CurrentReleaseTagName: "v2.0.0-rc1",
+		CurrentReleaseID:      "prerelease-R_1-2",
+		StableReleaseTagName:  "v1.1.0",
+		StableReleaseID:       "release-R_1-2",
+		PrereleaseTagName:     "v2.0.0-rc1",
+		PrereleaseID:          "prerelease-R_1-2",
+		LatestTagName:         "v2.0.0-rc1",
+		TagsDigest:            "tags-R_1-2",
+	}
+	if err := db.UpdateRelease(ctx, "R_1", release); err != nil {
+		t.Fatalf("UpdateRelease: %v", err)
+	}
+
+	for _, chatID := range []string{"1", "2"} {
+		for _, got := range mustGet(t, db, chatID) {
+			if got.RepoID != "R_1" {
+				if got.CurrentReleaseID != "release-"+got.RepoID {
+					t.Errorf("UpdateRelease leaked into %s: got %q", got.RepoID, got.CurrentReleaseID)
+				}
+				continue
+			}
+
+			if got.Release != release {
+				t.Errorf("chat %s got release %+v, want %+v", chatID, got.Release, release)
+			}
+		}
+	}
+
+	if !mustGet(t, db, "1")[0].ShouldNotifyPrerelease {
+		t.Error("UpdateRelease reset the prerelease setting")
+	}
+}
+
+func testUpdateReleaseMissing(t *testing.T, db database.Database) {
+	err := db.UpdateRelease(ctx, "R_missing", sampleRepo("R_missing").Release)
+	if err != errors.ErrRepoNotFound {
+		t.Errorf("UpdateRelease on a missing repo returned %v, want %v", err, errors.ErrRepoNotFound)
+	}
+
+	for r, err := range db.IterWatched(ctx) {
+		if err != nil {
+			t.Fatalf("IterWatched: %v", err)
+		}
+		t.Errorf("UpdateRelease on a missing repo created %s", r.RepoID)
+	}
+}
+
+func testCarriedOver(t *testing.T, db database.Database) {
+	mustAdd(t, db, "1", sampleRepo("R_1"))
+	mustAdd(t, db, "1", sampleRepo("R_2"))
+	mustAdd(t, db, "1", sampleRepo("R_3"))
+
+	carried := func() string {
+		t.Helper()
+		repoIDs, err := db.CarriedOverRepos(ctx)
+		if err != nil {
+			t.Fatalf("CarriedOverRepos: %v", err)
+		}
+		sort.Strings(repoIDs)
+		return strings.Join(repoIDs, ",")
+	}
+
+	if got := carried(); got != "" {
+		t.Errorf("new repos are carried over: %q", got)
+	}
+
+	if err := db.SetCarriedOver(ctx, []string{"R_1", "R_3", "R_missing"}, true); err != nil {
+		t.Fatalf("SetCarriedOver: %v", err)
+	}
+	if got := carried(); got != "R_1,R_3" {
+		t.Errorf("CarriedOverRepos = %q, want R_1,R_3", got)
+	}
+
+	if err := db.SetCarriedOver(ctx, []string{"R_1"}, false); err != nil {
+		t.Fatalf("SetCarriedOver: %v", err)
+	}
+	if got := carried(); got != "R_3" {
+		t.Errorf("CarriedOverRepos = %q, want R_3", got)
+	}
+
+	// the mark goes with the repo record
+	if err := db.RemoveRepo(ctx, "1", "R_3"); err != nil {
+		t.Fatalf("RemoveRepo: %v", err)
+	}
+	if got := carried(); got != "" {
+		t.Errorf("CarriedOverRepos = %q after the repo was dropped", got)
+	}
+}
+
+func testGetSubscribers(t *testing.T, db database.Database) {
+	mustAdd(t, db, "1", sampleRepo("R_1"))
+	mustAdd(t, db, "2", sampleRepo("R_1"))
+	mustAdd(t, db, "2", sampleRepo("R_2"))
+	if err := db.SetPreReleaseRetrieve(ctx, "2", "R_1", true); err != nil {
+		t.Fatalf("SetPreReleaseRetrieve: %v", err)
+	}
+
+	subscribers, err := db.GetSubscribers(ctx, "R_1")
+	if err != nil {
+		t.Fatalf("GetSubscribers: %v", err)
+	}
+
+	sort.Slice(subscribers, func(i, j int) bool {
+		return subscribers[i].ChatID < subscribers[j].ChatID
+	})
+
+	if len(subscribers) != 2 || subscribers[0].ChatID != "1" || subscribers[1].ChatID != "2" {
+		t.Fatalf("GetSubscribers = %+v, want chats 1 and 2", subscribers)
+	}
+	for _, s := range subscribers {
+		if s.RepoID != "R_1" || s.Name != "name-R_1" || s.CurrentReleaseID != "release-R_1" {
+			t.Errorf("subscriber %s got %+v, want the R_1 record", s.ChatID, s.Repo)
+		}
+	}
+	if subscribers[0].ShouldNotifyPrerelease || !subscribers[1].ShouldNotifyPrerelease {
+		t.Error("GetSubscribers mixed up the per-chat prerelease settings")
+	}
+
+	subscribers, err = db.GetSubscribers(ctx, "R_missing")
+	if err != nil {
+		t.Fatalf("GetSubscribers on a missing repo: %v", err)
+	}
+	if len(subscribers) != 0 {
+		t.Errorf("got %d subscribers for a missing repo, want 0", len(subscribers))
+	}
+}
+
+func testIterWatched(t *testing.T, db database.Database) {
+	want := map[string]struct{}{}
+	for i := 0; i < iterRepoCount; i++ {
+		r := sampleRepo(fmt.Sprintf("R_%04d", i))
+		// every repo is watched from two chats but must be yielded once
+		mustAdd(t, db, fmt.Sprint(i%7), r)
+		mustAdd(t, db, fmt.Sprint(i%7+7), r)
+		want[r.RepoID] = struct{}{}
+	}
+
+	seen := map[string]struct{}{}
+	for r, err := range db.IterWatched(ctx) {
+		if err != nil {
+			t.Fatalf("IterWatched: %v", err)
+		}
+
+		if _, dup := seen[r.RepoID]; dup {
+			t.Fatalf("IterWatched yielded %s twice", r.RepoID)
+		}
+		seen[r.RepoID] = struct{}{}
+
+		if r.CurrentReleaseID != "release-"+r.RepoID {
+			t.Errorf("IterWatched yielded %s with release %q", r.RepoID, r.CurrentReleaseID)
+		}
+	}
+
+	if len(seen) != len(want) {
+		t.Fatalf("IterWatched yielded %d repos, want %d", len(seen), len(want))
+	}
+}
+
+func testAllRepos(t *testing.T, db database.Database) {
+	repos, err := db.AllRepos(ctx)
+	if err != nil {
+		t.Fatalf("AllRepos on empty storage: %v", err)
+	}
+	if len(repos) != 0 {
+		t.Fatalf("got %d repos on empty storage, want 0", len(repos))
+	}
+
+	mustAdd(t, db, "1", sampleRepo("R_1"))
+	mustAdd(t, db, "1", sampleRepo("R_2"))
+	mustAdd(t, db, "2", sampleRepo("R_1"))
+
+	repos, err = db.AllRepos(ctx)
+	if err != nil {
+		t.Fatalf("AllRepos: %v", err)
+	}
+
+	got := make([]string, 0, len(repos))
+	for _, r := range repos {
+		got = append(got, r.ChatID+"/"+r.RepoID)
+	}
+	sort.Strings(got)
+
+	want := []string{"1/R_1", "1/R_2", "2/R_1"}
+	if len(got) != len(want) {
+		t.Fatalf("AllRepos = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("AllRepos = %v, want %v", got, want)
+		}
+	}
+}
+
+func testIterRepos(t *testing.T, db database.Database) {
+	want := map[string]struct{}{}
+	for i := 0; i < iterRepoCount; i++ {
+		chatID := fmt.Sprint(i % 7)
+		r := sampleRepo(fmt.Sprintf("R_%04d", i))
+		mustAdd(t, db, chatID, r)
+		want[chatID+"/"+r.RepoID] = struct{}{}
+	}
+
+	seen := map[string]struct{}{}
+	for r, err := range db.IterRepos(ctx) {
+		if err != nil {
+			t.Fatalf("IterRepos: %v", err)
+		}
+
+		key := r.ChatID + "/" + r.RepoID
+		if _, dup := seen[key]; dup {
+			t.Fatalf("IterRepos yielded %s twice", key)
+		}
+		seen[key] = struct{}{}
+	}
+
+	if len(seen) != len(want) {
+		t.Fatalf("IterRepos yielded %d repos, want %d", len(seen), len(want))
+	}
+	for key := range want {
+		if _, ok := seen[key]; !ok {
+			t.Errorf("IterRepos missed %s", key)
+		}
+	}
+}
+
+func testIterReposStopsEarly(t *testing.T, db database.Database) {
+	for i := 0; i < 10; i++ {
+		mustAdd(t, db, "1", sampleRepo(fmt.Sprint("R_", i)))
+	}
+
+	count := 0
+	for _, err := range db.IterRepos(ctx) {
+		if err != nil {
+			t.Fatalf("IterRepos: %v", err)
+		}
+		count++
+		if count == 3 {
+			break
+		}
+	}
+
+	if count != 3 {
+		t.Errorf("iterated %d repos, want 3", count)
+	}
+}
+
+func testIterReposWriteWhileIterating(t *testing.T, db database.Database) {
+	for i := 0; i < 10; i++ {
+		mustAdd(t, db, "1", sampleRepo(fmt.Sprint("R_", i)))
+	}
+
+	for r, err := range db.IterRepos(ctx) {
+		if err != nil {
+			t.Fatalf("IterRepos: %v", err)
+		}
+
+		if err := db.UpdateRelease(ctx, r.RepoID, repo.Release{CurrentReleaseID: "updated"}); err != nil {
+			t.Fatalf("UpdateRelease while iterating: %v", err)
+		}
+		if err := db.SetPreReleaseRetrieve(ctx, r.ChatID, r.RepoID, true); err != nil {
+			t.Fatalf("SetPreReleaseRetrieve while iterating: %v", err)
+		}
+	}
+
+	for _, r := range mustGet(t, db, "1") {
+		if r.CurrentReleaseID != "updated" || !r.ShouldNotifyPrerelease {
+			t.Errorf("%s was not updated", r.RepoID)
+		}
+	}
+}
+
+func testOutbox(t *testing.T, db database.Database) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	message := func(key string, next time.Duration) repo.OutboxMessage {
+		return repo.OutboxMessage{
+			Key:           key,
+			ChatID:        "1",
+			RepoID:        "R_1",
+			Text:          "<b>" + key + "</b>",
+			ParseMode:     "HTML",
+			Keyboard:      `{"inline_keyboard":[]}`,
+			PlainText:     key,
+			Silent:        key == "b",
+			NextAttemptAt: now.Add(next),
+			CreatedAt:     now,
+		}
+	}
+
+	for _, queued := range []repo.OutboxMessage{message("b", -time.Minute), message("a", -time.Hour), message("c", time.Minute)} {
+		added, err := db.EnqueueOutbox(ctx, queued)
+		if err != nil || !added {
+			t.Fatalf("EnqueueOutbox(%s) = %v, %v", queued.Key, added, err)
+		}
+	}
+
+	if added, err := db.EnqueueOutbox(ctx, message("a", 0)); err != nil || added {
+		t.Errorf("EnqueueOutbox of a used key = %v, %v", added, err)
+	}
+
+	due, err := db.DueOutbox(ctx, now)
+	if err != nil {
+		t.Fatalf("DueOutbox: %v", err)
+	}
+	if len(due) != 2 || due[0].Key != "a" || due[1].Key != "b" {
+		t.Fatalf("DueOutbox = %+v, want a and b", due)
+	}
+	if want := message("b", -time.Minute); due[1].Text != want.Text || due[1].Keyboard != want.Keyboard || due[1].PlainText != want.PlainText ||
+		!due[1].Silent || due[1].ParseMode != want.ParseMode || !due[1].NextAttemptAt.Equal(want.NextAttemptAt) || !due[1].CreatedAt.Equal(now) {
+		t.Errorf("DueOutbox returned %+v, want %+v", due[1], want)
+	}
+
+	if err = db.RetryOutbox(ctx, "a", 1, now.Add(time.Hour), "Too Many Requests"); err != nil {
+		t.Fatalf("RetryOutbox: %v", err)
+	}
+	if err = db.MarkDelivered(ctx, "b", now); err != nil {
+		t.Fatalf("MarkDelivered: %v", err)
+	}
+	if err = db.RetryOutbox(ctx, "gone", 1, now, ""); err != nil {
+		t.Errorf("RetryOutbox of an unknown key: %v", err)
+	}
+
+	due, err = db.DueOutbox(ctx, now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("DueOutbox: %v", err)
+	}
+	if len(due) != 2 || due[0].Key != "c" || due[1].Key != "a" || due[1].Attempts != 1 || due[1].LastError != "Too Many Requests" {
+		t.Errorf("DueOutbox after a retry and a delivery = %+v", due)
+	}
+
+	// delivered keys are kept until pruned
+	if added, err := db.EnqueueOutbox(ctx, message("b", 0)); err != nil || added {
+		t.Errorf("EnqueueOutbox of a delivered key = %v, %v", added, err)
+	}
+	if err = db.PruneOutbox(ctx, now.Add(time.Second)); err != nil {
+		t.Fatalf("PruneOutbox: %v", err)
+	}
+	if added, err := db.EnqueueOutbox(ctx, message("b", 0)); err != nil || !added {
+		t.Errorf("EnqueueOutbox of a pruned key = %v, %v", added, err)
+	}
+}
+
+func testMoveChat(t *testing.T, db database.Database) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	mustAdd(t, db, "1", sampleRepo("R_1"))
+	mustAdd(t, db, "1", sampleRepo("R_2"))
+	mustAdd(t, db, "2", sampleRepo("R_2"))
+	if err := db.SetPreReleaseRetrieve(ctx, "1", "R_1", true); err != nil {
+		t.Fatalf("SetPreReleaseRetrieve: %v", err)
+	}
+	if err := db.SetPreReleaseRetrieve(ctx, "1", "R_2", true); err != nil {
+		t.Fatalf("SetPreReleaseRetrieve: %v", err)
+	}
+	if err := db.SetTemplate(ctx, "1", "{{.Tag}}"); err != nil {
+		t.Fatalf("SetTemplate: %v", err)
+	}
+	err := db.QueueNotification(ctx, repo.PendingNotification{ChatID: "1", RepoID: "R_1", TagName: "v1.1.0", RepoName: "name-R_1", QueuedAt: now})
+	if err != nil {
+		t.Fatalf("QueueNotification: %v", err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if _, err = db.EnqueueOutbox(ctx, repo.OutboxMessage{Key: key, ChatID: "1", RepoID: "R_1", Text: key, NextAttemptAt: now, CreatedAt: now}); err != nil {
+			t.Fatalf("EnqueueOutbox: %v", err)
+		}
+	}
+	if err = db.MarkDelivered(ctx, "b", now); err != nil {
+		t.Fatalf("MarkDelivered: %v", err)
+	}
+
+	if err = db.MoveChat(ctx, "1", "2"); err != nil {
+		t.Fatalf("MoveChat: %v", err)
+	}
+	// a move that is done already changes nothing
+	if err = db.MoveChat(ctx, "1", "2"); err != nil {
+		t.Fatalf("MoveChat again: %v", err)
+	}
+
+	if repos := mustGet(t, db, "1"); len(repos) != 0 {
+		t.Errorf("the old chat kept %d repos", len(repos))
+	}
+	if repos := mustGet(t, db, "2"); len(repos) != 2 {
+		t.Errorf("the new chat has %d repos, want 2", len(repos))
+	}
+	for repoID, wantPre := range map[string]bool{"R_1": true, "R_2": false} {
+		subscribers, err := db.GetSubscribers(ctx, repoID)
+		if err != nil {
+			t.Fatalf("GetSubscribers(%s): %v", repoID, err)
+		}
+		if len(subscribers) != 1 || subscribers[0].ChatID != "2" || subscribers[0].ShouldNotifyPrerelease != wantPre {
+			t.Errorf("subscribers of %s = %+v, want chat 2 with prereleases %v", repoID, subscribers, wantPre)
+		}
+	}
+
+	if settings, _ := db.GetChatSettings(ctx, "2"); settings.Template != "{{.Tag}}" {
+		t.Errorf("settings of the new chat = %+v, want those of the old one", settings)
+	}
+	if settings, _ := db.GetChatSettings(ctx, "1"); settings.Template != "" {
+		t.Errorf("the old chat kept its settings: %+v", settings)
+	}
+
+	pending, err := db.PendingNotifications(ctx)
+	if err != nil {
+		t.Fatalf("PendingNotifications: %v", err)
+	}
+	if len(pending) != 1 || pending[0].ChatID != "2" || pending[0].RepoName != "name-R_1" {
+		t.Errorf("PendingNotifications = %+v, want the one of chat 1 queued for chat 2", pending)
+	}
+
+	due, err := db.DueOutbox(ctx, now)
+	if err != nil {
+		t.Fatalf("DueOutbox: %v", err)
+	}
+	if len(due) != 1 || due[0].Key != "a" || due[0].ChatID != "2" {
+		t.Errorf("DueOutbox = %+v, want a on its way to chat 2", due)
+	}
+}
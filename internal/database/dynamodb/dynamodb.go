@@ -2,8 +2,14 @@ package dynamodb
 
 import (
 	"context"
+	stderrors "errors"
 	"fmt"
+	"iter"
+	"maps"
 	"os"
+	"sort"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -16,30 +22,55 @@ import (
 	"go.uber.org/zap"
 )
 
+// Driver keeps the subscriptions in tableName, keyed by chatID and repoID, and the watched
+// repos with their last seen release in repositoriesTableName, keyed by repoID. Every repository
+// item also holds the set of subscribed chat IDs, so its subscribers can be found without a scan.
+// The settings of whole chats are kept in chatsTableName, keyed by chatID, and the notifications
+// waiting for a digest in pendingTableName, keyed by chatID and notificationKey. Notifications on their way
+// to Telegram are kept in outboxTableName, keyed by idempotencyKey.
 type Driver struct {
-	client    *dynamodb.Client
-	logger    zap.SugaredLogger
-	tableName string
+	client                *dynamodb.Client
+	logger                zap.SugaredLogger
+	tableName             string
+	repositoriesTableName string
+	chatsTableName        string
+	pendingTableName      string
+	outboxTableName       string
 }
 
 type DriverFactory struct{}
 
 type dynamoDBparams struct {
-	endpoint  string
-	region    string
-	tableName string
+	endpoint              string
+	region                string
+	tableName             string
+	repositoriesTableName string
+	chatsTableName        string
+	pendingTableName      string
+	outboxTableName       string
 }
 
 const (
-	defaultEndpoint  = "http://localhost:4566"
-	defaultRegion    = "eu-central-1"
-	defaultTableName = "ReleasesBot"
+	defaultEndpoint              = "http://localhost:4566"
+	defaultRegion                = "eu-central-1"
+	defaultTableName             = "ReleasesBot"
+	defaultRepositoriesTableName = "ReleasesBotRepositories"
+	defaultChatsTableName        = "ReleasesBotChats"
+	defaultPendingTableName      = "ReleasesBotPending"
+	defaultOutboxTableName       = "ReleasesBotOutbox"
 )
 
+// batchGetLimit is the most keys a single BatchGetItem call accepts.
+const batchGetLimit = 100
+
 func (params *dynamoDBparams) fillDefaults() {
 	params.endpoint = defaultEndpoint
 	params.region = defaultRegion
 	params.tableName = defaultTableName
+	params.repositoriesTableName = defaultRepositoriesTableName
+	params.chatsTableName = defaultChatsTableName
+	params.pendingTableName = defaultPendingTableName
+	params.outboxTableName = defaultOutboxTableName
 }
 
 func loadConfig() dynamoDBparams {
@@ -55,6 +86,18 @@ func loadConfig() dynamoDBparams {
 	if value := os.Getenv("BOT_TABLE_NAME"); value != "" {
 		params.tableName = value
 	}
+	if value := os.Getenv("BOT_REPOSITORIES_TABLE_NAME"); value != "" {
+		params.repositoriesTableName = value
+	}
+	if value := os.Getenv("BOT_CHATS_TABLE_NAME"); value != "" {
+		params.chatsTableName = value
+	}
+	if value := os.Getenv("BOT_PENDING_TABLE_NAME"); value != "" {
+		params.pendingTableName = value
+	}
+	if value := os.Getenv("BOT_OUTBOX_TABLE_NAME"); value != "" {
+		params.outboxTableName = value
+	}
 
 	return params
 }
@@ -80,117 +123,513 @@ func (factory *DriverFactory) Create(logger zap.SugaredLogger) database.Database
 	}
 
 	return &Driver{
-		client:    dynamodb.NewFromConfig(cfg),
-		tableName: params.tableName,
-		logger:    logger,
+		client:                dynamodb.NewFromConfig(cfg),
+		tableName:             params.tableName,
+		repositoriesTableName: params.repositoriesTableName,
+		chatsTableName:        params.chatsTableName,
+		pendingTableName:      params.pendingTableName,
+		outboxTableName:       params.outboxTableName,
+		logger:                logger,
+	}
+}
+
+type subscriptionItem struct {
+	ChatID    string `dynamodbav:"chatID"`
+	RepoID    string `dynamodbav:"repoID"`
+	ShouldPre bool   `dynamodbav:"shouldPre"`
+	TrackTags bool   `dynamodbav:"trackTags"`
+	// UpdateLevel is missing on items written before levels existed
+	UpdateLevel repo.UpdateLevel `dynamodbav:"updateLevel,omitempty"`
+	IncludeTags string           `dynamodbav:"includeTags,omitempty"`
+	ExcludeTags string           `dynamodbav:"excludeTags,omitempty"`
+	LastTagName string           `dynamodbav:"lastTagName,omitempty"`
+}
+
+// join merges the chat's settings into the repository the subscription is for.
+func (item subscriptionItem) join(repository repositoryItem) repo.RepoWithChatID {
+	joined := repo.RepoWithChatID{Repo: repository.repo(), ChatID: item.ChatID}
+	joined.ShouldNotifyPrerelease = item.ShouldPre
+	joined.TrackTags = item.TrackTags
+	joined.UpdateLevel = item.UpdateLevel
+	if joined.UpdateLevel == "" {
+		joined.UpdateLevel = repo.UpdateLevelAll
+	}
+	joined.IncludeTags, joined.ExcludeTags = item.IncludeTags, item.ExcludeTags
+	joined.LastTagName = item.LastTagName
+
+	return joined
+}
+
+type repositoryItem struct {
+	RepoID                string   `dynamodbav:"repoID"`
+	Name                  string   `dynamodbav:"repoName"`
+	Owner                 string   `dynamodbav:"repoOwner"`
+	Link                  string   `dynamodbav:"repoLink"`
+	CurrentReleaseTagName string   `dynamodbav:"currentReleaseTagName"`
+	CurrentReleaseID      string   `dynamodbav:"currentReleaseID"`
+	StableReleaseTagName  string   `dynamodbav:"stableReleaseTagName"`
+	StableReleaseID       string   `dynamodbav:"stableReleaseID"`
+	PrereleaseTagName     string   `dynamodbav:"prereleaseTagName"`
+	PrereleaseID          string   `dynamodbav:"prereleaseID"`
+	LatestTagName         string   `dynamodbav:"latestTagName"`
+	TagsDigest            string   `dynamodbav:"tagsDigest"`
+	Subscribers           []string `dynamodbav:"subscribers,stringset,omitempty"`
+}
+
+func (item repositoryItem) repo() repo.Repo {
+	return repo.Repo{
+		RepoID: item.RepoID,
+		Name:   item.Name,
+		Owner:  item.Owner,
+		Link:   item.Link,
+		Release: repo.Release{
+			CurrentReleaseTagName: item.CurrentReleaseTagName,
+			CurrentReleaseID:      item.CurrentReleaseID,
+			StableReleaseTagName:  item.StableReleaseTagName,
+			StableReleaseID:       item.StableReleaseID,
+			PrereleaseTagName:     item.PrereleaseTagName,
+			PrereleaseID:          item.PrereleaseID,
+			LatestTagName:         item.LatestTagName,
+			TagsDigest:            item.TagsDigest,
+		},
+	}
+}
+
+func subscriptionKey(chatID, repoID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"chatID": &types.AttributeValueMemberS{Value: chatID},
+		"repoID": &types.AttributeValueMemberS{Value: repoID},
+	}
+}
+
+func repositoryKey(repoID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"repoID": &types.AttributeValueMemberS{Value: repoID},
 	}
 }
 
-func (db *Driver) GetRepos(chatID string) ([]repo.Repo, error) {
+// batchGet reads the given keys from table in chunks of batchGetLimit, retrying unprocessed keys.
+// Missing items are left out of the result.
+func (db *Driver) batchGet(ctx context.Context, table string, keys []map[string]types.AttributeValue) ([]map[string]types.AttributeValue, error) {
+	items := make([]map[string]types.AttributeValue, 0, len(keys))
+	for start := 0; start < len(keys); start += batchGetLimit {
+		request := map[string]types.KeysAndAttributes{
+			table: {Keys: keys[start:min(start+batchGetLimit, len(keys))]},
+		}
+
+		for len(request) > 0 {
+			output, err := db.client.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{RequestItems: request})
+			if err != nil {
+				return nil, err
+			}
+
+			items = append(items, output.Responses[table]...)
+			request = output.UnprocessedKeys
+		}
+	}
+
+	return items, nil
+}
+
+// getRepositories reads the repository items of the given repo IDs, keyed by repo ID.
+func (db *Driver) getRepositories(ctx context.Context, repoIDs []string) (map[string]repositoryItem, error) {
+	keys := make([]map[string]types.AttributeValue, 0, len(repoIDs))
+	seen := map[string]struct{}{}
+	for _, repoID := range repoIDs {
+		if _, ok := seen[repoID]; ok {
+			continue
+		}
+		seen[repoID] = struct{}{}
+		keys = append(keys, repositoryKey(repoID))
+	}
+
+	items, err := db.batchGet(ctx, db.repositoriesTableName, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	page := make([]repositoryItem, len(items))
+	err = attributevalue.UnmarshalListOfMaps(items, &page)
+	if err != nil {
+		return nil, err
+	}
+
+	repositories := make(map[string]repositoryItem, len(page))
+	for _, item := range page {
+		repositories[item.RepoID] = item
+	}
+
+	return repositories, nil
+}
+
+// join attaches the repository items to the subscriptions, in the order of subscriptions.
+// A subscription whose repository item is missing is skipped.
+func (db *Driver) join(ctx context.Context, subscriptions []subscriptionItem) ([]repo.RepoWithChatID, error) {
+	repoIDs := make([]string, len(subscriptions))
+	for i, subscription := range subscriptions {
+		repoIDs[i] = subscription.RepoID
+	}
+
+	repositories, err := db.getRepositories(ctx, repoIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	joined := make([]repo.RepoWithChatID, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		repository, ok := repositories[subscription.RepoID]
+		if !ok {
+			db.logger.Warnf("subscription of chat %s to %s has no repository item", subscription.ChatID, subscription.RepoID)
+			continue
+		}
+
+		joined = append(joined, subscription.join(repository))
+	}
+
+	return joined, nil
+}
+
+func (db *Driver) GetRepos(ctx context.Context, chatID string) ([]repo.Repo, error) {
 	filterExp := "chatID = :chatid"
 	filterField := types.AttributeValueMemberS{Value: chatID}
 
-	resp, err := db.client.Query(context.TODO(), &dynamodb.QueryInput{
+	paginator := dynamodb.NewQueryPaginator(db.client, &dynamodb.QueryInput{
 		TableName:              &db.tableName,
 		KeyConditionExpression: &filterExp,
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":chatid": &filterField,
 		},
 	})
-	if err != nil {
-		return nil, err
-	}
 
-	if resp == nil {
-		return nil, errors.ErrChatIDNotFound
-	}
+	subscriptions := []subscriptionItem{}
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
 
-	repos := make([]repo.Repo, len(resp.Items))
+		if resp == nil {
+			return nil, errors.ErrChatIDNotFound
+		}
+
+		page := make([]subscriptionItem, len(resp.Items))
+		err = attributevalue.UnmarshalListOfMaps(resp.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+
+		subscriptions = append(subscriptions, page...)
+	}
 
-	err = attributevalue.UnmarshalListOfMaps(resp.Items, &repos)
+	joined, err := db.join(ctx, subscriptions)
 	if err != nil {
 		return nil, err
 	}
 
+	repos := make([]repo.Repo, len(joined))
+	for i, r := range joined {
+		repos[i] = r.Repo
+	}
+
 	return repos, nil
 }
 
-func (db *Driver) AddRepo(chatID string, details *repo.Repo) error {
-	// TODO: Contexts, mate
-	_, err := db.client.PutItem(context.TODO(), &dynamodb.PutItemInput{
-		TableName: &db.tableName,
-		Item: map[string]types.AttributeValue{
-			"chatID":                &types.AttributeValueMemberS{Value: chatID},
-			"repoID":                &types.AttributeValueMemberS{Value: details.RepoID},
-			"repoName":              &types.AttributeValueMemberS{Value: details.Name},
-			"repoOwner":             &types.AttributeValueMemberS{Value: details.Owner},
-			"repoLink":              &types.AttributeValueMemberS{Value: details.Link},
-			"currentReleaseTagName": &types.AttributeValueMemberS{Value: details.Release.CurrentReleaseTagName},
-			"currentReleaseID":      &types.AttributeValueMemberS{Value: details.Release.CurrentReleaseID},
-			"shouldPre":             &types.AttributeValueMemberBOOL{Value: false},
+// AddRepo writes the subscription and registers the chat on the repository item in one transaction.
+// The repository item is created for the first subscriber, an existing one keeps its last seen release
+// and only gets its name, owner and link refreshed.
+func (db *Driver) AddRepo(ctx context.Context, chatID string, details *repo.Repo) error {
+	_, err := db.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Put: &types.Put{
+					TableName: &db.tableName,
+					Item: map[string]types.AttributeValue{
+						"chatID":      &types.AttributeValueMemberS{Value: chatID},
+						"repoID":      &types.AttributeValueMemberS{Value: details.RepoID},
+						"shouldPre":   &types.AttributeValueMemberBOOL{Value: false},
+						"trackTags":   &types.AttributeValueMemberBOOL{Value: false},
+						"updateLevel": &types.AttributeValueMemberS{Value: string(repo.UpdateLevelAll)},
+						"lastTagName": &types.AttributeValueMemberS{Value: details.LatestTagName},
+					},
+					ConditionExpression: aws.String("attribute_not_exists(repoID)"),
+				},
+			},
+			{
+				Update: db.repositoryUpsert(*details, []string{chatID}),
+			},
 		},
 	})
-	if err != nil {
-		return err
+
+	return transactionConditionFailedAs(err, errors.ErrRepoExists)
+}
+
+// repositoryUpsert adds chatIDs to the subscribers of the repository item, creating it from details if needed.
+// An existing item keeps its last seen release.
+func (db *Driver) repositoryUpsert(details repo.Repo, chatIDs []string) *types.Update {
+	return &types.Update{
+		TableName: &db.repositoriesTableName,
+		Key:       repositoryKey(details.RepoID),
+		UpdateExpression: aws.String("SET repoName = :name, repoOwner = :owner, repoLink = :link, " +
+			"currentReleaseTagName = if_not_exists(currentReleaseTagName, :releaseTagName), " +
+			"currentReleaseID = if_not_exists(currentReleaseID, :releaseID), " +
+			"stableReleaseTagName = if_not_exists(stableReleaseTagName, :stableTagName), " +
+			"stableReleaseID = if_not_exists(stableReleaseID, :stableID), " +
+			"prereleaseTagName = if_not_exists(prereleaseTagName, :preTagName), " +
+			"prereleaseID = if_not_exists(prereleaseID, :preID), " +
+			"latestTagName = if_not_exists(latestTagName, :latestTagName), " +
+			"tagsDigest = if_not_exists(tagsDigest, :tagsDigest) " +
+			"ADD subscribers :chatIDs"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":name":           &types.AttributeValueMemberS{Value: details.Name},
+			":owner":          &types.AttributeValueMemberS{Value: details.Owner},
+			":link":           &types.AttributeValueMemberS{Value: details.Link},
+			":releaseTagName": &types.AttributeValueMemberS{Value: details.CurrentReleaseTagName},
+			":releaseID":      &types.AttributeValueMemberS{Value: details.CurrentReleaseID},
+			":stableTagName":  &types.AttributeValueMemberS{Value: details.StableReleaseTagName},
+			":stableID":       &types.AttributeValueMemberS{Value: details.StableReleaseID},
+			":preTagName":     &types.AttributeValueMemberS{Value: details.PrereleaseTagName},
+			":preID":          &types.AttributeValueMemberS{Value: details.PrereleaseID},
+			":latestTagName":  &types.AttributeValueMemberS{Value: details.LatestTagName},
+			":tagsDigest":     &types.AttributeValueMemberS{Value: details.TagsDigest},
+			":chatIDs":        &types.AttributeValueMemberSS{Value: chatIDs},
+		},
 	}
-	return nil
 }
 
-func (db *Driver) RemoveRepo(chatID, repoID string) error {
-	_, err := db.client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
-		Key: map[string]types.AttributeValue{
-			"chatID": &types.AttributeValueMemberS{
-				Value: chatID,
+// RemoveRepo deletes the subscription and the chat from the repository's subscribers in one transaction,
+// then drops the repository item if that was its last subscriber.
+func (db *Driver) RemoveRepo(ctx context.Context, chatID, repoID string) error {
+	_, err := db.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				// without the condition, removing an unknown subscription would create an empty repository item below
+				Delete: &types.Delete{
+					TableName:           &db.tableName,
+					Key:                 subscriptionKey(chatID, repoID),
+					ConditionExpression: aws.String("attribute_exists(repoID)"),
+				},
 			},
-			"repoID": &types.AttributeValueMemberS{
-				Value: repoID,
+			{
+				Update: &types.Update{
+					TableName:        &db.repositoriesTableName,
+					Key:              repositoryKey(repoID),
+					UpdateExpression: aws.String("DELETE subscribers :chatID"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":chatID": &types.AttributeValueMemberSS{Value: []string{chatID}},
+					},
+				},
 			},
 		},
-		TableName: &db.tableName,
+	})
+	err = transactionConditionFailedAs(err, nil)
+	if err != nil {
+		return err
+	}
+
+	// an empty set is removed from the item, a concurrent AddRepo re-adds it and keeps the item
+	_, err = db.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:           &db.repositoriesTableName,
+		Key:                 repositoryKey(repoID),
+		ConditionExpression: aws.String("attribute_not_exists(subscribers)"),
 	})
 
-	return err
+	return conditionFailedAs(err, nil)
 }
 
-func (db *Driver) AllRepos() ([]repo.RepoWithChatID, error) {
-	// TODO: may need to implement pagination
-	result, err := db.client.Scan(context.TODO(), &dynamodb.ScanInput{
-		TableName: &db.tableName,
+func (db *Driver) AllRepos(ctx context.Context) ([]repo.RepoWithChatID, error) {
+	return database.CollectRepos(db.IterRepos(ctx))
+}
+
+// IterRepos follows LastEvaluatedKey until the whole subscriptions table has been scanned,
+// a single Scan call stops at 1 MB of data. Every page is joined with its repository items.
+func (db *Driver) IterRepos(ctx context.Context) iter.Seq2[repo.RepoWithChatID, error] {
+	return func(yield func(repo.RepoWithChatID, error) bool) {
+		paginator := dynamodb.NewScanPaginator(db.client, &dynamodb.ScanInput{
+			TableName: &db.tableName,
+		})
+
+		for paginator.HasMorePages() {
+			result, err := paginator.NextPage(ctx)
+			if err != nil {
+				yield(repo.RepoWithChatID{}, err)
+				return
+			}
+
+			subscriptions := make([]subscriptionItem, len(result.Items))
+			err = attributevalue.UnmarshalListOfMaps(result.Items, &subscriptions)
+			if err != nil {
+				yield(repo.RepoWithChatID{}, err)
+				return
+			}
+
+			page, err := db.join(ctx, subscriptions)
+			if err != nil {
+				yield(repo.RepoWithChatID{}, err)
+				return
+			}
+
+			for _, r := range page {
+				if !yield(r, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// IterWatched scans the repositories table the same way IterRepos scans the subscriptions.
+func (db *Driver) IterWatched(ctx context.Context) iter.Seq2[repo.Repo, error] {
+	return func(yield func(repo.Repo, error) bool) {
+		paginator := dynamodb.NewScanPaginator(db.client, &dynamodb.ScanInput{
+			TableName: &db.repositoriesTableName,
+		})
+
+		for paginator.HasMorePages() {
+			result, err := paginator.NextPage(ctx)
+			if err != nil {
+				yield(repo.Repo{}, err)
+				return
+			}
+
+			page := make([]repositoryItem, len(result.Items))
+			err = attributevalue.UnmarshalListOfMaps(result.Items, &page)
+			if err != nil {
+				yield(repo.Repo{}, err)
+				return
+			}
+
+			for _, item := range page {
+				if !yield(item.repo(), nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (db *Driver) GetSubscribers(ctx context.Context, repoID string) ([]repo.RepoWithChatID, error) {
+	output, err := db.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: &db.repositoriesTableName,
+		Key:       repositoryKey(repoID),
 	})
+	if err != nil {
+		return nil, err
+	}
 
+	if output.Item == nil {
+		return []repo.RepoWithChatID{}, nil
+	}
+
+	var repository repositoryItem
+	err = attributevalue.UnmarshalMap(output.Item, &repository)
 	if err != nil {
 		return nil, err
 	}
 
-	repos := make([]repo.RepoWithChatID, len(result.Items))
+	keys := make([]map[string]types.AttributeValue, len(repository.Subscribers))
+	for i, chatID := range repository.Subscribers {
+		keys[i] = subscriptionKey(chatID, repoID)
+	}
 
-	err = attributevalue.UnmarshalListOfMaps(result.Items, &repos)
+	items, err := db.batchGet(ctx, db.tableName, keys)
 	if err != nil {
 		return nil, err
 	}
 
-	return repos, nil
+	subscriptions := make([]subscriptionItem, len(items))
+	err = attributevalue.UnmarshalListOfMaps(items, &subscriptions)
+	if err != nil {
+		return nil, err
+	}
+
+	subscribers := make([]repo.RepoWithChatID, len(subscriptions))
+	for i, subscription := range subscriptions {
+		subscribers[i] = subscription.join(repository)
+	}
+
+	return subscribers, nil
 }
 
-func (db *Driver) UpdateEntry(repo repo.RepoWithChatID) error {
-	_, err := db.client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
-		Key: map[string]types.AttributeValue{
-			"chatID": &types.AttributeValueMemberS{Value: fmt.Sprint(repo.ChatID)},
-			"repoID": &types.AttributeValueMemberS{Value: repo.RepoID},
+func (db *Driver) UpdateRelease(ctx context.Context, repoID string, release repo.Release) error {
+	_, err := db.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		Key: repositoryKey(repoID),
+		UpdateExpression: aws.String("set currentReleaseID = :releaseID, currentReleaseTagName = :releaseTagName, " +
+			"stableReleaseID = :stableID, stableReleaseTagName = :stableTagName, " +
+			"prereleaseID = :preID, prereleaseTagName = :preTagName, latestTagName = :latestTagName, tagsDigest = :tagsDigest"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":releaseID":      &types.AttributeValueMemberS{Value: release.CurrentReleaseID},
+			":releaseTagName": &types.AttributeValueMemberS{Value: release.CurrentReleaseTagName},
+			":stableID":       &types.AttributeValueMemberS{Value: release.StableReleaseID},
+			":stableTagName":  &types.AttributeValueMemberS{Value: release.StableReleaseTagName},
+			":preID":          &types.AttributeValueMemberS{Value: release.PrereleaseID},
+			":preTagName":     &types.AttributeValueMemberS{Value: release.PrereleaseTagName},
+			":latestTagName":  &types.AttributeValueMemberS{Value: release.LatestTagName},
+			":tagsDigest":     &types.AttributeValueMemberS{Value: release.TagsDigest},
 		},
-		UpdateExpression: aws.String("set currentReleaseID = :releaseID, currentReleaseTagName = :releaseTagName"),
+		ConditionExpression: aws.String("attribute_exists(repoID)"),
+		TableName:           &db.repositoriesTableName,
+	})
+
+	return conditionFailedAs(err, errors.ErrRepoNotFound)
+}
+
+// CarriedOverRepos scans the repositories table for the repos marked with carriedOver.
+func (db *Driver) CarriedOverRepos(ctx context.Context) ([]string, error) {
+	repoIDs := []string{}
+
+	paginator := dynamodb.NewScanPaginator(db.client, &dynamodb.ScanInput{
+		TableName:            &db.repositoriesTableName,
+		FilterExpression:     aws.String("carriedOver = :carried"),
+		ProjectionExpression: aws.String("repoID"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":releaseID":      &types.AttributeValueMemberS{Value: repo.CurrentReleaseID},
-			":releaseTagName": &types.AttributeValueMemberS{Value: repo.CurrentReleaseTagName},
+			":carried": &types.AttributeValueMemberBOOL{Value: true},
 		},
-		TableName: &db.tableName,
 	})
 
-	return err
+	for paginator.HasMorePages() {
+		result, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		page := make([]repositoryItem, len(result.Items))
+		err = attributevalue.UnmarshalListOfMaps(result.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range page {
+			repoIDs = append(repoIDs, item.RepoID)
+		}
+	}
+
+	return repoIDs, nil
 }
 
-func (db *Driver) SetPreReleaseRetrieve(chatID, repoID string, newValue bool) error {
-	_, err := db.client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+func (db *Driver) SetCarriedOver(ctx context.Context, repoIDs []string, carried bool) error {
+	for _, repoID := range repoIDs {
+		_, err := db.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			Key:              repositoryKey(repoID),
+			UpdateExpression: aws.String("set carriedOver = :carried"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":carried": &types.AttributeValueMemberBOOL{Value: carried},
+			},
+			// the repo isn't watched anymore
+			ConditionExpression: aws.String("attribute_exists(repoID)"),
+			TableName:           &db.repositoriesTableName,
+		})
+
+		err = conditionFailedAs(err, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (db *Driver) SetPreReleaseRetrieve(ctx context.Context, chatID, repoID string, newValue bool) error {
+	_, err := db.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
 		Key: map[string]types.AttributeValue{
 			"chatID": &types.AttributeValueMemberS{Value: fmt.Sprint(chatID)},
 			"repoID": &types.AttributeValueMemberS{Value: repoID},
@@ -199,14 +638,73 @@ func (db *Driver) SetPreReleaseRetrieve(chatID, repoID string, newValue bool) er
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":newValuePreReleaseRetrieve": &types.AttributeValueMemberBOOL{Value: newValue},
 		},
-		TableName: &db.tableName,
+		ConditionExpression: aws.String("attribute_exists(repoID)"),
+		TableName:           &db.tableName,
 	})
 
-	return err
+	return conditionFailedAs(err, errors.ErrRepoNotFound)
+}
+
+func (db *Driver) SetTrackTags(ctx context.Context, chatID, repoID string, newValue bool) error {
+	_, err := db.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		Key:              subscriptionKey(chatID, repoID),
+		UpdateExpression: aws.String("set trackTags = :trackTags"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":trackTags": &types.AttributeValueMemberBOOL{Value: newValue},
+		},
+		ConditionExpression: aws.String("attribute_exists(repoID)"),
+		TableName:           &db.tableName,
+	})
+
+	return conditionFailedAs(err, errors.ErrRepoNotFound)
+}
+
+func (db *Driver) SetUpdateLevel(ctx context.Context, chatID, repoID string, level repo.UpdateLevel) error {
+	_, err := db.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		Key:              subscriptionKey(chatID, repoID),
+		UpdateExpression: aws.String("set updateLevel = :updateLevel"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":updateLevel": &types.AttributeValueMemberS{Value: string(level)},
+		},
+		ConditionExpression: aws.String("attribute_exists(repoID)"),
+		TableName:           &db.tableName,
+	})
+
+	return conditionFailedAs(err, errors.ErrRepoNotFound)
+}
+
+func (db *Driver) SetTagFilters(ctx context.Context, chatID, repoID, include, exclude string) error {
+	_, err := db.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		Key:              subscriptionKey(chatID, repoID),
+		UpdateExpression: aws.String("set includeTags = :include, excludeTags = :exclude, lastTagName = :empty"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":include": &types.AttributeValueMemberS{Value: include},
+			":exclude": &types.AttributeValueMemberS{Value: exclude},
+			":empty":   &types.AttributeValueMemberS{Value: ""},
+		},
+		ConditionExpression: aws.String("attribute_exists(repoID)"),
+		TableName:           &db.tableName,
+	})
+
+	return conditionFailedAs(err, errors.ErrRepoNotFound)
+}
+
+func (db *Driver) SetLastTag(ctx context.Context, chatID, repoID, tag string) error {
+	_, err := db.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		Key:              subscriptionKey(chatID, repoID),
+		UpdateExpression: aws.String("set lastTagName = :tag"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":tag": &types.AttributeValueMemberS{Value: tag},
+		},
+		ConditionExpression: aws.String("attribute_exists(repoID)"),
+		TableName:           &db.tableName,
+	})
+
+	return conditionFailedAs(err, errors.ErrRepoNotFound)
 }
 
-func (db *Driver) CheckExisting(chatID, repoID string) (bool, error) {
-	output, err := db.client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+func (db *Driver) CheckExisting(ctx context.Context, chatID, repoID string) (bool, error) {
+	output, err := db.client.GetItem(ctx, &dynamodb.GetItemInput{
 		TableName: &db.tableName,
 		Key: map[string]types.AttributeValue{
 			"chatID": &types.AttributeValueMemberS{Value: chatID},
@@ -223,3 +721,547 @@ func (db *Driver) CheckExisting(chatID, repoID string) (bool, error) {
 
 	return false, nil
 }
+
+type chatItem struct {
+	ChatID     string `dynamodbav:"chatID"`
+	Template   string `dynamodbav:"notificationTemplate,omitempty"`
+	Delivery   string `dynamodbav:"delivery,omitempty"`
+	DigestHour int    `dynamodbav:"digestHour,omitempty"`
+	TimeZone   string `dynamodbav:"timeZone,omitempty"`
+	QuietFrom  int    `dynamodbav:"quietFrom,omitempty"`
+	QuietUntil int    `dynamodbav:"quietUntil,omitempty"`
+	QuietMode  string `dynamodbav:"quietMode,omitempty"`
+}
+
+func chatKey(chatID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"chatID": &types.AttributeValueMemberS{Value: chatID},
+	}
+}
+
+func (db *Driver) GetChatSettings(ctx context.Context, chatID string) (repo.ChatSettings, error) {
+	output, err := db.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: &db.chatsTableName,
+		Key:       chatKey(chatID),
+	})
+	if err != nil {
+		return repo.ChatSettings{}, err
+	}
+
+	item := chatItem{ChatID: chatID}
+	if output.Item != nil {
+		err = attributevalue.UnmarshalMap(output.Item, &item)
+		if err != nil {
+			return repo.ChatSettings{}, err
+		}
+	}
+
+	return repo.ChatSettings{
+		ChatID:     item.ChatID,
+		Template:   item.Template,
+		Delivery:   repo.DeliveryMode(item.Delivery),
+		DigestHour: item.DigestHour,
+		TimeZone:   item.TimeZone,
+		QuietFrom:  item.QuietFrom,
+		QuietUntil: item.QuietUntil,
+		QuietMode:  repo.QuietMode(item.QuietMode),
+	}, nil
+}
+
+func (db *Driver) SetTemplate(ctx context.Context, chatID, template string) error {
+	_, err := db.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		Key:              chatKey(chatID),
+		UpdateExpression: aws.String("set notificationTemplate = :template"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":template": &types.AttributeValueMemberS{Value: template},
+		},
+		TableName: &db.chatsTableName,
+	})
+
+	return err
+}
+
+func (db *Driver) SetDelivery(ctx context.Context, chatID string, mode repo.DeliveryMode, digestHour int, timeZone string) error {
+	_, err := db.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		Key:              chatKey(chatID),
+		UpdateExpression: aws.String("set delivery = :delivery, digestHour = :hour, timeZone = :zone"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":delivery": &types.AttributeValueMemberS{Value: string(mode)},
+			":hour":     &types.AttributeValueMemberN{Value: strconv.Itoa(digestHour)},
+			":zone":     &types.AttributeValueMemberS{Value: timeZone},
+		},
+		TableName: &db.chatsTableName,
+	})
+
+	return err
+}
+
+func (db *Driver) SetQuietHours(ctx context.Context, chatID string, from, until int, mode repo.QuietMode) error {
+	_, err := db.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		Key:              chatKey(chatID),
+		UpdateExpression: aws.String("set quietFrom = :from, quietUntil = :until, quietMode = :mode"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":from":  &types.AttributeValueMemberN{Value: strconv.Itoa(from)},
+			":until": &types.AttributeValueMemberN{Value: strconv.Itoa(until)},
+			":mode":  &types.AttributeValueMemberS{Value: string(mode)},
+		},
+		TableName: &db.chatsTableName,
+	})
+
+	return err
+}
+
+func (db *Driver) SetTimeZone(ctx context.Context, chatID, timeZone string) error {
+	_, err := db.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		Key:              chatKey(chatID),
+		UpdateExpression: aws.String("set timeZone = :zone"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":zone": &types.AttributeValueMemberS{Value: timeZone},
+		},
+		TableName: &db.chatsTableName,
+	})
+
+	return err
+}
+
+// MoveChat copies every item of the chat over to the new chat ID before deleting it, one item at a time.
+// A move cut short is finished by calling it again.
+func (db *Driver) MoveChat(ctx context.Context, from, to string) error {
+	subscriptions, err := db.queryChat(ctx, db.tableName, from)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range subscriptions {
+		repoID := item["repoID"]
+		err = db.moveItem(ctx, db.tableName, item, to, map[string]types.AttributeValue{"chatID": item["chatID"], "repoID": repoID})
+		if err != nil {
+			return err
+		}
+
+		// the repository item may be gone if the chat was unsubscribed meanwhile
+		_, err = db.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:           &db.repositoriesTableName,
+			Key:                 map[string]types.AttributeValue{"repoID": repoID},
+			UpdateExpression:    aws.String("ADD subscribers :to DELETE subscribers :from"),
+			ConditionExpression: aws.String("attribute_exists(repoID)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":to":   &types.AttributeValueMemberSS{Value: []string{to}},
+				":from": &types.AttributeValueMemberSS{Value: []string{from}},
+			},
+		})
+		err = conditionFailedAs(err, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	output, err := db.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: &db.chatsTableName,
+		Key:       chatKey(from),
+	})
+	if err != nil {
+		return err
+	}
+	if output.Item != nil {
+		err = db.moveItem(ctx, db.chatsTableName, output.Item, to, chatKey(from))
+		if err != nil {
+			return err
+		}
+	}
+
+	pending, err := db.queryChat(ctx, db.pendingTableName, from)
+	if err != nil {
+		return err
+	}
+	for _, item := range pending {
+		key := map[string]types.AttributeValue{"chatID": item["chatID"], "notificationKey": item["notificationKey"]}
+		err = db.moveItem(ctx, db.pendingTableName, item, to, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	paginator := dynamodb.NewScanPaginator(db.client, &dynamodb.ScanInput{
+		TableName:            &db.outboxTableName,
+		FilterExpression:     aws.String("chatID = :from AND attribute_not_exists(deliveredAt)"),
+		ProjectionExpression: aws.String("idempotencyKey"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":from": &types.AttributeValueMemberS{Value: from},
+		},
+	})
+	for paginator.HasMorePages() {
+		result, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range result.Items {
+			_, err = db.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+				TableName:           &db.outboxTableName,
+				Key:                 map[string]types.AttributeValue{"idempotencyKey": item["idempotencyKey"]},
+				UpdateExpression:    aws.String("SET chatID = :to"),
+				ConditionExpression: aws.String("attribute_not_exists(deliveredAt)"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":to": &types.AttributeValueMemberS{Value: to},
+				},
+			})
+			// delivered while moving, nothing left to redirect
+			err = conditionFailedAs(err, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// queryChat reads every item of the chat from a table keyed by chatID first.
+func (db *Driver) queryChat(ctx context.Context, table, chatID string) ([]map[string]types.AttributeValue, error) {
+	paginator := dynamodb.NewQueryPaginator(db.client, &dynamodb.QueryInput{
+		TableName:              &table,
+		KeyConditionExpression: aws.String("chatID = :chatid"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":chatid": &types.AttributeValueMemberS{Value: chatID},
+		},
+	})
+
+	items := []map[string]types.AttributeValue{}
+	for paginator.HasMorePages() {
+		result, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, result.Items...)
+	}
+
+	return items, nil
+}
+
+// moveItem writes item under the chat ID to, unless the new chat has that item already, then deletes it at key.
+func (db *Driver) moveItem(ctx context.Context, table string, item map[string]types.AttributeValue, to string, key map[string]types.AttributeValue) error {
+	moved := maps.Clone(item)
+	moved["chatID"] = &types.AttributeValueMemberS{Value: to}
+
+	_, err := db.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           &table,
+		Item:                moved,
+		ConditionExpression: aws.String("attribute_not_exists(chatID)"),
+	})
+	err = conditionFailedAs(err, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: &table,
+		Key:       key,
+	})
+
+	return err
+}
+
+type pendingItem struct {
+	ChatID          string    `dynamodbav:"chatID"`
+	NotificationKey string    `dynamodbav:"notificationKey"`
+	RepoID          string    `dynamodbav:"repoID"`
+	TagName         string    `dynamodbav:"tagName"`
+	RepoName        string    `dynamodbav:"repoName"`
+	RepoLink        string    `dynamodbav:"repoLink"`
+	IsPrerelease    bool      `dynamodbav:"isPrerelease"`
+	IsTag           bool      `dynamodbav:"isTag"`
+	QueuedAt        time.Time `dynamodbav:"queuedAt"`
+}
+
+func pendingKey(chatID, repoID, tagName string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"chatID":          &types.AttributeValueMemberS{Value: chatID},
+		"notificationKey": &types.AttributeValueMemberS{Value: repoID + "/" + tagName},
+	}
+}
+
+func (db *Driver) QueueNotification(ctx context.Context, notification repo.PendingNotification) error {
+	item, err := attributevalue.MarshalMap(pendingItem{
+		ChatID:          notification.ChatID,
+		NotificationKey: notification.RepoID + "/" + notification.TagName,
+		RepoID:          notification.RepoID,
+		TagName:         notification.TagName,
+		RepoName:        notification.RepoName,
+		RepoLink:        notification.RepoLink,
+		IsPrerelease:    notification.IsPrerelease,
+		IsTag:           notification.IsTag,
+		QueuedAt:        notification.QueuedAt.UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.client.PutItem(ctx, &dynamodb.PutItemInput{
+		Item:                item,
+		TableName:           &db.pendingTableName,
+		ConditionExpression: aws.String("attribute_not_exists(chatID)"),
+	})
+
+	// already queued, the first one stays
+	return conditionFailedAs(err, nil)
+}
+
+// PendingNotifications scans the whole pending table, which only holds what the next digests are made of.
+func (db *Driver) PendingNotifications(ctx context.Context) ([]repo.PendingNotification, error) {
+	pending := []repo.PendingNotification{}
+
+	paginator := dynamodb.NewScanPaginator(db.client, &dynamodb.ScanInput{
+		TableName: &db.pendingTableName,
+	})
+	for paginator.HasMorePages() {
+		result, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		page := make([]pendingItem, len(result.Items))
+		err = attributevalue.UnmarshalListOfMaps(result.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range page {
+			pending = append(pending, repo.PendingNotification{
+				ChatID:       item.ChatID,
+				RepoID:       item.RepoID,
+				TagName:      item.TagName,
+				RepoName:     item.RepoName,
+				RepoLink:     item.RepoLink,
+				IsPrerelease: item.IsPrerelease,
+				IsTag:        item.IsTag,
+				QueuedAt:     item.QueuedAt,
+			})
+		}
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		a, b := pending[i], pending[j]
+		if a.ChatID != b.ChatID {
+			return a.ChatID < b.ChatID
+		}
+		if !a.QueuedAt.Equal(b.QueuedAt) {
+			return a.QueuedAt.Before(b.QueuedAt)
+		}
+		if a.RepoID != b.RepoID {
+			return a.RepoID < b.RepoID
+		}
+		return a.TagName < b.TagName
+	})
+
+	return pending, nil
+}
+
+func (db *Driver) RemovePending(ctx context.Context, chatID string, notifications []repo.PendingNotification) error {
+	for _, notification := range notifications {
+		_, err := db.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			Key:       pendingKey(chatID, notification.RepoID, notification.TagName),
+			TableName: &db.pendingTableName,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// outboxItem keeps its times as Unix nanoseconds, so that they can be compared in filter expressions.
+// DeliveredAt is missing until the message is delivered.
+type outboxItem struct {
+	Key           string `dynamodbav:"idempotencyKey"`
+	ChatID        string `dynamodbav:"chatID"`
+	RepoID        string `dynamodbav:"repoID"`
+	Text          string `dynamodbav:"text"`
+	ParseMode     string `dynamodbav:"parseMode,omitempty"`
+	Keyboard      string `dynamodbav:"keyboard,omitempty"`
+	PlainText     string `dynamodbav:"plainText,omitempty"`
+	Silent        bool   `dynamodbav:"silent"`
+	Attempts      int    `dynamodbav:"attempts"`
+	NextAttemptAt int64  `dynamodbav:"nextAttemptAt"`
+	LastError     string `dynamodbav:"lastError,omitempty"`
+	CreatedAt     int64  `dynamodbav:"createdAt"`
+	DeliveredAt   int64  `dynamodbav:"deliveredAt,omitempty"`
+}
+
+func outboxKey(key string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"idempotencyKey": &types.AttributeValueMemberS{Value: key},
+	}
+}
+
+func (db *Driver) EnqueueOutbox(ctx context.Context, message repo.OutboxMessage) (bool, error) {
+	item, err := attributevalue.MarshalMap(outboxItem{
+		Key:           message.Key,
+		ChatID:        message.ChatID,
+		RepoID:        message.RepoID,
+		Text:          message.Text,
+		ParseMode:     message.ParseMode,
+		Keyboard:      message.Keyboard,
+		PlainText:     message.PlainText,
+		Silent:        message.Silent,
+		Attempts:      message.Attempts,
+		NextAttemptAt: message.NextAttemptAt.UnixNano(),
+		LastError:     message.LastError,
+		CreatedAt:     message.CreatedAt.UnixNano(),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	_, err = db.client.PutItem(ctx, &dynamodb.PutItemInput{
+		Item:                item,
+		TableName:           &db.outboxTableName,
+		ConditionExpression: aws.String("attribute_not_exists(idempotencyKey)"),
+	})
+
+	var conditionFailed *types.ConditionalCheckFailedException
+	if stderrors.As(err, &conditionFailed) {
+		// the key was used before
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// DueOutbox scans the outbox, which only holds the messages of the last few weeks.
+func (db *Driver) DueOutbox(ctx context.Context, now time.Time) ([]repo.OutboxMessage, error) {
+	due := []repo.OutboxMessage{}
+
+	paginator := dynamodb.NewScanPaginator(db.client, &dynamodb.ScanInput{
+		TableName:        &db.outboxTableName,
+		FilterExpression: aws.String("attribute_not_exists(deliveredAt) AND nextAttemptAt <= :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.UnixNano(), 10)},
+		},
+	})
+	for paginator.HasMorePages() {
+		result, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		page := make([]outboxItem, len(result.Items))
+		err = attributevalue.UnmarshalListOfMaps(result.Items, &page)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range page {
+			due = append(due, repo.OutboxMessage{
+				Key:           item.Key,
+				ChatID:        item.ChatID,
+				RepoID:        item.RepoID,
+				Text:          item.Text,
+				ParseMode:     item.ParseMode,
+				Keyboard:      item.Keyboard,
+				PlainText:     item.PlainText,
+				Silent:        item.Silent,
+				Attempts:      item.Attempts,
+				NextAttemptAt: time.Unix(0, item.NextAttemptAt).UTC(),
+				LastError:     item.LastError,
+				CreatedAt:     time.Unix(0, item.CreatedAt).UTC(),
+			})
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].NextAttemptAt.Equal(due[j].NextAttemptAt) {
+			return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+		}
+		return due[i].Key < due[j].Key
+	})
+
+	return due, nil
+}
+
+func (db *Driver) RetryOutbox(ctx context.Context, key string, attempts int, nextAttemptAt time.Time, lastError string) error {
+	_, err := db.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		Key:                 outboxKey(key),
+		UpdateExpression:    aws.String("set attempts = :attempts, nextAttemptAt = :next, lastError = :error"),
+		ConditionExpression: aws.String("attribute_exists(idempotencyKey)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":attempts": &types.AttributeValueMemberN{Value: strconv.Itoa(attempts)},
+			":next":     &types.AttributeValueMemberN{Value: strconv.FormatInt(nextAttemptAt.UnixNano(), 10)},
+			":error":    &types.AttributeValueMemberS{Value: lastError},
+		},
+		TableName: &db.outboxTableName,
+	})
+
+	return conditionFailedAs(err, nil)
+}
+
+func (db *Driver) MarkDelivered(ctx context.Context, key string, deliveredAt time.Time) error {
+	_, err := db.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		Key:                 outboxKey(key),
+		UpdateExpression:    aws.String("set deliveredAt = :delivered"),
+		ConditionExpression: aws.String("attribute_exists(idempotencyKey)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":delivered": &types.AttributeValueMemberN{Value: strconv.FormatInt(deliveredAt.UnixNano(), 10)},
+		},
+		TableName: &db.outboxTableName,
+	})
+
+	return conditionFailedAs(err, nil)
+}
+
+func (db *Driver) PruneOutbox(ctx context.Context, before time.Time) error {
+	paginator := dynamodb.NewScanPaginator(db.client, &dynamodb.ScanInput{
+		TableName:            &db.outboxTableName,
+		ProjectionExpression: aws.String("idempotencyKey"),
+		FilterExpression:     aws.String("deliveredAt < :before"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":before": &types.AttributeValueMemberN{Value: strconv.FormatInt(before.UnixNano(), 10)},
+		},
+	})
+	for paginator.HasMorePages() {
+		result, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range result.Items {
+			_, err = db.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+				Key:       map[string]types.AttributeValue{"idempotencyKey": item["idempotencyKey"]},
+				TableName: &db.outboxTableName,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// conditionFailedAs replaces a failed ConditionExpression with the given error, so callers
+// don't have to know about DynamoDB exception types.
+func conditionFailedAs(err, replacement error) error {
+	var conditionFailed *types.ConditionalCheckFailedException
+	if stderrors.As(err, &conditionFailed) {
+		return replacement
+	}
+
+	return err
+}
+
+// transactionConditionFailedAs does what conditionFailedAs does for a cancelled TransactWriteItems call.
+func transactionConditionFailedAs(err, replacement error) error {
+	var cancelled *types.TransactionCanceledException
+	if !stderrors.As(err, &cancelled) {
+		return err
+	}
+
+	for _, reason := range cancelled.CancellationReasons {
+		if aws.ToString(reason.Code) == "ConditionalCheckFailed" {
+			return replacement
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,81 @@
+package dynamodb
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/chofnar/release-bot/internal/database"
+	"github.com/chofnar/release-bot/internal/database/databasetest"
+	"go.uber.org/zap"
+)
+
+// Runs against a local stand-in such as LocalStack or DynamoDB Local, e.g.
+// BOT_TEST_DYNAMODB_ENDPOINT=http://localhost:4566 AWS_ACCESS_KEY_ID=test AWS_SECRET_ACCESS_KEY=test go test ./...
+func TestConformance(t *testing.T) {
+	endpoint := os.Getenv("BOT_TEST_DYNAMODB_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("BOT_TEST_DYNAMODB_ENDPOINT not set")
+	}
+
+	databasetest.Run(t, func(t *testing.T) database.Database {
+		t.Setenv("BOT_DYNAMODB_ENDPOINT", endpoint)
+		suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+		t.Setenv("BOT_TABLE_NAME", "ReleasesBotTest"+suffix)
+		t.Setenv("BOT_REPOSITORIES_TABLE_NAME", "ReleasesBotRepositoriesTest"+suffix)
+		t.Setenv("BOT_CHATS_TABLE_NAME", "ReleasesBotChatsTest"+suffix)
+		t.Setenv("BOT_PENDING_TABLE_NAME", "ReleasesBotPendingTest"+suffix)
+		t.Setenv("BOT_OUTBOX_TABLE_NAME", "ReleasesBotOutboxTest"+suffix)
+
+		db := (&DriverFactory{}).Create(*zap.NewNop().Sugar())
+		driver := db.(*Driver)
+		createTable(t, driver, driver.tableName, "chatID", "repoID")
+		createTable(t, driver, driver.repositoriesTableName, "repoID", "")
+		createTable(t, driver, driver.chatsTableName, "chatID", "")
+		createTable(t, driver, driver.pendingTableName, "chatID", "notificationKey")
+		createTable(t, driver, driver.outboxTableName, "idempotencyKey", "")
+
+		return db
+	})
+}
+
+// createTable creates a table keyed by hashKey and, unless empty, rangeKey and drops it after the test.
+func createTable(t *testing.T, driver *Driver, name, hashKey, rangeKey string) {
+	t.Helper()
+	ctx := context.Background()
+
+	input := &dynamodb.CreateTableInput{
+		TableName: &name,
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String(hashKey), AttributeType: types.ScalarAttributeTypeS},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(hashKey), KeyType: types.KeyTypeHash},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	}
+	if rangeKey != "" {
+		input.AttributeDefinitions = append(input.AttributeDefinitions, types.AttributeDefinition{AttributeName: aws.String(rangeKey), AttributeType: types.ScalarAttributeTypeS})
+		input.KeySchema = append(input.KeySchema, types.KeySchemaElement{AttributeName: aws.String(rangeKey), KeyType: types.KeyTypeRange})
+	}
+
+	_, err := driver.client.CreateTable(ctx, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_, _ = driver.client.DeleteTable(ctx, &dynamodb.DeleteTableInput{TableName: &name})
+	})
+
+	waiter := dynamodb.NewTableExistsWaiter(driver.client)
+	err = waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: &name}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
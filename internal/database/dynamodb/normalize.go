@@ -0,0 +1,101 @@
+package dynamodb
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/chofnar/release-bot/internal/server/repo"
+)
+
+// legacyItem is a row of the single table layout, where every subscription carried its own copy of the repo.
+type legacyItem struct {
+	subscriptionItem
+	Name                  string `dynamodbav:"repoName"`
+	Owner                 string `dynamodbav:"repoOwner"`
+	Link                  string `dynamodbav:"repoLink"`
+	CurrentReleaseTagName string `dynamodbav:"currentReleaseTagName"`
+	CurrentReleaseID      string `dynamodbav:"currentReleaseID"`
+}
+
+// Normalize converts a subscriptions table still in the single table layout: the repo details of every
+// legacy item are moved into the repositories table, which must exist already, and stripped from the item.
+// Items that are already normalized are left alone, so it is safe to run again after an interruption.
+func (db *Driver) Normalize(ctx context.Context) error {
+	paginator := dynamodb.NewScanPaginator(db.client, &dynamodb.ScanInput{
+		TableName:        &db.tableName,
+		FilterExpression: aws.String("attribute_exists(repoName)"),
+	})
+
+	legacy := []legacyItem{}
+	for paginator.HasMorePages() {
+		result, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+
+		page := make([]legacyItem, len(result.Items))
+		err = attributevalue.UnmarshalListOfMaps(result.Items, &page)
+		if err != nil {
+			return err
+		}
+
+		legacy = append(legacy, page...)
+	}
+
+	// chats that get prereleases have seen the newest release, use theirs as the last seen one
+	repositories := map[string]legacyItem{}
+	subscribers := map[string][]string{}
+	for _, item := range legacy {
+		chosen, ok := repositories[item.RepoID]
+		if !ok || (item.ShouldPre && !chosen.ShouldPre) {
+			repositories[item.RepoID] = item
+		}
+		subscribers[item.RepoID] = append(subscribers[item.RepoID], item.ChatID)
+	}
+
+	// every repository is written before any item is stripped, an interrupted run still finds the details it needs
+	for repoID, item := range repositories {
+		err := db.upsertRepository(ctx, repo.Repo{
+			RepoID: repoID,
+			Name:   item.Name,
+			Owner:  item.Owner,
+			Link:   item.Link,
+			Release: repo.Release{
+				CurrentReleaseTagName: item.CurrentReleaseTagName,
+				CurrentReleaseID:      item.CurrentReleaseID,
+			},
+		}, subscribers[repoID])
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, item := range legacy {
+		_, err := db.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:        &db.tableName,
+			Key:              subscriptionKey(item.ChatID, item.RepoID),
+			UpdateExpression: aws.String("REMOVE repoName, repoOwner, repoLink, currentReleaseTagName, currentReleaseID"),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	db.logger.Infof("normalized %d subscriptions of %d repos", len(legacy), len(repositories))
+
+	return nil
+}
+
+func (db *Driver) upsertRepository(ctx context.Context, details repo.Repo, chatIDs []string) error {
+	upsert := db.repositoryUpsert(details, chatIDs)
+	_, err := db.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 upsert.TableName,
+		Key:                       upsert.Key,
+		UpdateExpression:          upsert.UpdateExpression,
+		ExpressionAttributeValues: upsert.ExpressionAttributeValues,
+	})
+
+	return err
+}
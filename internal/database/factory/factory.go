@@ -3,11 +3,17 @@ package factory
 import (
 	"github.com/chofnar/release-bot/internal/database"
 	"github.com/chofnar/release-bot/internal/database/dynamodb"
+	"github.com/chofnar/release-bot/internal/database/memory"
+	"github.com/chofnar/release-bot/internal/database/postgres"
+	"github.com/chofnar/release-bot/internal/database/sqlite"
 	"go.uber.org/zap"
 )
 
 var driverFactories = map[string]DriverFactory{
 	"dynamodb": &dynamodb.DriverFactory{},
+	"memory":   &memory.DriverFactory{},
+	"postgres": &postgres.DriverFactory{},
+	"sqlite":   &sqlite.DriverFactory{},
 }
 
 type DriverFactory interface {
@@ -1,15 +1,97 @@
 package database
 
 import (
+	"context"
+	"iter"
+	"time"
+
 	"github.com/chofnar/release-bot/internal/server/repo"
 )
 
+// Database stores one record per watched GitHub repo, holding its last seen release,
+// one record per (chat, repo) subscription, holding the chat's settings for it,
+// one record per chat that changed the settings applying to all of its repos,
+// and the queues of digests and of notifications on their way to Telegram.
+// Methods returning repo.Repo or repo.RepoWithChatID join the two.
 type Database interface {
-	GetRepos(chatID string) ([]repo.Repo, error)
-	AddRepo(chatID string, details *repo.Repo) error
-	RemoveRepo(chatID, repoID string) error
-	SetPreReleaseRetrieve(chatID, repoID string, newValue bool) error
-	AllRepos() ([]repo.RepoWithChatID, error)
-	UpdateEntry(repo repo.RepoWithChatID) error
-	CheckExisting(chatID, repoID string) (bool, error)
+	GetRepos(ctx context.Context, chatID string) ([]repo.Repo, error)
+	// AddRepo subscribes the chat, creating the repo record on its first subscription.
+	// An existing repo record keeps its last seen release. The chat's last seen tag starts at details.LatestTagName.
+	AddRepo(ctx context.Context, chatID string, details *repo.Repo) error
+	// RemoveRepo unsubscribes the chat, dropping the repo record once it has no subscribers left.
+	RemoveRepo(ctx context.Context, chatID, repoID string) error
+	SetPreReleaseRetrieve(ctx context.Context, chatID, repoID string, newValue bool) error
+	// SetTrackTags switches the chat between following the repo's releases and following its git tags.
+	SetTrackTags(ctx context.Context, chatID, repoID string, newValue bool) error
+	// SetUpdateLevel sets the smallest version bump the chat is notified about. New subscriptions start at repo.UpdateLevelAll.
+	SetUpdateLevel(ctx context.Context, chatID, repoID string, level repo.UpdateLevel) error
+	// SetTagFilters replaces both filters of the subscription, "" drops a filter. The chat's last seen tag is forgotten,
+	// it may not pass the new filters.
+	SetTagFilters(ctx context.Context, chatID, repoID, include, exclude string) error
+	// SetLastTag records the newest tag the chat, tracking tags, has heard of.
+	SetLastTag(ctx context.Context, chatID, repoID, tag string) error
+	AllRepos(ctx context.Context) ([]repo.RepoWithChatID, error)
+	// IterRepos yields every subscription as it is read from storage instead of loading the whole table first.
+	// Iteration stops after the first error. Writes are allowed while iterating.
+	IterRepos(ctx context.Context) iter.Seq2[repo.RepoWithChatID, error]
+	CheckExisting(ctx context.Context, chatID, repoID string) (bool, error)
+
+	// IterWatched yields every watched repo once, with the same guarantees as IterRepos.
+	IterWatched(ctx context.Context) iter.Seq2[repo.Repo, error]
+	GetSubscribers(ctx context.Context, repoID string) ([]repo.RepoWithChatID, error)
+	// UpdateRelease stores the last seen release of a watched repo.
+	UpdateRelease(ctx context.Context, repoID string, release repo.Release) error
+	// CarriedOverRepos returns the IDs of the watched repos an update run left out because the rate limit ran low.
+	CarriedOverRepos(ctx context.Context) ([]string, error)
+	// SetCarriedOver marks the watched repos as left out of an update run, or as checked again. Repos that aren't
+	// watched anymore are skipped.
+	SetCarriedOver(ctx context.Context, repoIDs []string, carried bool) error
+
+	// GetChatSettings returns the settings of the chat, the zero settings if it never changed any.
+	// They outlive the chat's subscriptions.
+	GetChatSettings(ctx context.Context, chatID string) (repo.ChatSettings, error)
+	// SetTemplate stores the template the chat's release notifications are rendered with, "" restores the default one.
+	SetTemplate(ctx context.Context, chatID, template string) error
+	// SetDelivery stores when the chat is told about new releases.
+	SetDelivery(ctx context.Context, chatID string, mode repo.DeliveryMode, digestHour int, timeZone string) error
+	// SetQuietHours stores the chat's quiet hours and what happens to releases found during them.
+	SetQuietHours(ctx context.Context, chatID string, from, until int, mode repo.QuietMode) error
+	// SetTimeZone stores the time zone the chat's digest and quiet hours are in.
+	SetTimeZone(ctx context.Context, chatID, timeZone string) error
+	// MoveChat hands the subscriptions, settings, queued notifications and undelivered outbox messages of a chat over
+	// to the chat it goes on as, like a group upgraded to a supergroup. Whatever the new chat has already is kept.
+	MoveChat(ctx context.Context, from, to string) error
+
+	// QueueNotification adds a release to the queue of the chat's next digest, or of the end of its quiet hours. Queueing the same repo and tag
+	// again keeps the first one.
+	QueueNotification(ctx context.Context, notification repo.PendingNotification) error
+	// PendingNotifications returns the whole queue, sorted by chat and then by when they were queued.
+	PendingNotifications(ctx context.Context) ([]repo.PendingNotification, error)
+	// RemovePending takes the given notifications of a chat out of the queue, those not queued are skipped.
+	RemovePending(ctx context.Context, chatID string, notifications []repo.PendingNotification) error
+
+	// EnqueueOutbox adds a message to the outbox, unless a message with the same key was ever added
+	// and not pruned since. It tells whether the message was added.
+	EnqueueOutbox(ctx context.Context, message repo.OutboxMessage) (bool, error)
+	// DueOutbox returns the messages not delivered yet whose next attempt is due at now, the longest due first.
+	DueOutbox(ctx context.Context, now time.Time) ([]repo.OutboxMessage, error)
+	// RetryOutbox records a failed attempt at delivering a message and when the next one is due.
+	RetryOutbox(ctx context.Context, key string, attempts int, nextAttemptAt time.Time, lastError string) error
+	// MarkDelivered takes a message out of delivery. Its key is kept until it is pruned.
+	MarkDelivered(ctx context.Context, key string, deliveredAt time.Time) error
+	// PruneOutbox drops the messages delivered before before.
+	PruneOutbox(ctx context.Context, before time.Time) error
+}
+
+// CollectRepos drains an IterRepos sequence into a slice.
+func CollectRepos(seq iter.Seq2[repo.RepoWithChatID, error]) ([]repo.RepoWithChatID, error) {
+	repos := []repo.RepoWithChatID{}
+	for r, err := range seq {
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, r)
+	}
+
+	return repos, nil
 }
@@ -1,11 +1,20 @@
 package loader
 
 import (
+	"os"
+
 	"github.com/chofnar/release-bot/internal/database"
 	"github.com/chofnar/release-bot/internal/database/factory"
 	"go.uber.org/zap"
 )
 
+const defaultDriver = "dynamodb"
+
 func GetDatabase(logger zap.SugaredLogger) database.Database {
-	return factory.Create("dynamodb", logger)
+	driver := defaultDriver
+	if value := os.Getenv("BOT_DATABASE"); value != "" {
+		driver = value
+	}
+
+	return factory.Create(driver, logger)
 }
@@ -0,0 +1,571 @@
+package memory
+
+import (
+	"context"
+	"iter"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/chofnar/release-bot/internal/database"
+	"github.com/chofnar/release-bot/internal/errors"
+	"github.com/chofnar/release-bot/internal/server/repo"
+	"go.uber.org/zap"
+)
+
+// Driver keeps everything in process memory. Nothing survives a restart,
+// so it is meant for tests and local development.
+type Driver struct {
+	mu sync.RWMutex
+	// repositories by repo ID, the subscription settings are unused
+	repositories map[string]repo.Repo
+	// subscriptions by chat ID, then repo ID
+	subscriptions map[string]map[string]subscription
+	// chats by chat ID
+	chats map[string]repo.ChatSettings
+	// pending notifications by chat ID, then repo ID and tag
+	pending map[string]map[pendingKey]repo.PendingNotification
+	// outbox by key
+	outbox map[string]repo.OutboxMessage
+	// carriedOver holds the IDs of the repos left out of an update run
+	carriedOver map[string]struct{}
+	logger      zap.SugaredLogger
+}
+
+type pendingKey struct {
+	repoID, tagName string
+}
+
+type subscription struct {
+	ShouldNotifyPrerelease bool
+	TrackTags              bool
+	UpdateLevel            repo.UpdateLevel
+	IncludeTags            string
+	ExcludeTags            string
+	LastTagName            string
+}
+
+type DriverFactory struct{}
+
+func (factory *DriverFactory) Create(logger zap.SugaredLogger) database.Database {
+	return New(logger)
+}
+
+func New(logger zap.SugaredLogger) *Driver {
+	return &Driver{
+		repositories:  map[string]repo.Repo{},
+		subscriptions: map[string]map[string]subscription{},
+		chats:         map[string]repo.ChatSettings{},
+		pending:       map[string]map[pendingKey]repo.PendingNotification{},
+		outbox:        map[string]repo.OutboxMessage{},
+		carriedOver:   map[string]struct{}{},
+		logger:        logger,
+	}
+}
+
+// join must be called with the lock held.
+func (db *Driver) join(chatID, repoID string) repo.RepoWithChatID {
+	joined := repo.RepoWithChatID{
+		Repo:   db.repositories[repoID],
+		ChatID: chatID,
+	}
+	settings := db.subscriptions[chatID][repoID]
+	joined.ShouldNotifyPrerelease = settings.ShouldNotifyPrerelease
+	joined.TrackTags = settings.TrackTags
+	joined.UpdateLevel = settings.UpdateLevel
+	joined.IncludeTags, joined.ExcludeTags = settings.IncludeTags, settings.ExcludeTags
+	joined.LastTagName = settings.LastTagName
+
+	return joined
+}
+
+func (db *Driver) GetRepos(ctx context.Context, chatID string) ([]repo.Repo, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	repos := make([]repo.Repo, 0, len(db.subscriptions[chatID]))
+	for repoID := range db.subscriptions[chatID] {
+		repos = append(repos, db.join(chatID, repoID).Repo)
+	}
+
+	sort.Slice(repos, func(i, j int) bool {
+		return repos[i].RepoID < repos[j].RepoID
+	})
+
+	return repos, nil
+}
+
+func (db *Driver) AddRepo(ctx context.Context, chatID string, details *repo.Repo) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	chatSubscriptions, ok := db.subscriptions[chatID]
+	if !ok {
+		chatSubscriptions = map[string]subscription{}
+		db.subscriptions[chatID] = chatSubscriptions
+	}
+
+	if _, exists := chatSubscriptions[details.RepoID]; exists {
+		return errors.ErrRepoExists
+	}
+
+	repository, exists := db.repositories[details.RepoID]
+	if !exists {
+		repository = repo.Repo{
+			RepoID:  details.RepoID,
+			Release: details.Release,
+		}
+		// not stored by the other drivers either
+		repository.IsPrerelease = false
+	}
+	repository.Name, repository.Owner, repository.Link = details.Name, details.Owner, details.Link
+	db.repositories[details.RepoID] = repository
+
+	chatSubscriptions[details.RepoID] = subscription{UpdateLevel: repo.UpdateLevelAll, LastTagName: details.LatestTagName}
+
+	return nil
+}
+
+func (db *Driver) RemoveRepo(ctx context.Context, chatID, repoID string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	delete(db.subscriptions[chatID], repoID)
+	if len(db.subscriptions[chatID]) == 0 {
+		delete(db.subscriptions, chatID)
+	}
+
+	for _, chatSubscriptions := range db.subscriptions {
+		if _, ok := chatSubscriptions[repoID]; ok {
+			return nil
+		}
+	}
+	delete(db.repositories, repoID)
+	delete(db.carriedOver, repoID)
+
+	return nil
+}
+
+func (db *Driver) AllRepos(ctx context.Context) ([]repo.RepoWithChatID, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	repos := []repo.RepoWithChatID{}
+	for chatID, chatSubscriptions := range db.subscriptions {
+		for repoID := range chatSubscriptions {
+			repos = append(repos, db.join(chatID, repoID))
+		}
+	}
+
+	return repos, nil
+}
+
+// IterRepos walks a snapshot, the lock can't be held while yielding since the caller may write.
+func (db *Driver) IterRepos(ctx context.Context) iter.Seq2[repo.RepoWithChatID, error] {
+	return func(yield func(repo.RepoWithChatID, error) bool) {
+		repos, _ := db.AllRepos(ctx)
+		for _, r := range repos {
+			if !yield(r, nil) {
+				return
+			}
+		}
+	}
+}
+
+// IterWatched walks a snapshot, like IterRepos.
+func (db *Driver) IterWatched(ctx context.Context) iter.Seq2[repo.Repo, error] {
+	return func(yield func(repo.Repo, error) bool) {
+		db.mu.RLock()
+		repos := make([]repo.Repo, 0, len(db.repositories))
+		for _, r := range db.repositories {
+			repos = append(repos, r)
+		}
+		db.mu.RUnlock()
+
+		for _, r := range repos {
+			if !yield(r, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (db *Driver) GetSubscribers(ctx context.Context, repoID string) ([]repo.RepoWithChatID, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	subscribers := []repo.RepoWithChatID{}
+	for chatID, chatSubscriptions := range db.subscriptions {
+		if _, ok := chatSubscriptions[repoID]; ok {
+			subscribers = append(subscribers, db.join(chatID, repoID))
+		}
+	}
+
+	sort.Slice(subscribers, func(i, j int) bool {
+		return subscribers[i].ChatID < subscribers[j].ChatID
+	})
+
+	return subscribers, nil
+}
+
+func (db *Driver) UpdateRelease(ctx context.Context, repoID string, release repo.Release) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	stored, ok := db.repositories[repoID]
+	if !ok {
+		return errors.ErrRepoNotFound
+	}
+
+	stored.Release = release
+	stored.IsPrerelease = false
+	db.repositories[repoID] = stored
+
+	return nil
+}
+
+func (db *Driver) CarriedOverRepos(ctx context.Context) ([]string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	repoIDs := []string{}
+	for repoID := range db.carriedOver {
+		repoIDs = append(repoIDs, repoID)
+	}
+
+	return repoIDs, nil
+}
+
+func (db *Driver) SetCarriedOver(ctx context.Context, repoIDs []string, carried bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, repoID := range repoIDs {
+		_, watched := db.repositories[repoID]
+		if carried && watched {
+			db.carriedOver[repoID] = struct{}{}
+		} else {
+			delete(db.carriedOver, repoID)
+		}
+	}
+
+	return nil
+}
+
+func (db *Driver) SetPreReleaseRetrieve(ctx context.Context, chatID, repoID string, newValue bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	stored, ok := db.subscriptions[chatID][repoID]
+	if !ok {
+		return errors.ErrRepoNotFound
+	}
+
+	stored.ShouldNotifyPrerelease = newValue
+	db.subscriptions[chatID][repoID] = stored
+
+	return nil
+}
+
+func (db *Driver) SetTrackTags(ctx context.Context, chatID, repoID string, newValue bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	stored, ok := db.subscriptions[chatID][repoID]
+	if !ok {
+		return errors.ErrRepoNotFound
+	}
+
+	stored.TrackTags = newValue
+	db.subscriptions[chatID][repoID] = stored
+
+	return nil
+}
+
+func (db *Driver) SetUpdateLevel(ctx context.Context, chatID, repoID string, level repo.UpdateLevel) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	stored, ok := db.subscriptions[chatID][repoID]
+	if !ok {
+		return errors.ErrRepoNotFound
+	}
+
+	stored.UpdateLevel = level
+	db.subscriptions[chatID][repoID] = stored
+
+	return nil
+}
+
+func (db *Driver) SetTagFilters(ctx context.Context, chatID, repoID, include, exclude string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	stored, ok := db.subscriptions[chatID][repoID]
+	if !ok {
+		return errors.ErrRepoNotFound
+	}
+
+	stored.IncludeTags, stored.ExcludeTags = include, exclude
+	stored.LastTagName = ""
+	db.subscriptions[chatID][repoID] = stored
+
+	return nil
+}
+
+func (db *Driver) SetLastTag(ctx context.Context, chatID, repoID, tag string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	stored, ok := db.subscriptions[chatID][repoID]
+	if !ok {
+		return errors.ErrRepoNotFound
+	}
+
+	stored.LastTagName = tag
+	db.subscriptions[chatID][repoID] = stored
+
+	return nil
+}
+
+func (db *Driver) CheckExisting(ctx context.Context, chatID, repoID string) (bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	_, ok := db.subscriptions[chatID][repoID]
+	return ok, nil
+}
+
+func (db *Driver) GetChatSettings(ctx context.Context, chatID string) (repo.ChatSettings, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	settings := db.chats[chatID]
+	settings.ChatID = chatID
+
+	return settings, nil
+}
+
+func (db *Driver) SetTemplate(ctx context.Context, chatID, template string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	settings := db.chats[chatID]
+	settings.ChatID, settings.Template = chatID, template
+	db.chats[chatID] = settings
+
+	return nil
+}
+
+func (db *Driver) SetDelivery(ctx context.Context, chatID string, mode repo.DeliveryMode, digestHour int, timeZone string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	settings := db.chats[chatID]
+	settings.ChatID = chatID
+	settings.Delivery, settings.DigestHour, settings.TimeZone = mode, digestHour, timeZone
+	db.chats[chatID] = settings
+
+	return nil
+}
+
+func (db *Driver) SetQuietHours(ctx context.Context, chatID string, from, until int, mode repo.QuietMode) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	settings := db.chats[chatID]
+	settings.ChatID = chatID
+	settings.QuietFrom, settings.QuietUntil, settings.QuietMode = from, until, mode
+	db.chats[chatID] = settings
+
+	return nil
+}
+
+func (db *Driver) SetTimeZone(ctx context.Context, chatID, timeZone string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	settings := db.chats[chatID]
+	settings.ChatID, settings.TimeZone = chatID, timeZone
+	db.chats[chatID] = settings
+
+	return nil
+}
+
+func (db *Driver) MoveChat(ctx context.Context, from, to string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for repoID, settings := range db.subscriptions[from] {
+		if _, ok := db.subscriptions[to][repoID]; ok {
+			continue
+		}
+		if db.subscriptions[to] == nil {
+			db.subscriptions[to] = map[string]subscription{}
+		}
+		db.subscriptions[to][repoID] = settings
+	}
+	delete(db.subscriptions, from)
+
+	if settings, ok := db.chats[from]; ok {
+		if _, ok := db.chats[to]; !ok {
+			settings.ChatID = to
+			db.chats[to] = settings
+		}
+		delete(db.chats, from)
+	}
+
+	for key, notification := range db.pending[from] {
+		if _, ok := db.pending[to][key]; ok {
+			continue
+		}
+		if db.pending[to] == nil {
+			db.pending[to] = map[pendingKey]repo.PendingNotification{}
+		}
+		notification.ChatID = to
+		db.pending[to][key] = notification
+	}
+	delete(db.pending, from)
+
+	for key, message := range db.outbox {
+		if message.ChatID == from && message.DeliveredAt.IsZero() {
+			message.ChatID = to
+			db.outbox[key] = message
+		}
+	}
+
+	return nil
+}
+
+func (db *Driver) QueueNotification(ctx context.Context, notification repo.PendingNotification) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	chatPending, ok := db.pending[notification.ChatID]
+	if !ok {
+		chatPending = map[pendingKey]repo.PendingNotification{}
+		db.pending[notification.ChatID] = chatPending
+	}
+
+	key := pendingKey{notification.RepoID, notification.TagName}
+	if _, ok := chatPending[key]; !ok {
+		chatPending[key] = notification
+	}
+
+	return nil
+}
+
+func (db *Driver) PendingNotifications(ctx context.Context) ([]repo.PendingNotification, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	pending := []repo.PendingNotification{}
+	for _, chatPending := range db.pending {
+		for _, notification := range chatPending {
+			pending = append(pending, notification)
+		}
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		a, b := pending[i], pending[j]
+		if a.ChatID != b.ChatID {
+			return a.ChatID < b.ChatID
+		}
+		if !a.QueuedAt.Equal(b.QueuedAt) {
+			return a.QueuedAt.Before(b.QueuedAt)
+		}
+		if a.RepoID != b.RepoID {
+			return a.RepoID < b.RepoID
+		}
+		return a.TagName < b.TagName
+	})
+
+	return pending, nil
+}
+
+func (db *Driver) RemovePending(ctx context.Context, chatID string, notifications []repo.PendingNotification) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, notification := range notifications {
+		delete(db.pending[chatID], pendingKey{notification.RepoID, notification.TagName})
+	}
+	if len(db.pending[chatID]) == 0 {
+		delete(db.pending, chatID)
+	}
+
+	return nil
+}
+
+func (db *Driver) EnqueueOutbox(ctx context.Context, message repo.OutboxMessage) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.outbox[message.Key]; ok {
+		return false, nil
+	}
+	db.outbox[message.Key] = message
+
+	return true, nil
+}
+
+func (db *Driver) DueOutbox(ctx context.Context, now time.Time) ([]repo.OutboxMessage, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	due := []repo.OutboxMessage{}
+	for _, message := range db.outbox {
+		if message.DeliveredAt.IsZero() && !message.NextAttemptAt.After(now) {
+			due = append(due, message)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].NextAttemptAt.Equal(due[j].NextAttemptAt) {
+			return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+		}
+		return due[i].Key < due[j].Key
+	})
+
+	return due, nil
+}
+
+func (db *Driver) RetryOutbox(ctx context.Context, key string, attempts int, nextAttemptAt time.Time, lastError string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	message, ok := db.outbox[key]
+	if !ok {
+		return nil
+	}
+	message.Attempts, message.NextAttemptAt, message.LastError = attempts, nextAttemptAt, lastError
+	db.outbox[key] = message
+
+	return nil
+}
+
+func (db *Driver) MarkDelivered(ctx context.Context, key string, deliveredAt time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	message, ok := db.outbox[key]
+	if !ok {
+		return nil
+	}
+	message.DeliveredAt = deliveredAt
+	db.outbox[key] = message
+
+	return nil
+}
+
+func (db *Driver) PruneOutbox(ctx context.Context, before time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for key, message := range db.outbox {
+		if !message.DeliveredAt.IsZero() && message.DeliveredAt.Before(before) {
+			delete(db.outbox, key)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,15 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/chofnar/release-bot/internal/database"
+	"github.com/chofnar/release-bot/internal/database/databasetest"
+	"go.uber.org/zap"
+)
+
+func TestConformance(t *testing.T) {
+	databasetest.Run(t, func(t *testing.T) database.Database {
+		return New(*zap.NewNop().Sugar())
+	})
+}
@@ -0,0 +1,101 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"go.uber.org/zap"
+)
+
+// Migration is a single schema change. Versions must be unique and are applied in ascending order,
+// a migration that has been applied once is never run again, so never edit one that has been released.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+}
+
+const createVersionTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version    INTEGER PRIMARY KEY,
+	name       TEXT    NOT NULL
+)`
+
+// Apply brings the schema of db up to date. Every pending migration runs in its own transaction
+// together with the bookkeeping row, so a failed migration leaves the schema at the previous version.
+func Apply(db *sql.DB, migrations []Migration, logger zap.SugaredLogger) error {
+	_, err := db.Exec(createVersionTable)
+	if err != nil {
+		return err
+	}
+
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return err
+	}
+
+	pending := make([]Migration, 0, len(migrations))
+	for _, migration := range migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].Version < pending[j].Version
+	})
+
+	for _, migration := range pending {
+		err = apply(db, migration)
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+		logger.Infof("applied migration %d (%s)", migration.Version, migration.Name)
+	}
+
+	return nil
+}
+
+func appliedVersions(db *sql.DB) (map[int]struct{}, error) {
+	rows, err := db.Query(`SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]struct{}{}
+	for rows.Next() {
+		var version int
+		err = rows.Scan(&version)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = struct{}{}
+	}
+
+	return applied, rows.Err()
+}
+
+func apply(db *sql.DB, migration Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	_, err = tx.Exec(migration.Up)
+	if err != nil {
+		return err
+	}
+
+	// $1 placeholders are understood by both the postgres and the sqlite driver
+	_, err = tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,37 @@
+package postgres
+
+import (
+	"os"
+	"testing"
+
+	"github.com/chofnar/release-bot/internal/database"
+	"github.com/chofnar/release-bot/internal/database/databasetest"
+	"go.uber.org/zap"
+)
+
+// The suite wipes every table of the bot before each test, point BOT_TEST_POSTGRES_DSN at a throwaway database.
+func TestConformance(t *testing.T) {
+	dsn := os.Getenv("BOT_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("BOT_TEST_POSTGRES_DSN not set")
+	}
+
+	databasetest.Run(t, func(t *testing.T) database.Database {
+		t.Setenv("BOT_POSTGRES_DSN", dsn)
+
+		db := (&DriverFactory{}).Create(*zap.NewNop().Sugar())
+		if db == nil {
+			t.Fatal("could not create postgres driver")
+		}
+
+		driver := db.(*Driver)
+		if _, err := driver.db.Exec(`TRUNCATE subscriptions, repositories, chats, pending_notifications, outbox`); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = driver.db.Close()
+		})
+
+		return db
+	})
+}
@@ -0,0 +1,156 @@
+package sqlite
+
+import "github.com/chofnar/release-bot/internal/database/migrations"
+
+// schemaMigrations is append only, add a new entry for every schema change.
+// The first one is idempotent so databases created before migrations existed are picked up as is.
+var schemaMigrations = []migrations.Migration{
+	{
+		Version: 1,
+		Name:    "create repos",
+		Up: `
+CREATE TABLE IF NOT EXISTS repos (
+	chat_id                  TEXT    NOT NULL,
+	repo_id                  TEXT    NOT NULL,
+	repo_name                TEXT    NOT NULL,
+	repo_owner               TEXT    NOT NULL,
+	repo_link                TEXT    NOT NULL,
+	current_release_tag_name TEXT    NOT NULL DEFAULT '',
+	current_release_id       TEXT    NOT NULL DEFAULT '',
+	should_pre               INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (chat_id, repo_id)
+);`,
+	},
+	{
+		Version: 2,
+		Name:    "split repos into repositories and subscriptions",
+		Up: `
+CREATE TABLE repositories (
+	repo_id                  TEXT NOT NULL PRIMARY KEY,
+	repo_name                TEXT NOT NULL,
+	repo_owner               TEXT NOT NULL,
+	repo_link                TEXT NOT NULL,
+	current_release_tag_name TEXT NOT NULL DEFAULT '',
+	current_release_id       TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE subscriptions (
+	chat_id    TEXT    NOT NULL,
+	repo_id    TEXT    NOT NULL,
+	should_pre INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (chat_id, repo_id)
+);
+
+CREATE INDEX subscriptions_repo_id ON subscriptions (repo_id);
+
+-- chats that get prereleases have seen the newest release, use theirs as the last seen one
+INSERT INTO repositories (repo_id, repo_name, repo_owner, repo_link, current_release_tag_name, current_release_id)
+SELECT repo_id, repo_name, repo_owner, repo_link, current_release_tag_name, current_release_id
+FROM (
+	SELECT *, ROW_NUMBER() OVER (PARTITION BY repo_id ORDER BY should_pre DESC, chat_id) AS position
+	FROM repos
+)
+WHERE position = 1;
+
+INSERT INTO subscriptions (chat_id, repo_id, should_pre)
+SELECT chat_id, repo_id, should_pre FROM repos;
+
+DROP TABLE repos;`,
+	},
+	{
+		Version: 3,
+		Name:    "track stable releases and prereleases separately",
+		Up: `
+ALTER TABLE repositories ADD COLUMN stable_release_tag_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE repositories ADD COLUMN stable_release_id       TEXT NOT NULL DEFAULT '';
+ALTER TABLE repositories ADD COLUMN prerelease_tag_name     TEXT NOT NULL DEFAULT '';
+ALTER TABLE repositories ADD COLUMN prerelease_id           TEXT NOT NULL DEFAULT '';`,
+	},
+	{
+		Version: 4,
+		Name:    "track git tags",
+		Up: `
+ALTER TABLE repositories ADD COLUMN latest_tag_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE subscriptions ADD COLUMN track_tags INTEGER NOT NULL DEFAULT 0;`,
+	},
+	{
+		Version: 5,
+		Name:    "notify per update level",
+		Up: `
+ALTER TABLE subscriptions ADD COLUMN update_level TEXT NOT NULL DEFAULT 'all';`,
+	},
+	{
+		Version: 6,
+		Name:    "filter tags per subscription",
+		Up: `
+ALTER TABLE repositories ADD COLUMN tags_digest TEXT NOT NULL DEFAULT '';
+ALTER TABLE subscriptions ADD COLUMN include_tags  TEXT NOT NULL DEFAULT '';
+ALTER TABLE subscriptions ADD COLUMN exclude_tags  TEXT NOT NULL DEFAULT '';
+ALTER TABLE subscriptions ADD COLUMN last_tag_name TEXT NOT NULL DEFAULT '';`,
+	},
+	{
+		Version: 7,
+		Name:    "chat settings",
+		Up: `
+CREATE TABLE chats (
+	chat_id  TEXT PRIMARY KEY,
+	template TEXT NOT NULL DEFAULT ''
+);`,
+	},
+	{
+		Version: 8,
+		Name:    "digests",
+		Up: `
+ALTER TABLE chats ADD COLUMN delivery    TEXT    NOT NULL DEFAULT '';
+ALTER TABLE chats ADD COLUMN digest_hour INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE chats ADD COLUMN time_zone   TEXT    NOT NULL DEFAULT '';
+
+CREATE TABLE pending_notifications (
+	chat_id       TEXT NOT NULL,
+	repo_id       TEXT NOT NULL,
+	tag_name      TEXT NOT NULL,
+	repo_name     TEXT NOT NULL,
+	repo_link     TEXT NOT NULL,
+	is_prerelease INTEGER NOT NULL DEFAULT 0,
+	is_tag        INTEGER NOT NULL DEFAULT 0,
+	queued_at     TIMESTAMP NOT NULL,
+	PRIMARY KEY (chat_id, repo_id, tag_name)
+);`,
+	},
+	{
+		Version: 9,
+		Name:    "quiet hours",
+		Up: `
+ALTER TABLE chats ADD COLUMN quiet_from  INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE chats ADD COLUMN quiet_until INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE chats ADD COLUMN quiet_mode  TEXT    NOT NULL DEFAULT '';`,
+	},
+	{
+		Version: 10,
+		Name:    "outbox",
+		Up: `
+CREATE TABLE outbox (
+	idempotency_key TEXT PRIMARY KEY,
+	chat_id         TEXT NOT NULL,
+	repo_id         TEXT NOT NULL,
+	text            TEXT NOT NULL,
+	parse_mode      TEXT NOT NULL DEFAULT '',
+	keyboard        TEXT NOT NULL DEFAULT '',
+	plain_text      TEXT NOT NULL DEFAULT '',
+	silent          INTEGER NOT NULL DEFAULT 0,
+	attempts        INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP NOT NULL,
+	last_error      TEXT NOT NULL DEFAULT '',
+	created_at      TIMESTAMP NOT NULL,
+	delivered_at    TIMESTAMP
+);
+
+CREATE INDEX outbox_due ON outbox (next_attempt_at) WHERE delivered_at IS NULL;`,
+	},
+	{
+		Version: 11,
+		Name:    "carried over repos",
+		Up: `
+ALTER TABLE repositories ADD COLUMN carried_over INTEGER NOT NULL DEFAULT 0;`,
+	},
+}
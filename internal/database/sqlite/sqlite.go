@@ -0,0 +1,600 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"iter"
+	"os"
+	"time"
+
+	"github.com/chofnar/release-bot/internal/database"
+	"github.com/chofnar/release-bot/internal/database/migrations"
+	"github.com/chofnar/release-bot/internal/errors"
+	"github.com/chofnar/release-bot/internal/server/repo"
+	_ "github.com/mattn/go-sqlite3"
+	"go.uber.org/zap"
+)
+
+type Driver struct {
+	db     *sql.DB
+	logger zap.SugaredLogger
+}
+
+type DriverFactory struct{}
+
+const reposPageSize = 500
+
+type sqliteParams struct {
+	path string
+}
+
+const (
+	defaultPath = "release-bot.db"
+)
+
+// subscriptionColumns is what scanSubscription expects, selected from subscriptions s joined with repositories r.
+const subscriptionColumns = `s.chat_id, r.repo_id, r.repo_name, r.repo_owner, r.repo_link, r.current_release_tag_name, r.current_release_id,
+	r.stable_release_tag_name, r.stable_release_id, r.prerelease_tag_name, r.prerelease_id, r.latest_tag_name, r.tags_digest,
+	s.should_pre, s.track_tags, s.update_level, s.include_tags, s.exclude_tags, s.last_tag_name`
+
+// repositoryColumns is what scanRepository expects, selected from repositories.
+const repositoryColumns = `repo_id, repo_name, repo_owner, repo_link, current_release_tag_name, current_release_id,
+	stable_release_tag_name, stable_release_id, prerelease_tag_name, prerelease_id, latest_tag_name, tags_digest`
+
+func (params *sqliteParams) fillDefaults() {
+	params.path = defaultPath
+}
+
+func loadConfig() sqliteParams {
+	var params sqliteParams
+	params.fillDefaults()
+
+	if value := os.Getenv("BOT_SQLITE_PATH"); value != "" {
+		params.path = value
+	}
+
+	return params
+}
+
+func (factory *DriverFactory) Create(logger zap.SugaredLogger) database.Database {
+	params := loadConfig()
+
+	db, err := sql.Open("sqlite3", params.path)
+	if err != nil {
+		logger.Error(err)
+		return nil
+	}
+
+	// sqlite only allows a single writer, let database/sql serialize access
+	db.SetMaxOpenConns(1)
+
+	err = migrations.Apply(db, schemaMigrations, logger)
+	if err != nil {
+		logger.Error(err)
+		return nil
+	}
+
+	return &Driver{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// withTx runs fn in a transaction, committing if fn succeeds and rolling back otherwise.
+func (db *Driver) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSubscription(row scanner) (repo.RepoWithChatID, error) {
+	var r repo.RepoWithChatID
+	err := row.Scan(&r.ChatID, &r.RepoID, &r.Name, &r.Owner, &r.Link, &r.CurrentReleaseTagName, &r.CurrentReleaseID,
+		&r.StableReleaseTagName, &r.StableReleaseID, &r.PrereleaseTagName, &r.PrereleaseID, &r.LatestTagName, &r.TagsDigest,
+		&r.ShouldNotifyPrerelease, &r.TrackTags, &r.UpdateLevel, &r.IncludeTags, &r.ExcludeTags, &r.LastTagName)
+	return r, err
+}
+
+func scanRepository(row scanner) (repo.Repo, error) {
+	var r repo.Repo
+	err := row.Scan(&r.RepoID, &r.Name, &r.Owner, &r.Link, &r.CurrentReleaseTagName, &r.CurrentReleaseID,
+		&r.StableReleaseTagName, &r.StableReleaseID, &r.PrereleaseTagName, &r.PrereleaseID, &r.LatestTagName, &r.TagsDigest)
+	return r, err
+}
+
+func (db *Driver) querySubscriptions(ctx context.Context, query string, args ...any) ([]repo.RepoWithChatID, error) {
+	rows, err := db.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := []repo.RepoWithChatID{}
+	for rows.Next() {
+		r, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, r)
+	}
+
+	return subscriptions, rows.Err()
+}
+
+func (db *Driver) GetRepos(ctx context.Context, chatID string) ([]repo.Repo, error) {
+	subscriptions, err := db.querySubscriptions(ctx, `
+		SELECT `+subscriptionColumns+`
+		FROM subscriptions s JOIN repositories r ON r.repo_id = s.repo_id
+		WHERE s.chat_id = ?
+		ORDER BY s.repo_id`, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	repos := make([]repo.Repo, len(subscriptions))
+	for i, subscription := range subscriptions {
+		repos[i] = subscription.Repo
+	}
+
+	return repos, nil
+}
+
+// AddRepo creates the repository record if this is its first subscriber. An existing record keeps
+// its last seen release, only the name, owner and link are refreshed.
+func (db *Driver) AddRepo(ctx context.Context, chatID string, details *repo.Repo) error {
+	return db.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO repositories (`+repositoryColumns+`)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (repo_id) DO UPDATE SET
+				repo_name = excluded.repo_name,
+				repo_owner = excluded.repo_owner,
+				repo_link = excluded.repo_link`,
+			details.RepoID, details.Name, details.Owner, details.Link, details.CurrentReleaseTagName, details.CurrentReleaseID,
+			details.StableReleaseTagName, details.StableReleaseID, details.PrereleaseTagName, details.PrereleaseID, details.LatestTagName,
+			details.TagsDigest)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO subscriptions (chat_id, repo_id, should_pre, last_tag_name)
+			VALUES (?, ?, 0, ?)
+			ON CONFLICT (chat_id, repo_id) DO NOTHING`,
+			chatID, details.RepoID, details.LatestTagName)
+		if err != nil {
+			return err
+		}
+
+		inserted, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if inserted == 0 {
+			return errors.ErrRepoExists
+		}
+
+		return nil
+	})
+}
+
+// RemoveRepo deletes the subscription and, once nobody watches it anymore, the repository record.
+func (db *Driver) RemoveRepo(ctx context.Context, chatID, repoID string) error {
+	return db.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `DELETE FROM subscriptions WHERE chat_id = ? AND repo_id = ?`, chatID, repoID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			DELETE FROM repositories
+			WHERE repo_id = ?1 AND NOT EXISTS (SELECT 1 FROM subscriptions WHERE repo_id = ?1)`,
+			repoID)
+		return err
+	})
+}
+
+func (db *Driver) AllRepos(ctx context.Context) ([]repo.RepoWithChatID, error) {
+	return database.CollectRepos(db.IterRepos(ctx))
+}
+
+// IterRepos reads the subscriptions in keyset pages of reposPageSize rows. The connection
+// is given back between pages, so the caller can write while iterating.
+func (db *Driver) IterRepos(ctx context.Context) iter.Seq2[repo.RepoWithChatID, error] {
+	return func(yield func(repo.RepoWithChatID, error) bool) {
+		afterChatID, afterRepoID := "", ""
+		for {
+			page, err := db.querySubscriptions(ctx, `
+				SELECT `+subscriptionColumns+`
+				FROM subscriptions s JOIN repositories r ON r.repo_id = s.repo_id
+				WHERE (s.chat_id, s.repo_id) > (?, ?)
+				ORDER BY s.chat_id, s.repo_id
+				LIMIT ?`, afterChatID, afterRepoID, reposPageSize)
+			if err != nil {
+				yield(repo.RepoWithChatID{}, err)
+				return
+			}
+
+			for _, r := range page {
+				if !yield(r, nil) {
+					return
+				}
+			}
+
+			if len(page) < reposPageSize {
+				return
+			}
+
+			last := page[len(page)-1]
+			afterChatID, afterRepoID = last.ChatID, last.RepoID
+		}
+	}
+}
+
+// IterWatched pages through the repositories the same way IterRepos pages through subscriptions.
+func (db *Driver) IterWatched(ctx context.Context) iter.Seq2[repo.Repo, error] {
+	return func(yield func(repo.Repo, error) bool) {
+		afterRepoID := ""
+		for {
+			page, err := db.repositoriesPage(ctx, afterRepoID)
+			if err != nil {
+				yield(repo.Repo{}, err)
+				return
+			}
+
+			for _, r := range page {
+				if !yield(r, nil) {
+					return
+				}
+			}
+
+			if len(page) < reposPageSize {
+				return
+			}
+
+			afterRepoID = page[len(page)-1].RepoID
+		}
+	}
+}
+
+func (db *Driver) repositoriesPage(ctx context.Context, afterRepoID string) ([]repo.Repo, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT `+repositoryColumns+`
+		FROM repositories
+		WHERE repo_id > ?
+		ORDER BY repo_id
+		LIMIT ?`, afterRepoID, reposPageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	repos := make([]repo.Repo, 0, reposPageSize)
+	for rows.Next() {
+		r, err := scanRepository(rows)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, r)
+	}
+
+	return repos, rows.Err()
+}
+
+func (db *Driver) GetSubscribers(ctx context.Context, repoID string) ([]repo.RepoWithChatID, error) {
+	return db.querySubscriptions(ctx, `
+		SELECT `+subscriptionColumns+`
+		FROM subscriptions s JOIN repositories r ON r.repo_id = s.repo_id
+		WHERE s.repo_id = ?
+		ORDER BY s.chat_id`, repoID)
+}
+
+func (db *Driver) UpdateRelease(ctx context.Context, repoID string, release repo.Release) error {
+	result, err := db.db.ExecContext(ctx, `
+		UPDATE repositories
+		SET current_release_id = ?, current_release_tag_name = ?,
+			stable_release_id = ?, stable_release_tag_name = ?,
+			prerelease_id = ?, prerelease_tag_name = ?,
+			latest_tag_name = ?, tags_digest = ?
+		WHERE repo_id = ?`,
+		release.CurrentReleaseID, release.CurrentReleaseTagName,
+		release.StableReleaseID, release.StableReleaseTagName,
+		release.PrereleaseID, release.PrereleaseTagName,
+		release.LatestTagName, release.TagsDigest, repoID)
+	return expectOneRow(result, err)
+}
+
+func (db *Driver) CarriedOverRepos(ctx context.Context) ([]string, error) {
+	rows, err := db.db.QueryContext(ctx, `SELECT repo_id FROM repositories WHERE carried_over`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	repoIDs := []string{}
+	for rows.Next() {
+		var repoID string
+		err = rows.Scan(&repoID)
+		if err != nil {
+			return nil, err
+		}
+		repoIDs = append(repoIDs, repoID)
+	}
+
+	return repoIDs, rows.Err()
+}
+
+func (db *Driver) SetCarriedOver(ctx context.Context, repoIDs []string, carried bool) error {
+	return db.withTx(ctx, func(tx *sql.Tx) error {
+		for _, repoID := range repoIDs {
+			_, err := tx.ExecContext(ctx, `UPDATE repositories SET carried_over = ? WHERE repo_id = ?`, carried, repoID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (db *Driver) SetPreReleaseRetrieve(ctx context.Context, chatID, repoID string, newValue bool) error {
+	result, err := db.db.ExecContext(ctx, `UPDATE subscriptions SET should_pre = ? WHERE chat_id = ? AND repo_id = ?`, newValue, chatID, repoID)
+	return expectOneRow(result, err)
+}
+
+func (db *Driver) SetTrackTags(ctx context.Context, chatID, repoID string, newValue bool) error {
+	result, err := db.db.ExecContext(ctx, `UPDATE subscriptions SET track_tags = ? WHERE chat_id = ? AND repo_id = ?`, newValue, chatID, repoID)
+	return expectOneRow(result, err)
+}
+
+func (db *Driver) SetUpdateLevel(ctx context.Context, chatID, repoID string, level repo.UpdateLevel) error {
+	result, err := db.db.ExecContext(ctx, `UPDATE subscriptions SET update_level = ? WHERE chat_id = ? AND repo_id = ?`, level, chatID, repoID)
+	return expectOneRow(result, err)
+}
+
+func (db *Driver) SetTagFilters(ctx context.Context, chatID, repoID, include, exclude string) error {
+	result, err := db.db.ExecContext(ctx, `UPDATE subscriptions SET include_tags = ?, exclude_tags = ?, last_tag_name = '' WHERE chat_id = ? AND repo_id = ?`, include, exclude, chatID, repoID)
+	return expectOneRow(result, err)
+}
+
+func (db *Driver) SetLastTag(ctx context.Context, chatID, repoID, tag string) error {
+	result, err := db.db.ExecContext(ctx, `UPDATE subscriptions SET last_tag_name = ? WHERE chat_id = ? AND repo_id = ?`, tag, chatID, repoID)
+	return expectOneRow(result, err)
+}
+
+func (db *Driver) CheckExisting(ctx context.Context, chatID, repoID string) (bool, error) {
+	var exists bool
+	err := db.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM subscriptions WHERE chat_id = ? AND repo_id = ?)`, chatID, repoID).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+func (db *Driver) GetChatSettings(ctx context.Context, chatID string) (repo.ChatSettings, error) {
+	settings := repo.ChatSettings{ChatID: chatID}
+	var delivery, quietMode string
+	err := db.db.QueryRowContext(ctx, `
+		SELECT template, delivery, digest_hour, time_zone, quiet_from, quiet_until, quiet_mode
+		FROM chats
+		WHERE chat_id = ?`, chatID).
+		Scan(&settings.Template, &delivery, &settings.DigestHour, &settings.TimeZone, &settings.QuietFrom, &settings.QuietUntil, &quietMode)
+	settings.Delivery, settings.QuietMode = repo.DeliveryMode(delivery), repo.QuietMode(quietMode)
+	if err == sql.ErrNoRows {
+		return settings, nil
+	}
+	if err != nil {
+		return repo.ChatSettings{}, err
+	}
+
+	return settings, nil
+}
+
+func (db *Driver) SetTemplate(ctx context.Context, chatID, template string) error {
+	_, err := db.db.ExecContext(ctx, `
+		INSERT INTO chats (chat_id, template)
+		VALUES (?, ?)
+		ON CONFLICT (chat_id) DO UPDATE SET template = excluded.template`,
+		chatID, template)
+	return err
+}
+
+func (db *Driver) SetDelivery(ctx context.Context, chatID string, mode repo.DeliveryMode, digestHour int, timeZone string) error {
+	_, err := db.db.ExecContext(ctx, `
+		INSERT INTO chats (chat_id, delivery, digest_hour, time_zone)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (chat_id) DO UPDATE SET
+			delivery = excluded.delivery,
+			digest_hour = excluded.digest_hour,
+			time_zone = excluded.time_zone`,
+		chatID, string(mode), digestHour, timeZone)
+	return err
+}
+
+func (db *Driver) SetQuietHours(ctx context.Context, chatID string, from, until int, mode repo.QuietMode) error {
+	_, err := db.db.ExecContext(ctx, `
+		INSERT INTO chats (chat_id, quiet_from, quiet_until, quiet_mode)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (chat_id) DO UPDATE SET
+			quiet_from = excluded.quiet_from,
+			quiet_until = excluded.quiet_until,
+			quiet_mode = excluded.quiet_mode`,
+		chatID, from, until, string(mode))
+	return err
+}
+
+func (db *Driver) SetTimeZone(ctx context.Context, chatID, timeZone string) error {
+	_, err := db.db.ExecContext(ctx, `
+		INSERT INTO chats (chat_id, time_zone)
+		VALUES (?, ?)
+		ON CONFLICT (chat_id) DO UPDATE SET time_zone = excluded.time_zone`,
+		chatID, timeZone)
+	return err
+}
+
+// MoveChat moves the rows of the chat over to the new chat ID, leaving out those the new chat has already.
+func (db *Driver) MoveChat(ctx context.Context, from, to string) error {
+	return db.withTx(ctx, func(tx *sql.Tx) error {
+		for _, statement := range []string{
+			`UPDATE subscriptions SET chat_id = ?2
+			WHERE chat_id = ?1 AND repo_id NOT IN (SELECT repo_id FROM subscriptions WHERE chat_id = ?2)`,
+			`DELETE FROM subscriptions WHERE chat_id = ?1`,
+			`UPDATE chats SET chat_id = ?2
+			WHERE chat_id = ?1 AND NOT EXISTS (SELECT 1 FROM chats WHERE chat_id = ?2)`,
+			`DELETE FROM chats WHERE chat_id = ?1`,
+			`UPDATE pending_notifications SET chat_id = ?2
+			WHERE chat_id = ?1 AND (repo_id, tag_name) NOT IN (SELECT repo_id, tag_name FROM pending_notifications WHERE chat_id = ?2)`,
+			`DELETE FROM pending_notifications WHERE chat_id = ?1`,
+			`UPDATE outbox SET chat_id = ?2 WHERE chat_id = ?1 AND delivered_at IS NULL`,
+		} {
+			_, err := tx.ExecContext(ctx, statement, from, to)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (db *Driver) QueueNotification(ctx context.Context, notification repo.PendingNotification) error {
+	_, err := db.db.ExecContext(ctx, `
+		INSERT INTO pending_notifications (chat_id, repo_id, tag_name, repo_name, repo_link, is_prerelease, is_tag, queued_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (chat_id, repo_id, tag_name) DO NOTHING`,
+		notification.ChatID, notification.RepoID, notification.TagName, notification.RepoName, notification.RepoLink,
+		notification.IsPrerelease, notification.IsTag, notification.QueuedAt.UTC())
+	return err
+}
+
+func (db *Driver) PendingNotifications(ctx context.Context) ([]repo.PendingNotification, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT chat_id, repo_id, tag_name, repo_name, repo_link, is_prerelease, is_tag, queued_at
+		FROM pending_notifications
+		ORDER BY chat_id, queued_at, repo_id, tag_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pending := []repo.PendingNotification{}
+	for rows.Next() {
+		var notification repo.PendingNotification
+		err = rows.Scan(&notification.ChatID, &notification.RepoID, &notification.TagName, &notification.RepoName, &notification.RepoLink,
+			&notification.IsPrerelease, &notification.IsTag, &notification.QueuedAt)
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending, notification)
+	}
+
+	return pending, rows.Err()
+}
+
+func (db *Driver) RemovePending(ctx context.Context, chatID string, notifications []repo.PendingNotification) error {
+	return db.withTx(ctx, func(tx *sql.Tx) error {
+		for _, notification := range notifications {
+			_, err := tx.ExecContext(ctx, `DELETE FROM pending_notifications WHERE chat_id = ? AND repo_id = ? AND tag_name = ?`,
+				chatID, notification.RepoID, notification.TagName)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (db *Driver) EnqueueOutbox(ctx context.Context, message repo.OutboxMessage) (bool, error) {
+	result, err := db.db.ExecContext(ctx, `
+		INSERT INTO outbox (idempotency_key, chat_id, repo_id, text, parse_mode, keyboard, plain_text, silent, attempts, next_attempt_at, last_error, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (idempotency_key) DO NOTHING`,
+		message.Key, message.ChatID, message.RepoID, message.Text, message.ParseMode, message.Keyboard, message.PlainText,
+		message.Silent, message.Attempts, message.NextAttemptAt.UTC(), message.LastError, message.CreatedAt.UTC())
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (db *Driver) DueOutbox(ctx context.Context, now time.Time) ([]repo.OutboxMessage, error) {
+	rows, err := db.db.QueryContext(ctx, `
+		SELECT idempotency_key, chat_id, repo_id, text, parse_mode, keyboard, plain_text, silent, attempts, next_attempt_at, last_error, created_at
+		FROM outbox
+		WHERE delivered_at IS NULL AND next_attempt_at <= ?
+		ORDER BY next_attempt_at, idempotency_key`, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	due := []repo.OutboxMessage{}
+	for rows.Next() {
+		var message repo.OutboxMessage
+		err = rows.Scan(&message.Key, &message.ChatID, &message.RepoID, &message.Text, &message.ParseMode, &message.Keyboard, &message.PlainText,
+			&message.Silent, &message.Attempts, &message.NextAttemptAt, &message.LastError, &message.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		due = append(due, message)
+	}
+
+	return due, rows.Err()
+}
+
+func (db *Driver) RetryOutbox(ctx context.Context, key string, attempts int, nextAttemptAt time.Time, lastError string) error {
+	_, err := db.db.ExecContext(ctx, `
+		UPDATE outbox
+		SET attempts = ?, next_attempt_at = ?, last_error = ?
+		WHERE idempotency_key = ?`,
+		attempts, nextAttemptAt.UTC(), lastError, key)
+	return err
+}
+
+func (db *Driver) MarkDelivered(ctx context.Context, key string, deliveredAt time.Time) error {
+	_, err := db.db.ExecContext(ctx, `UPDATE outbox SET delivered_at = ? WHERE idempotency_key = ?`, deliveredAt.UTC(), key)
+	return err
+}
+
+func (db *Driver) PruneOutbox(ctx context.Context, before time.Time) error {
+	_, err := db.db.ExecContext(ctx, `DELETE FROM outbox WHERE delivered_at < ?`, before.UTC())
+	return err
+}
+
+// expectOneRow turns an update that matched nothing into errors.ErrRepoNotFound.
+func expectOneRow(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return errors.ErrRepoNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/chofnar/release-bot/internal/database"
+	"github.com/chofnar/release-bot/internal/database/databasetest"
+	"github.com/chofnar/release-bot/internal/database/migrations"
+	"go.uber.org/zap"
+)
+
+func TestConformance(t *testing.T) {
+	databasetest.Run(t, func(t *testing.T) database.Database {
+		t.Setenv("BOT_SQLITE_PATH", filepath.Join(t.TempDir(), "release-bot.db"))
+
+		db := (&DriverFactory{}).Create(*zap.NewNop().Sugar())
+		if db == nil {
+			t.Fatal("could not create sqlite driver")
+		}
+		t.Cleanup(func() {
+			_ = db.(*Driver).db.Close()
+		})
+
+		return db
+	})
+}
+
+func TestMigrateSingleTableLayout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "release-bot.db")
+	t.Setenv("BOT_SQLITE_PATH", path)
+
+	old, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = migrations.Apply(old, schemaMigrations[:1], *zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = old.Exec(`
+		INSERT INTO repos (chat_id, repo_id, repo_name, repo_owner, repo_link, current_release_tag_name, current_release_id, should_pre) VALUES
+			('1', 'R_1', 'name', 'owner', 'link', 'v1.0.0', 'release-1', 0),
+			('2', 'R_1', 'name', 'owner', 'link', 'v1.1.0-rc1', 'release-2', 1),
+			('2', 'R_2', 'other', 'owner', 'link', 'v0.1.0', 'release-3', 0)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = old.Close()
+
+	db := (&DriverFactory{}).Create(*zap.NewNop().Sugar())
+	if db == nil {
+		t.Fatal("could not create sqlite driver")
+	}
+	defer db.(*Driver).db.Close()
+
+	subscribers, err := db.GetSubscribers(context.Background(), "R_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subscribers) != 2 {
+		t.Fatalf("got %d subscribers of R_1, want 2", len(subscribers))
+	}
+	for _, s := range subscribers {
+		if s.CurrentReleaseID != "release-2" {
+			t.Errorf("chat %s got release %q, want the prerelease chat's %q", s.ChatID, s.CurrentReleaseID, "release-2")
+		}
+		if s.ShouldNotifyPrerelease != (s.ChatID == "2") {
+			t.Errorf("chat %s lost its prerelease setting", s.ChatID)
+		}
+	}
+
+	repos, err := db.GetRepos(context.Background(), "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repos) != 2 {
+		t.Errorf("chat 2 has %d repos, want 2", len(repos))
+	}
+}
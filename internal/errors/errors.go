@@ -4,8 +4,15 @@ import "errors"
 
 var (
 	ErrInvalidDynamoDBEndpoint = errors.New("dynamodb: invalid endpoint")
+	ErrUnknownDatabaseDriver   = errors.New("database: unknown driver or driver could not be created")
+	ErrInvalidCallbackData     = errors.New("callback: malformed data")
 	ErrChatIDNotFound          = errors.New("dynamodb: specified chatID does not exist in db")
 	ErrNoReleases              = errors.New("repository has no release")
 	ErrNoRepos                 = errors.New("no repos for current user")
+	ErrRateLimitLow            = errors.New("github: rate limit is too low until it resets")
+	ErrRepoExists              = errors.New("repo is already watched by this chat")
+	ErrRepoNotFound            = errors.New("repo is not watched by this chat")
 	ErrUpdateIncorrectToken    = errors.New("update: incorrect token")
+	ErrUpdateRunning           = errors.New("update: another run is still in progress")
+	ErrWebhookSignature        = errors.New("webhook: missing or invalid signature")
 )
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/chofnar/release-bot/internal/database"
 	"github.com/chofnar/release-bot/internal/errors"
@@ -12,82 +13,95 @@ import (
 	"github.com/chofnar/release-bot/internal/server/messages"
 	"github.com/chofnar/release-bot/internal/server/repo"
 	"github.com/hasura/go-graphql-client"
-	"github.com/mymmrac/telego"
-	"go.uber.org/zap"
 )
 
 type BehaviorHandler struct {
-	Bot                    *telego.Bot
+	// Sender sends every message of the bot, keeping to Telegram's rate limits
+	Sender                 *Sender
 	LinkRegex, DirectRegex *regexp.Regexp
 	GQLClient              *graphql.Client
-	DB                     database.Database
+	// GitHubBudget paces every GitHub request, shared by the update workers and the chat handlers.
+	GitHubBudget *GitHubBudget
+	// UpdateGuard keeps update runs from overlapping, see TryUpdateRepos
+	UpdateGuard   *sync.Mutex
+	DB            database.Database
+	UpdateWorkers int
 }
 
-func (bh BehaviorHandler) About(chatID int64) error {
-	_, err := bh.Bot.SendMessage(messages.AboutMessage(chatID))
+func (bh BehaviorHandler) About(ctx context.Context, chatID int64) error {
+	_, err := bh.Sender.SendMessage(ctx, messages.AboutMessage(chatID))
 	return err
 }
 
-func (bh BehaviorHandler) Start(chatID int64) error {
-	_, err := bh.Bot.SendMessage(messages.StartMessage(chatID))
+func (bh BehaviorHandler) Start(ctx context.Context, chatID int64) error {
+	_, err := bh.Sender.SendMessage(ctx, messages.StartMessage(chatID))
 	return err
 }
 
-func (bh BehaviorHandler) UnknownCommand(chatID int64) error {
-	_, err := bh.Bot.SendMessage(messages.UnknownCommandMessage(chatID))
+func (bh BehaviorHandler) UnknownCommand(ctx context.Context, chatID int64) error {
+	_, err := bh.Sender.SendMessage(ctx, messages.UnknownCommandMessage(chatID))
 	if err != nil {
 		return err
 	}
 
-	_, err = bh.Bot.SendMessage(messages.StartMessage(chatID))
+	_, err = bh.Sender.SendMessage(ctx, messages.StartMessage(chatID))
 	return err
 }
 
-func (bh BehaviorHandler) SentRepo(messageText string, messageID int, chatID int64) error {
+func (bh BehaviorHandler) SentRepo(ctx context.Context, messageText string, messageID int, chatID int64) error {
 	owner, repoName, valid := bh.validateInput(messageText)
 	if valid {
-		repoToAdd, err := bh.validateAndRetrieveRepo(owner, repoName)
+		repoToAdd, err := bh.validateAndRetrieveRepo(ctx, owner, repoName)
 		hasReleases := true
 		if err != nil {
 			if err == errors.ErrNoReleases {
 				hasReleases = false
+			} else if err == errors.ErrRateLimitLow {
+				_, _ = bh.Sender.SendMessage(ctx, messages.RateLimitedMessage(chatID))
+
+				return err
 			} else {
-				_, _ = bh.Bot.SendMessage(messages.RepoNotFoundMessage(chatID))
+				_, _ = bh.Sender.SendMessage(ctx, messages.RepoNotFoundMessage(chatID))
 
 				return err
 			}
 		}
-		exists, err := bh.DB.CheckExisting(fmt.Sprint(chatID), repoToAdd.RepoID)
+		exists, err := bh.DB.CheckExisting(ctx, fmt.Sprint(chatID), repoToAdd.RepoID)
 		if err != nil {
 			return err
 		}
 
 		if exists {
-			_, err = bh.Bot.SendMessage(messages.AlreadyExistsMessage(chatID, messageID))
+			_, err = bh.Sender.SendMessage(ctx, messages.AlreadyExistsMessage(chatID, messageID))
 			if err != nil {
 				return err
 			}
 			return nil
 		}
 
-		err = bh.DB.AddRepo(fmt.Sprint(chatID), &repoToAdd)
+		err = bh.DB.AddRepo(ctx, fmt.Sprint(chatID), &repoToAdd)
+		if err == errors.ErrRepoExists {
+			// lost a race against another add of the same repo
+			_, err = bh.Sender.SendMessage(ctx, messages.AlreadyExistsMessage(chatID, messageID))
+			return err
+		}
 		if err != nil {
 			return err
 		}
 
 		if hasReleases {
-			_, err = bh.Bot.SendMessage(messages.SuccessfullyAddedRepoMessage(chatID))
+			_, err = bh.Sender.SendMessage(ctx, messages.SuccessfullyAddedRepoMessage(chatID))
 			if err != nil {
 				return err
 			}
 		} else {
-			_, err = bh.Bot.SendMessage(messages.SuccessfullyAddedRepoWithoutReleasesMessage(chatID))
+			_, err = bh.Sender.SendMessage(ctx, messages.SuccessfullyAddedRepoWithoutReleasesMessage(chatID))
 			if err != nil {
 				return err
 			}
 		}
 	} else {
-		_, err := bh.Bot.SendMessage(messages.InvalidRepoMessage(chatID))
+		_, err := bh.Sender.SendMessage(ctx, messages.InvalidRepoMessage(chatID))
 		if err != nil {
 			return err
 		}
@@ -112,73 +126,18 @@ func (bh BehaviorHandler) validateInput(message string) (owner, repo string, isV
 	return
 }
 
-func (bh BehaviorHandler) validateAndRetrieveRepo(owner, name string) (repo.Repo, error) {
-	variables := map[string]interface{}{
-		"name":  name,
-		"owner": owner,
-	}
-
-	var getRepoQuery struct {
-		Repository struct {
-			ID    string
-			URL   string
-			Name  string
-			Owner struct {
-				Login string
-			}
-			Releases struct {
-				Nodes []struct {
-					TagName      string
-					ID           string
-					IsPrerelease bool
-				}
-			} `graphql:"releases(first: 1)"`
-		} `graphql:"repository(name: $name, owner: $owner)"`
-	}
-
-	err := bh.GQLClient.Query(context.TODO(), &getRepoQuery, variables)
-	if err != nil {
-		return repo.Repo{}, err
-	}
-
-	if len(getRepoQuery.Repository.Releases.Nodes) != 0 {
-		return repo.Repo{
-			RepoID: getRepoQuery.Repository.ID,
-			Name:   getRepoQuery.Repository.Name,
-			Owner:  getRepoQuery.Repository.Owner.Login,
-			Link:   getRepoQuery.Repository.URL,
-			Release: repo.Release{
-				CurrentReleaseTagName: getRepoQuery.Repository.Releases.Nodes[0].TagName,
-				CurrentReleaseID:      getRepoQuery.Repository.Releases.Nodes[0].ID,
-				IsPrerelease:          getRepoQuery.Repository.Releases.Nodes[0].IsPrerelease,
-			},
-		}, nil
-	}
-
-	return repo.Repo{
-		RepoID: getRepoQuery.Repository.ID,
-		Name:   getRepoQuery.Repository.Name,
-		Owner:  getRepoQuery.Repository.Owner.Login,
-		Link:   getRepoQuery.Repository.URL,
-		Release: repo.Release{
-			CurrentReleaseTagName: "",
-			CurrentReleaseID:      "",
-		},
-	}, errors.ErrNoReleases
-}
-
-func (bh BehaviorHandler) SeeRepos(chatID int64, messageID, limit, page int) error {
-	markup, err := messages.SeeReposMarkup(chatID, messageID, limit, page, &bh.DB)
+func (bh BehaviorHandler) SeeRepos(ctx context.Context, chatID int64, messageID, limit, page int) error {
+	markup, err := messages.SeeReposMarkup(ctx, chatID, messageID, limit, page, &bh.DB)
 	if err != errors.ErrNoRepos && err != nil {
 		return err
 	}
 	if err == errors.ErrNoRepos {
-		_, err = bh.Bot.EditMessageText(messages.SeeAllReposButNoneFoundMessage(chatID, messageID, *markup.ReplyMarkup))
+		_, err = bh.Sender.EditMessageText(ctx, messages.SeeAllReposButNoneFoundMessage(chatID, messageID, *markup.ReplyMarkup))
 		if err != nil {
 			return err
 		}
 	} else {
-		_, err = bh.Bot.EditMessageText(messages.SeeAllReposMessage(chatID, messageID, *markup.ReplyMarkup))
+		_, err = bh.Sender.EditMessageText(ctx, messages.SeeAllReposMessage(chatID, messageID, *markup.ReplyMarkup))
 		if err != nil {
 			return err
 		}
@@ -186,26 +145,26 @@ func (bh BehaviorHandler) SeeRepos(chatID int64, messageID, limit, page int) err
 	return nil
 }
 
-func (bh BehaviorHandler) Add(chatID int64, messageID int) error {
-	_, err := bh.Bot.EditMessageText(messages.AddRepoMessage(chatID, messageID))
+func (bh BehaviorHandler) Add(ctx context.Context, chatID int64, messageID int) error {
+	_, err := bh.Sender.EditMessageText(ctx, messages.AddRepoMessage(chatID, messageID))
 	return err
 }
 
-func (bh BehaviorHandler) Menu(chatID int64, messageID int) error {
-	_, err := bh.Bot.EditMessageText(messages.EditedStartMessage(chatID, messageID))
+func (bh BehaviorHandler) Menu(ctx context.Context, chatID int64, messageID int) error {
+	_, err := bh.Sender.EditMessageText(ctx, messages.EditedStartMessage(chatID, messageID))
 	return err
 }
 
-func (bh BehaviorHandler) DeleteRepo(chatID int64, messageID int, data string) error {
-	err := messages.DeleteRepo(chatID, data, &bh.DB)
+func (bh BehaviorHandler) DeleteRepo(ctx context.Context, chatID int64, messageID int, data string) error {
+	err := messages.DeleteRepo(ctx, chatID, data, &bh.DB)
 	if err != nil {
 		return err
 	}
 
-	return bh.Menu(chatID, messageID)
+	return bh.Menu(ctx, chatID, messageID)
 }
 
-func (bh BehaviorHandler) FlipPreRelease(chatID int64, messageID int, repoIDwithOP string) error {
+func (bh BehaviorHandler) FlipPreRelease(ctx context.Context, chatID int64, messageID int, repoIDwithOP string) error {
 	repoIDwithNewVal := strings.TrimPrefix(repoIDwithOP, consts.FlipOperationPrefix)
 
 	newValStr := repoIDwithNewVal[0]
@@ -216,77 +175,141 @@ func (bh BehaviorHandler) FlipPreRelease(chatID int64, messageID int, repoIDwith
 
 	repoID := repoIDwithNewVal[2:]
 
-	err := messages.SetPreReleaseRetrieve(chatID, repoID, newVal, &bh.DB)
+	err := messages.SetPreReleaseRetrieve(ctx, chatID, repoID, newVal, &bh.DB)
 	if err != nil {
 		return err
 	}
 
-	return bh.Menu(chatID, messageID)
+	return bh.Menu(ctx, chatID, messageID)
 }
 
-func (bh BehaviorHandler) newUpdate(repository repo.RepoWithChatID, isPre bool) error {
-	_, err := bh.Bot.SendMessage(messages.UpdateMessage(repository, isPre))
-	return err
+func (bh BehaviorHandler) FlipTrackTags(ctx context.Context, chatID int64, messageID int, repoIDwithOP string) error {
+	repoIDwithNewVal := strings.TrimPrefix(repoIDwithOP, consts.TrackTagsOperationPrefix)
+
+	newVal := repoIDwithNewVal[0] == byte('T')
+	repoID := repoIDwithNewVal[2:]
+
+	err := messages.SetTrackTags(ctx, chatID, repoID, newVal, &bh.DB)
+	if err != nil {
+		return err
+	}
+
+	return bh.Menu(ctx, chatID, messageID)
 }
 
-type erroredRepo struct {
-	Err  error     `json:"err,omitempty"`
-	Repo repo.Repo `json:"repo,omitempty"`
+func (bh BehaviorHandler) FlipUpdateLevel(ctx context.Context, chatID int64, messageID int, repoIDwithOP string) error {
+	levelWithRepoID := strings.TrimPrefix(repoIDwithOP, consts.UpdateLevelOperationPrefix)
+
+	newLevel, repoID, found := strings.Cut(levelWithRepoID, "_")
+	if !found || !repo.UpdateLevel(newLevel).Valid() {
+		return errors.ErrInvalidCallbackData
+	}
+
+	err := messages.SetUpdateLevel(ctx, chatID, repoID, repo.UpdateLevel(newLevel), &bh.DB)
+	if err != nil {
+		return err
+	}
+
+	return bh.Menu(ctx, chatID, messageID)
 }
 
-func (bh BehaviorHandler) UpdateRepos(logger zap.SugaredLogger) []erroredRepo {
-	repos, err := bh.DB.AllRepos()
-	failedRepos := []erroredRepo{}
+// Filter handles /filter owner/repo [include|exclude] <regex>, /filter owner/repo clear and /filter owner/repo,
+// payload being everything after the command.
+func (bh BehaviorHandler) Filter(ctx context.Context, chatID int64, payload string) error {
+	repoArg, rest, _ := strings.Cut(strings.TrimSpace(payload), " ")
+	rest = strings.TrimSpace(rest)
+
+	owner, repoName, valid := bh.validateInput(repoArg)
+	if !valid {
+		_, err := bh.Sender.SendMessage(ctx, messages.FilterUsageMessage(chatID))
+		return err
+	}
+
+	repos, err := bh.DB.GetRepos(ctx, fmt.Sprint(chatID))
 	if err != nil {
-		failedRepos = append(failedRepos, erroredRepo{Err: err})
-		return failedRepos
+		return err
 	}
 
-	for _, repository := range repos {
-		newlyRetrievedRepo, err := bh.validateAndRetrieveRepo(repository.Owner, repository.Name)
-		if err != nil {
-			failedRepos = append(failedRepos, erroredRepo{Err: err, Repo: newlyRetrievedRepo})
-			// Could not resolve
-			if strings.Contains(err.Error(), "Could not resolve to a Repository with the name") {
-				errdb := bh.DB.RemoveRepo(repository.ChatID, repository.RepoID)
-				if errdb != nil {
-					logger.Error(errdb)
-				}
-			}
-			continue
+	var subscription *repo.Repo
+	for i := range repos {
+		// GitHub doesn't care about case in owner and repo names
+		if strings.EqualFold(repos[i].Owner, owner) && strings.EqualFold(repos[i].Name, repoName) {
+			subscription = &repos[i]
+			break
 		}
+	}
+	if subscription == nil {
+		_, err = bh.Sender.SendMessage(ctx, messages.FilterRepoNotWatchedMessage(chatID))
+		return err
+	}
 
-		if newlyRetrievedRepo.CurrentReleaseID != repository.CurrentReleaseID && (!newlyRetrievedRepo.IsPrerelease || (newlyRetrievedRepo.IsPrerelease && repository.ShouldNotifyPrerelease)) {
-			withChatID := repo.RepoWithChatID{
-				Repo:   newlyRetrievedRepo,
-				ChatID: repository.ChatID,
-			}
+	if rest == "" {
+		_, err = bh.Sender.SendMessage(ctx, messages.FiltersMessage(chatID, *subscription))
+		return err
+	}
 
-			err = bh.DB.UpdateEntry(withChatID)
-			if err != nil {
-				failedRepos = append(failedRepos, erroredRepo{Err: err, Repo: newlyRetrievedRepo})
-				continue
-			}
+	include, exclude, ok := changeFilters(rest, subscription.IncludeTags, subscription.ExcludeTags)
+	if !ok {
+		_, err = bh.Sender.SendMessage(ctx, messages.FilterUsageMessage(chatID))
+		return err
+	}
 
-			err = bh.newUpdate(withChatID, newlyRetrievedRepo.IsPrerelease)
-			if err != nil {
-				// clean up orphaned repos:
-				// 400 chat not found, 403 user blocked the bot
-				if strings.Contains(err.Error(), "Forbidden: bot was blocked by the user") || strings.Contains(err.Error(), "Bad Request: chat not found") {
-					errdb := bh.DB.RemoveRepo(repository.ChatID, repository.RepoID)
-					if errdb != nil {
-						logger.Error(errdb)
-					}
-					continue
-				}
-
-				// other
-				failedRepos = append(failedRepos, erroredRepo{Err: err, Repo: newlyRetrievedRepo})
-				continue
-			}
+	_, err = newTagFilter(include, exclude)
+	if err != nil {
+		_, err = bh.Sender.SendMessage(ctx, messages.FilterInvalidMessage(chatID, err))
+		return err
+	}
 
+	err = messages.SetTagFilters(ctx, chatID, subscription.RepoID, include, exclude, &bh.DB)
+	if err != nil {
+		return err
+	}
+
+	subscription.IncludeTags, subscription.ExcludeTags = include, exclude
+	_, err = bh.Sender.SendMessage(ctx, messages.FiltersMessage(chatID, *subscription))
+	return err
+}
+
+// Template shows the template the chat's release notifications are written with, or sets it to payload.
+// "reset" goes back to the default template. A new template is tried out before it is saved.
+func (bh BehaviorHandler) Template(ctx context.Context, chatID int64, payload string) error {
+	payload = strings.TrimSpace(payload)
+
+	switch payload {
+	case "":
+		settings, err := bh.DB.GetChatSettings(ctx, fmt.Sprint(chatID))
+		if err != nil {
+			return err
 		}
+
+		_, err = bh.Sender.SendMessage(ctx, messages.TemplateMessage(chatID, settings.Template))
+		return err
+	case "reset":
+		err := messages.SetTemplate(ctx, chatID, "", &bh.DB)
+		if err != nil {
+			return err
+		}
+
+		_, err = bh.Sender.SendMessage(ctx, messages.TemplateResetMessage(chatID))
+		return err
+	}
+
+	tmpl, err := messages.ParseTemplate(payload)
+	if err != nil {
+		_, err = bh.Sender.SendMessage(ctx, messages.TemplateInvalidMessage(chatID, err))
+		return err
+	}
+
+	err = messages.SetTemplate(ctx, chatID, payload, &bh.DB)
+	if err != nil {
+		return err
 	}
 
-	return failedRepos
+	_, err = bh.Sender.SendMessage(ctx, messages.TemplateSavedMessage(chatID))
+	if err != nil {
+		return err
+	}
+
+	_, err = bh.Sender.SendMessage(ctx, messages.TemplatePreviewMessage(chatID, tmpl))
+	return err
 }
@@ -0,0 +1,201 @@
+package behaviors
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chofnar/release-bot/internal/server/messages"
+	"github.com/chofnar/release-bot/internal/server/repo"
+	"go.uber.org/zap"
+)
+
+// defaultDigestHour is when digests go out for chats that didn't pick an hour.
+const defaultDigestHour = 9
+
+// digestWeekday is the day weekly digests go out on.
+const digestWeekday = time.Monday
+
+// chatLocation is the time zone of a chat, UTC if it has none or it is unknown.
+func chatLocation(timeZone string) *time.Location {
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return time.UTC
+	}
+
+	return location
+}
+
+// nextDigest is the first time, at or after since, the digest of a chat goes out.
+func nextDigest(settings repo.ChatSettings, since time.Time) time.Time {
+	location := chatLocation(settings.TimeZone)
+	local := since.In(location)
+
+	for days := 0; ; days++ {
+		next := time.Date(local.Year(), local.Month(), local.Day()+days, settings.DigestHour, 0, 0, 0, location)
+		if next.Before(local) {
+			continue
+		}
+		if settings.Delivery == repo.DeliveryWeekly && next.Weekday() != digestWeekday {
+			continue
+		}
+
+		return next
+	}
+}
+
+// digestDue tells if a chat, whose oldest pending notification was queued at oldest, gets its digest at now.
+// Chats without digests only have releases held back during their quiet hours pending, they get them right away.
+func digestDue(settings repo.ChatSettings, oldest, now time.Time) bool {
+	if !settings.Delivery.Digest() {
+		return true
+	}
+
+	return !now.Before(nextDigest(settings, oldest))
+}
+
+// queueNotification puts a release the subscriber would have been told about into the queue of its next digest,
+// or of the end of its quiet hours.
+func (bh BehaviorHandler) queueNotification(ctx context.Context, repository repo.RepoWithChatID, release repo.Release) error {
+	return bh.DB.QueueNotification(ctx, repo.PendingNotification{
+		ChatID:       repository.ChatID,
+		RepoID:       repository.RepoID,
+		TagName:      release.CurrentReleaseTagName,
+		RepoName:     repository.Name,
+		RepoLink:     repository.Link,
+		IsPrerelease: release.IsPrerelease,
+		IsTag:        repository.TrackTags,
+		QueuedAt:     time.Now(),
+	})
+}
+
+// SendDigests sends every chat whose digest is due at now one message with all of its pending notifications.
+// Chats in their quiet hours wait for them to end, unless they'd rather have the digest without a sound.
+// Notifications stay queued until their digest made it, a chat that blocked the bot or is gone is unsubscribed.
+// A group upgraded to a supergroup gets its digest there.
+func (bh BehaviorHandler) SendDigests(ctx context.Context, logger zap.SugaredLogger, now time.Time) []erroredRepo {
+	failedRepos := []erroredRepo{}
+
+	pending, err := bh.DB.PendingNotifications(ctx)
+	if err != nil {
+		return append(failedRepos, erroredRepo{Err: err})
+	}
+
+	// pending is sorted by chat
+	for start := 0; start < len(pending); {
+		end := start + 1
+		for end < len(pending) && pending[end].ChatID == pending[start].ChatID {
+			end++
+		}
+		chatPending := pending[start:end]
+		start = end
+
+		err = bh.sendDigest(ctx, logger, chatPending, now)
+		if err != nil {
+			failedRepos = append(failedRepos, erroredRepo{Err: err})
+		}
+	}
+
+	return failedRepos
+}
+
+func (bh BehaviorHandler) sendDigest(ctx context.Context, logger zap.SugaredLogger, chatPending []repo.PendingNotification, now time.Time) error {
+	chatID := chatPending[0].ChatID
+
+	settings, err := bh.DB.GetChatSettings(ctx, chatID)
+	if err != nil {
+		return err
+	}
+
+	quiet := inQuietHours(settings, now)
+	if !digestDue(settings, chatPending[0].QueuedAt, now) || (quiet && settings.QuietMode != repo.QuietSilent) {
+		return nil
+	}
+
+	message := messages.DigestMessage(chatID, settings.Delivery, chatPending)
+	message.DisableNotification = quiet
+	_, err = bh.Sender.SendMessage(ctx, message)
+	if to, migrated := migratedTo(err); migrated {
+		// the queue moves along with the subscriptions, the digest goes to the supergroup right away
+		err = bh.DB.MoveChat(ctx, chatID, to)
+		if err != nil {
+			return err
+		}
+		chatID = to
+		message = messages.DigestMessage(chatID, settings.Delivery, chatPending)
+		message.DisableNotification = quiet
+		_, err = bh.Sender.SendMessage(ctx, message)
+	}
+	if err != nil && chatGone(err) {
+		repos, errdb := bh.DB.GetRepos(ctx, chatID)
+		if errdb != nil {
+			logger.Error(errdb)
+		}
+		for _, watched := range repos {
+			errdb = bh.DB.RemoveRepo(ctx, chatID, watched.RepoID)
+			if errdb != nil {
+				logger.Error(errdb)
+			}
+		}
+	} else if err != nil {
+		// tried again on the next update run
+		return err
+	}
+
+	return bh.DB.RemovePending(ctx, chatID, chatPending)
+}
+
+// Digest shows when the chat is told about new releases, or changes it according to payload:
+// "instant", or "daily" or "weekly" followed by an optional hour and time zone.
+func (bh BehaviorHandler) Digest(ctx context.Context, chatID int64, payload string) error {
+	settings, err := bh.DB.GetChatSettings(ctx, fmt.Sprint(chatID))
+	if err != nil {
+		return err
+	}
+
+	args := strings.Fields(payload)
+	if len(args) == 0 {
+		_, err = bh.Sender.SendMessage(ctx, messages.DeliveryMessage(chatID, settings, true))
+		return err
+	}
+
+	mode := repo.DeliveryMode(strings.ToLower(args[0]))
+	hour, timeZone := settings.DigestHour, settings.TimeZone
+	if !settings.Delivery.Digest() {
+		hour = defaultDigestHour
+	}
+
+	switch {
+	case mode == repo.DeliveryInstant && len(args) == 1:
+	case mode.Digest() && len(args) <= 3:
+		if len(args) > 1 {
+			hour, err = strconv.Atoi(strings.TrimSuffix(args[1], ":00"))
+			if err != nil || hour < 0 || hour > 23 {
+				_, err = bh.Sender.SendMessage(ctx, messages.DeliveryInvalidMessage(chatID, fmt.Errorf("%s is not an hour between 0 and 23", args[1])))
+				return err
+			}
+		}
+		if len(args) > 2 {
+			timeZone = args[2]
+			// Local is wherever the bot runs
+			if _, err = time.LoadLocation(timeZone); err != nil || timeZone == "Local" {
+				_, err = bh.Sender.SendMessage(ctx, messages.DeliveryInvalidMessage(chatID, fmt.Errorf("%s is not a known time zone", timeZone)))
+				return err
+			}
+		}
+	default:
+		_, err = bh.Sender.SendMessage(ctx, messages.DeliveryUsageMessage(chatID))
+		return err
+	}
+
+	err = messages.SetDelivery(ctx, chatID, mode, hour, timeZone, &bh.DB)
+	if err != nil {
+		return err
+	}
+
+	settings.Delivery, settings.DigestHour, settings.TimeZone = mode, hour, timeZone
+	_, err = bh.Sender.SendMessage(ctx, messages.DeliveryMessage(chatID, settings, false))
+	return err
+}
@@ -0,0 +1,59 @@
+package behaviors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chofnar/release-bot/internal/server/repo"
+)
+
+func TestNextDigest(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone data:", err)
+	}
+
+	// a Wednesday
+	since := time.Date(2025, 3, 5, 10, 30, 0, 0, time.UTC)
+
+	for _, test := range []struct {
+		settings repo.ChatSettings
+		want     time.Time
+	}{
+		{repo.ChatSettings{Delivery: repo.DeliveryDaily, DigestHour: 18}, time.Date(2025, 3, 5, 18, 0, 0, 0, time.UTC)},
+		{repo.ChatSettings{Delivery: repo.DeliveryDaily, DigestHour: 9}, time.Date(2025, 3, 6, 9, 0, 0, 0, time.UTC)},
+		// 11:30 in Berlin
+		{repo.ChatSettings{Delivery: repo.DeliveryDaily, DigestHour: 11, TimeZone: "Europe/Berlin"}, time.Date(2025, 3, 6, 11, 0, 0, 0, berlin)},
+		{repo.ChatSettings{Delivery: repo.DeliveryWeekly, DigestHour: 9}, time.Date(2025, 3, 10, 9, 0, 0, 0, time.UTC)},
+		// the switch to summer time in Berlin is on March 30th
+		{repo.ChatSettings{Delivery: repo.DeliveryWeekly, DigestHour: 8, TimeZone: "Europe/Berlin"}, time.Date(2025, 3, 10, 8, 0, 0, 0, berlin)},
+		{repo.ChatSettings{Delivery: repo.DeliveryDaily, DigestHour: 9, TimeZone: "Not/AZone"}, time.Date(2025, 3, 6, 9, 0, 0, 0, time.UTC)},
+	} {
+		if got := nextDigest(test.settings, since); !got.Equal(test.want) {
+			t.Errorf("nextDigest(%+v) = %v, want %v", test.settings, got, test.want)
+		}
+	}
+
+	summer := nextDigest(repo.ChatSettings{Delivery: repo.DeliveryWeekly, DigestHour: 8, TimeZone: "Europe/Berlin"}, time.Date(2025, 3, 25, 0, 0, 0, 0, time.UTC))
+	if want := time.Date(2025, 3, 31, 6, 0, 0, 0, time.UTC); !summer.Equal(want) {
+		t.Errorf("nextDigest across the switch to summer time = %v, want %v", summer, want)
+	}
+}
+
+func TestDigestDue(t *testing.T) {
+	queued := time.Date(2025, 3, 5, 10, 30, 0, 0, time.UTC)
+	daily := repo.ChatSettings{Delivery: repo.DeliveryDaily, DigestHour: 9}
+
+	if digestDue(daily, queued, queued.Add(time.Hour)) {
+		t.Error("digest due before its hour came")
+	}
+	if !digestDue(daily, queued, time.Date(2025, 3, 6, 9, 0, 0, 0, time.UTC)) {
+		t.Error("digest not due at its hour")
+	}
+	if !digestDue(daily, queued, time.Date(2025, 3, 8, 13, 0, 0, 0, time.UTC)) {
+		t.Error("late digest not due")
+	}
+	if !digestDue(repo.ChatSettings{}, queued, queued) {
+		t.Error("chat back on instant delivery has to wait")
+	}
+}
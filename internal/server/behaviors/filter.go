@@ -0,0 +1,140 @@
+package behaviors
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+
+	"github.com/chofnar/release-bot/internal/server/repo"
+)
+
+// maxFilterLength bounds the regular expressions a chat may store, Go's regexp runs in linear time
+// but there is no need to keep arbitrarily long ones around.
+const maxFilterLength = 200
+
+// tagFilter holds the compiled include and exclude filters of a subscription.
+// The zero value lets every release and tag through.
+type tagFilter struct {
+	include, exclude *regexp.Regexp
+}
+
+func newTagFilter(include, exclude string) (tagFilter, error) {
+	var filter tagFilter
+	var err error
+
+	filter.include, err = compileFilter(include)
+	if err != nil {
+		return tagFilter{}, err
+	}
+
+	filter.exclude, err = compileFilter(exclude)
+	if err != nil {
+		return tagFilter{}, err
+	}
+
+	return filter, nil
+}
+
+// compileFilter compiles a filter, "" being no filter at all.
+func compileFilter(expression string) (*regexp.Regexp, error) {
+	if expression == "" {
+		return nil, nil
+	}
+
+	if len(expression) > maxFilterLength {
+		return nil, fmt.Errorf("filter is longer than %d characters", maxFilterLength)
+	}
+
+	return regexp.Compile(expression)
+}
+
+// matches tells if a release or tag named tag passes the filter.
+func (filter tagFilter) matches(tag string) bool {
+	if filter.include != nil && !filter.include.MatchString(tag) {
+		return false
+	}
+
+	return filter.exclude == nil || !filter.exclude.MatchString(tag)
+}
+
+// filterTags returns the tags passing the filter, keeping their order.
+func (filter tagFilter) filterTags(tags []string) []string {
+	matching := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if filter.matches(tag) {
+			matching = append(matching, tag)
+		}
+	}
+
+	return matching
+}
+
+// changeFilters applies the arguments of /filter after the repo to the include and exclude expressions of a
+// subscription. ok is false when they are not one of "clear", "include <regex>", "exclude <regex>" or "<regex>".
+func changeFilters(args, include, exclude string) (newInclude, newExclude string, ok bool) {
+	keyword, expression, _ := strings.Cut(args, " ")
+	expression = strings.TrimSpace(expression)
+
+	switch keyword {
+	case "clear":
+		return "", "", expression == ""
+	case "include":
+		return expression, exclude, expression != ""
+	case "exclude":
+		return include, expression, expression != ""
+	default:
+		return args, exclude, true
+	}
+}
+
+// tagPrefix is the directory every tag passing the include filter is in, like "service/dynamodb/" for
+// "^service/dynamodb/v1\.", so that GitHub can be asked for the tags in there only. It is "" when the filter
+// isn't anchored to a literal directory.
+func (filter tagFilter) tagPrefix() string {
+	if filter.include == nil {
+		return ""
+	}
+
+	parsed, err := syntax.Parse(filter.include.String(), syntax.Perl)
+	if err != nil {
+		return ""
+	}
+	parsed = parsed.Simplify()
+	if parsed.Op != syntax.OpConcat || len(parsed.Sub) < 2 || parsed.Sub[0].Op != syntax.OpBeginText {
+		return ""
+	}
+
+	literal := parsed.Sub[1]
+	if literal.Op != syntax.OpLiteral || literal.Flags&syntax.FoldCase != 0 {
+		return ""
+	}
+
+	prefix := string(literal.Rune)
+	return prefix[:strings.LastIndex(prefix, "/")+1]
+}
+
+// tagPrefixes returns the distinct prefixes of the include filters of the subscribers tracking tags.
+func tagPrefixes(subscribers []repo.RepoWithChatID) []string {
+	prefixes := []string{}
+	seen := map[string]struct{}{}
+	for _, subscriber := range subscribers {
+		if !subscriber.TrackTags {
+			continue
+		}
+
+		filter, err := newTagFilter(subscriber.IncludeTags, "")
+		if err != nil {
+			continue
+		}
+
+		prefix := filter.tagPrefix()
+		if _, ok := seen[prefix]; ok || prefix == "" {
+			continue
+		}
+		seen[prefix] = struct{}{}
+		prefixes = append(prefixes, prefix)
+	}
+
+	return prefixes
+}
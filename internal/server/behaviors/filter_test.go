@@ -0,0 +1,115 @@
+package behaviors
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/chofnar/release-bot/internal/server/repo"
+)
+
+func TestTagFilter(t *testing.T) {
+	filter, err := newTagFilter(`^service/dynamodb/`, `-rc`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags := []string{"service/s3/v1.2.0", "service/dynamodb/v1.3.0", "service/dynamodb/v1.4.0-rc1", "v2.0.0"}
+	if got := strings.Join(filter.filterTags(tags), ","); got != "service/dynamodb/v1.3.0" {
+		t.Errorf("filterTags = %q", got)
+	}
+
+	if !(tagFilter{}).matches("anything") {
+		t.Error("the zero filter must let everything through")
+	}
+
+	if _, err := newTagFilter(`(`, ""); err == nil {
+		t.Error("newTagFilter accepted an invalid expression")
+	}
+	if _, err := newTagFilter("", strings.Repeat("a", maxFilterLength+1)); err == nil {
+		t.Error("newTagFilter accepted an overly long expression")
+	}
+}
+
+func TestTagPrefix(t *testing.T) {
+	for include, want := range map[string]string{
+		`^service/dynamodb/`:      "service/dynamodb/",
+		`^service/dynamodb/v1\.`:  "service/dynamodb/",
+		`^service/(dynamo|s3)db/`: "service/",
+		`service/dynamodb/`:       "",
+		`^(?i)service/`:           "",
+		`^api/|^web/`:             "",
+		`^v`:                      "",
+		"":                        "",
+	} {
+		filter, err := newTagFilter(include, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := filter.tagPrefix(); got != want {
+			t.Errorf("tagPrefix of %q = %q, want %q", include, got, want)
+		}
+	}
+}
+
+func TestChangeFilters(t *testing.T) {
+	for _, test := range []struct {
+		args                     string
+		wantInclude, wantExclude string
+		wantOK                   bool
+	}{
+		{"^api/", "^api/", "old-exclude", true},
+		{"include ^api/", "^api/", "old-exclude", true},
+		{"exclude -rc", "old-include", "-rc", true},
+		{"clear", "", "", true},
+		// a keyword without its expression must not become the expression
+		{"include", "old-include", "old-exclude", false},
+		{"exclude", "old-include", "old-exclude", false},
+		{"clear ^api/", "old-include", "old-exclude", false},
+	} {
+		include, exclude, ok := changeFilters(test.args, "old-include", "old-exclude")
+		if ok != test.wantOK || (ok && (include != test.wantInclude || exclude != test.wantExclude)) {
+			t.Errorf("changeFilters(%q) = %q, %q, %v", test.args, include, exclude, ok)
+		}
+	}
+}
+
+func TestMissedReleasesFiltered(t *testing.T) {
+	newestFirst := []repo.Release{
+		{CurrentReleaseID: "RE_4", CurrentReleaseTagName: "service/s3/v1.1.0"},
+		{CurrentReleaseID: "RE_3", CurrentReleaseTagName: "service/dynamodb/v1.1.0"},
+		{CurrentReleaseID: "RE_2", CurrentReleaseTagName: "service/s3/v1.0.0"},
+		{CurrentReleaseID: "RE_1", CurrentReleaseTagName: "service/dynamodb/v1.0.0"},
+	}
+	filter, err := newTagFilter(`^service/dynamodb/`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the last seen release doesn't pass the filter, it still bounds what was missed
+	got := missedReleases(newestFirst, repo.Release{StableReleaseID: "RE_2"}, false, filter)
+	if len(got) != 1 || got[0].CurrentReleaseID != "RE_3" {
+		t.Errorf("missedReleases = %+v, want RE_3 only", got)
+	}
+
+	got = missedReleases(newestFirst, repo.Release{StableReleaseID: "RE_3"}, false, filter)
+	if len(got) != 0 {
+		t.Errorf("missedReleases = %+v, want nothing", got)
+	}
+}
+
+func TestMissedTagsInMonorepo(t *testing.T) {
+	filter, err := newTagFilter(`^service/dynamodb/`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags := filter.filterTags([]string{"service/s3/v1.80.0", "service/dynamodb/v1.41.0", "service/dynamodb/v1.40.0"})
+	got := missedTags(tags, "service/dynamodb/v1.40.0", false)
+	if len(got) != 1 || got[0].CurrentReleaseTagName != "service/dynamodb/v1.41.0" {
+		t.Errorf("missedTags = %+v, want service/dynamodb/v1.41.0", got)
+	}
+
+	if bump := versionBump("service/dynamodb/v1.41.0", tags); bump != repo.UpdateLevelMinor {
+		t.Errorf("versionBump = %q, want %q", bump, repo.UpdateLevelMinor)
+	}
+}
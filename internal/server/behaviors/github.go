@@ -0,0 +1,437 @@
+package behaviors
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chofnar/release-bot/internal/errors"
+	"github.com/chofnar/release-bot/internal/server/repo"
+	"github.com/hasura/go-graphql-client"
+)
+
+// batchSize is how many repos are resolved by a single GraphQL request during an update run.
+const batchSize = 50
+
+// maxAnnouncedReleases caps how many missed releases of one repo an update run announces to a chat,
+// out of the 10 newest it fetches.
+const maxAnnouncedReleases = 5
+
+// repositoryNode is the part of a GitHub repository the bot cares about. It is used both as
+// a query struct and, through ConstructQuery, as the selection set of the aliased batch query.
+type repositoryNode struct {
+	ID    string
+	URL   string
+	Name  string
+	Owner struct {
+		Login string
+	}
+	// without orderBy GitHub picks its own order, which isn't always newest first
+	Releases struct {
+		Nodes []struct {
+			TagName      string
+			ID           string
+			IsPrerelease bool
+			IsDraft      bool
+			Name         string
+			Description  string
+			PublishedAt  time.Time
+			// nil once the account is deleted
+			Author *struct {
+				Login string
+			}
+		}
+	} `graphql:"releases(first: 10, orderBy: {field: CREATED_AT, direction: DESC})"`
+	// for chats tracking tags, newestTag and missedTags order them by version
+	Refs struct {
+		Nodes []struct {
+			Name string
+		}
+	} `graphql:"refs(refPrefix: \"refs/tags/\", first: 20, orderBy: {field: TAG_COMMIT_DATE, direction: DESC})"`
+	// prefixedTags are the newest tags in the directories of repoRef.TagPrefixes, prefix included
+	prefixedTags []string `graphql:"-"`
+}
+
+// tagRefs is a refs field, the newest tags by commit date named relative to the refPrefix asked for.
+type tagRefs struct {
+	Nodes []struct {
+		Name string
+	}
+}
+
+// releases returns the fetched releases with their notes, newest first. Drafts, which the token sees
+// if it has push access to the repo, are left out.
+func (node repositoryNode) releases() []repo.Release {
+	releases := make([]repo.Release, 0, len(node.Releases.Nodes))
+	for _, release := range node.Releases.Nodes {
+		if release.IsDraft {
+			continue
+		}
+
+		notes := repo.ReleaseNotes{
+			Name:        release.Name,
+			Description: release.Description,
+			PublishedAt: release.PublishedAt,
+		}
+		if release.Author != nil {
+			notes.Author = release.Author.Login
+		}
+
+		releases = append(releases, repo.Release{
+			CurrentReleaseTagName: release.TagName,
+			CurrentReleaseID:      release.ID,
+			IsPrerelease:          release.IsPrerelease,
+			Notes:                 notes,
+		})
+	}
+
+	return releases
+}
+
+// tags returns the names of the fetched tags, newest commit first, followed by those of the directories
+// not among them.
+func (node repositoryNode) tags() []string {
+	tags := make([]string, 0, len(node.Refs.Nodes)+len(node.prefixedTags))
+	seen := map[string]struct{}{}
+	for _, ref := range node.Refs.Nodes {
+		tags = append(tags, ref.Name)
+		seen[ref.Name] = struct{}{}
+	}
+	for _, tag := range node.prefixedTags {
+		if _, ok := seen[tag]; !ok {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
+
+func (node repositoryNode) toRepo() (repo.Repo, error) {
+	retrieved := repo.Repo{
+		RepoID: node.ID,
+		Name:   node.Name,
+		Owner:  node.Owner.Login,
+		Link:   node.URL,
+	}
+
+	// known even without releases, a chat may switch the repo to tags later on
+	retrieved.LatestTagName = newestTag(node.tags())
+	retrieved.TagsDigest = tagsDigest(node.tags())
+
+	releases := node.releases()
+	if len(releases) == 0 {
+		return retrieved, errors.ErrNoReleases
+	}
+
+	retrieved.Release = latestReleases(releases, repo.Release{LatestTagName: retrieved.LatestTagName, TagsDigest: retrieved.TagsDigest})
+
+	return retrieved, nil
+}
+
+func (bh BehaviorHandler) validateAndRetrieveRepo(ctx context.Context, owner, name string) (repo.Repo, error) {
+	variables := map[string]interface{}{
+		"name":  name,
+		"owner": owner,
+	}
+
+	var getRepoQuery struct {
+		Repository repositoryNode `graphql:"repository(name: $name, owner: $owner)"`
+		RateLimit  rateLimit
+	}
+
+	// chats may dig into the part of the budget update runs leave alone
+	err := bh.GitHubBudget.take(ctx, 0)
+	if err != nil {
+		return repo.Repo{}, err
+	}
+
+	err = bh.GQLClient.Query(ctx, &getRepoQuery, variables)
+	if err != nil {
+		return repo.Repo{}, err
+	}
+	bh.GitHubBudget.observe(getRepoQuery.RateLimit)
+
+	return getRepoQuery.Repository.toRepo()
+}
+
+type repoRef struct {
+	Owner, Name string
+	// TagPrefixes are directories of tags fetched on their own, the newest tags of a monorepo
+	// may all be in other directories
+	TagPrefixes []string
+}
+
+type retrievedRepo struct {
+	Repo repo.Repo
+	// Releases are the newest releases, newest first, Repo.Release is the first of them
+	Releases []repo.Release
+	// Tags are the newest tags by commit date
+	Tags []string
+	Err  error
+}
+
+// retrieveRepos resolves all refs with one GraphQL request, using an aliased repository field per ref.
+// Results line up with refs. A repo that fails to resolve only fails its own entry, the returned error
+// is reserved for requests that failed as a whole.
+func (bh BehaviorHandler) retrieveRepos(ctx context.Context, refs []repoRef) ([]retrievedRepo, error) {
+	if len(refs) == 0 {
+		return nil, nil
+	}
+
+	query, variables, err := batchQuery(refs)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := bh.GQLClient.ExecRaw(ctx, query, variables)
+	aliasErrors := map[string]error{}
+	if err != nil {
+		gqlErrors, ok := err.(graphql.Errors)
+		if !ok || len(data) == 0 {
+			return nil, err
+		}
+
+		for _, gqlErr := range gqlErrors {
+			alias := errorAlias(gqlErr)
+			if alias == "" {
+				// not tied to a single repo, nothing in data can be trusted
+				return nil, gqlErr
+			}
+			aliasErrors[alias] = gqlErr
+		}
+	}
+
+	fields := map[string]json.RawMessage{}
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	if raw, ok := fields[rateLimitField]; ok {
+		var limit rateLimit
+		err = json.Unmarshal(raw, &limit)
+		if err != nil {
+			return nil, err
+		}
+		bh.GitHubBudget.observe(limit)
+	}
+
+	results := make([]retrievedRepo, len(refs))
+	for i := range refs {
+		alias := batchAlias(i)
+
+		if aliasErr, ok := aliasErrors[alias]; ok {
+			results[i] = retrievedRepo{Err: aliasErr}
+			continue
+		}
+
+		var node *repositoryNode
+		if raw, ok := fields[alias]; ok {
+			err = json.Unmarshal(raw, &node)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if node == nil {
+			results[i] = retrievedRepo{Err: fmt.Errorf("no data returned for %s/%s", refs[i].Owner, refs[i].Name)}
+			continue
+		}
+
+		if len(refs[i].TagPrefixes) > 0 {
+			node.prefixedTags, err = prefixedTags(fields[alias], refs[i].TagPrefixes)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		retrieved, err := node.toRepo()
+		results[i] = retrievedRepo{Repo: retrieved, Releases: node.releases(), Tags: node.tags(), Err: err}
+	}
+
+	return results, nil
+}
+
+// latestReleases is the last seen release of a repo once releases, sorted newest first, have been seen.
+// A kind of release that doesn't show up in releases keeps its entry from previous.
+func latestReleases(releases []repo.Release, previous repo.Release) repo.Release {
+	if len(releases) == 0 {
+		return previous
+	}
+
+	latest := previous
+	latest.CurrentReleaseTagName, latest.CurrentReleaseID = releases[0].CurrentReleaseTagName, releases[0].CurrentReleaseID
+	latest.IsPrerelease = releases[0].IsPrerelease
+
+	stableFound, prereleaseFound := false, false
+	for _, release := range releases {
+		if release.IsPrerelease && !prereleaseFound {
+			latest.PrereleaseTagName, latest.PrereleaseID = release.CurrentReleaseTagName, release.CurrentReleaseID
+			prereleaseFound = true
+		}
+		if !release.IsPrerelease && !stableFound {
+			latest.StableReleaseTagName, latest.StableReleaseID = release.CurrentReleaseTagName, release.CurrentReleaseID
+			stableFound = true
+		}
+	}
+
+	return latest
+}
+
+// sameReleases tells if nothing was released or tagged between a and b.
+func sameReleases(a, b repo.Release) bool {
+	return a.CurrentReleaseID == b.CurrentReleaseID && a.StableReleaseID == b.StableReleaseID && a.PrereleaseID == b.PrereleaseID &&
+		a.LatestTagName == b.LatestTagName && a.TagsDigest == b.TagsDigest
+}
+
+// missedReleases picks the releases a chat hasn't heard of yet out of releases, which are sorted newest first.
+// Stable releases are compared against the last seen stable release and, if wantPrereleases is set, prereleases
+// against the last seen prerelease. Only releases passing filter are picked, up to maxAnnouncedReleases
+// of the newest are returned, oldest first.
+func missedReleases(releases []repo.Release, lastSeen repo.Release, wantPrereleases bool, filter tagFilter) []repo.Release {
+	missed := map[string]struct{}{}
+	for _, release := range missedOfKind(releases, lastSeen, false, filter) {
+		missed[release.CurrentReleaseID] = struct{}{}
+	}
+	if wantPrereleases {
+		for _, release := range missedOfKind(releases, lastSeen, true, filter) {
+			missed[release.CurrentReleaseID] = struct{}{}
+		}
+	}
+
+	picked := []repo.Release{}
+	for _, release := range releases {
+		if len(picked) == maxAnnouncedReleases {
+			break
+		}
+		if _, ok := missed[release.CurrentReleaseID]; ok {
+			picked = append(picked, release)
+		}
+	}
+
+	oldestFirst := make([]repo.Release, len(picked))
+	for i, release := range picked {
+		oldestFirst[len(picked)-1-i] = release
+	}
+
+	return oldestFirst
+}
+
+// missedOfKind returns the stable releases or prereleases passing filter that are newer than the last seen one
+// of that kind, newest first. The last seen release itself is looked for among all releases, filtered or not.
+// Repos stored before both kinds were tracked only know the current release, which is used instead. If the last
+// seen release is not among releases, because it was deleted or too many were published since, only the newest
+// release of the kind is returned.
+func missedOfKind(releases []repo.Release, lastSeen repo.Release, prerelease bool, filter tagFilter) []repo.Release {
+	lastSeenID := lastSeen.StableReleaseID
+	if prerelease {
+		lastSeenID = lastSeen.PrereleaseID
+	}
+
+	missed := []repo.Release{}
+	for _, release := range releases {
+		if release.CurrentReleaseID == lastSeenID || (lastSeenID == "" && release.CurrentReleaseID == lastSeen.CurrentReleaseID) {
+			return missed
+		}
+
+		if release.IsPrerelease == prerelease && filter.matches(release.CurrentReleaseTagName) {
+			missed = append(missed, release)
+		}
+	}
+
+	return missed[:min(len(missed), 1)]
+}
+
+func batchAlias(i int) string {
+	return fmt.Sprint("r", i)
+}
+
+func tagPrefixAlias(j int) string {
+	return fmt.Sprint("p", j)
+}
+
+// prefixedTags reads the tags of the aliased refs fields batchQuery added to a repository for prefixes.
+func prefixedTags(raw json.RawMessage, prefixes []string) ([]string, error) {
+	fields := map[string]json.RawMessage{}
+	err := json.Unmarshal(raw, &fields)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := []string{}
+	for j, prefix := range prefixes {
+		var refs tagRefs
+		if field, ok := fields[tagPrefixAlias(j)]; ok {
+			err = json.Unmarshal(field, &refs)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, ref := range refs.Nodes {
+			tags = append(tags, prefix+ref.Name)
+		}
+	}
+
+	return tags, nil
+}
+
+// batchQuery builds
+//
+//	query($o0: String!, $n0: String!, $p0_0: String!, ...) {
+//		r0: repository(owner: $o0, name: $n0) {... p0: refs(refPrefix: $p0_0, ...) {...}} ... rateLimit {...}
+//	}
+//
+// with a refs field for each of the tag prefixes of a ref.
+func batchQuery(refs []repoRef) (string, map[string]interface{}, error) {
+	fields, err := graphql.ConstructQuery(repositoryNode{}, nil)
+	if err != nil {
+		return "", nil, err
+	}
+
+	refsFields, err := graphql.ConstructQuery(tagRefs{}, nil)
+	if err != nil {
+		return "", nil, err
+	}
+
+	limitFields, err := graphql.ConstructQuery(rateLimit{}, nil)
+	if err != nil {
+		return "", nil, err
+	}
+
+	variables := make(map[string]interface{}, len(refs)*2)
+	arguments := make([]string, 0, len(refs)*2)
+	var selections strings.Builder
+
+	for i, ref := range refs {
+		owner, name := fmt.Sprint("o", i), fmt.Sprint("n", i)
+		variables[owner], variables[name] = ref.Owner, ref.Name
+		arguments = append(arguments, "$"+owner+": String!", "$"+name+": String!")
+
+		repoFields := strings.TrimSuffix(fields, "}")
+		for j, prefix := range ref.TagPrefixes {
+			variable := fmt.Sprint("p", i, "_", j)
+			variables[variable] = "refs/tags/" + prefix
+			arguments = append(arguments, "$"+variable+": String!")
+			repoFields += fmt.Sprintf(",%s: refs(refPrefix: $%s, first: 20, orderBy: {field: TAG_COMMIT_DATE, direction: DESC})%s",
+				tagPrefixAlias(j), variable, refsFields)
+		}
+
+		fmt.Fprintf(&selections, "%s: repository(owner: $%s, name: $%s)%s} ", batchAlias(i), owner, name, repoFields)
+	}
+
+	selections.WriteString(rateLimitField + limitFields + " ")
+
+	return "query(" + strings.Join(arguments, ", ") + ") { " + selections.String() + "}", variables, nil
+}
+
+// errorAlias returns the alias a GraphQL error belongs to, or "" when it isn't scoped to one.
+func errorAlias(gqlErr graphql.Error) string {
+	if len(gqlErr.Path) == 0 {
+		return ""
+	}
+
+	alias, _ := gqlErr.Path[0].(string)
+	return alias
+}
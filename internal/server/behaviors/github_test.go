@@ -0,0 +1,187 @@
+package behaviors
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chofnar/release-bot/internal/errors"
+	"github.com/chofnar/release-bot/internal/server/repo"
+	"github.com/hasura/go-graphql-client"
+)
+
+var ctx = context.Background()
+
+// fakeGitHub answers every GraphQL request with response.
+func fakeGitHub(t *testing.T, response string) BehaviorHandler {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+
+	return BehaviorHandler{GQLClient: graphql.NewClient(server.URL, server.Client())}
+}
+
+func TestBatchQuery(t *testing.T) {
+	query, variables, err := batchQuery([]repoRef{{Owner: "a", Name: "b"}, {Owner: "c", Name: "d", TagPrefixes: []string{"service/dynamodb/"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"$o0: String!", "$n1: String!",
+		"r0: repository(owner: $o0, name: $n0){",
+		"r1: repository(owner: $o1, name: $n1){",
+		"releases(first: 10, orderBy: {field: CREATED_AT, direction: DESC}){nodes{tagName,id,isPrerelease,isDraft,name,description,publishedAt,author{login}}}",
+		`refs(refPrefix: "refs/tags/", first: 20, orderBy: {field: TAG_COMMIT_DATE, direction: DESC}){nodes{name}}`,
+		"$p1_0: String!",
+		`{nodes{name}},p0: refs(refPrefix: $p1_0, first: 20, orderBy: {field: TAG_COMMIT_DATE, direction: DESC}){nodes{name}}} rateLimit`,
+		"rateLimit{cost,remaining,resetAt}",
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query %q does not contain %q", query, want)
+		}
+	}
+
+	if variables["o1"] != "c" || variables["n1"] != "d" || variables["p1_0"] != "refs/tags/service/dynamodb/" {
+		t.Errorf("unexpected variables %v", variables)
+	}
+}
+
+func TestRetrieveReposPartialErrors(t *testing.T) {
+	bh := fakeGitHub(t, `{
+		"data": {
+			"r0": {"id": "R_0", "url": "https://github.com/a/b", "name": "b", "owner": {"login": "a"},
+				"releases": {"nodes": [{"tagName": "v1.0.0", "id": "RE_0", "isPrerelease": false,
+					"name": "First", "description": "* init", "publishedAt": "2025-01-02T03:04:05Z", "author": null}]}},
+			"r1": null,
+			"r2": {"id": "R_2", "url": "https://github.com/e/f", "name": "f", "owner": {"login": "e"},
+				"releases": {"nodes": [{"tagName": "v0.1.0", "id": "RE_2", "isPrerelease": false, "isDraft": true}]}}
+		},
+		"errors": [{"type": "NOT_FOUND", "path": ["r1"], "message": "Could not resolve to a Repository with the name 'c/d'."}]
+	}`)
+
+	results, err := bh.retrieveRepos(ctx, []repoRef{{Owner: "a", Name: "b"}, {Owner: "c", Name: "d"}, {Owner: "e", Name: "f"}})
+	if err != nil {
+		t.Fatalf("partial failure failed the whole batch: %v", err)
+	}
+
+	if results[0].Err != nil || results[0].Repo.RepoID != "R_0" || results[0].Repo.CurrentReleaseTagName != "v1.0.0" {
+		t.Errorf("r0 = %+v", results[0])
+	}
+	if notes := results[0].Releases[0].Notes; notes.Name != "First" || notes.Description != "* init" || notes.PublishedAt.Day() != 2 || notes.Author != "" {
+		t.Errorf("r0 notes = %+v", notes)
+	}
+	if results[1].Err == nil || !strings.Contains(results[1].Err.Error(), "Could not resolve to a Repository with the name") {
+		t.Errorf("r1 error = %v, want the resolve error", results[1].Err)
+	}
+	if results[2].Err != errors.ErrNoReleases || results[2].Repo.RepoID != "R_2" {
+		t.Errorf("r2 = %+v, want ErrNoReleases since its only release is a draft", results[2])
+	}
+}
+
+func TestRetrieveReposUnscopedError(t *testing.T) {
+	bh := fakeGitHub(t, `{"data": null, "errors": [{"type": "RATE_LIMITED", "message": "API rate limit exceeded"}]}`)
+
+	_, err := bh.retrieveRepos(ctx, []repoRef{{Owner: "a", Name: "b"}})
+	if err == nil {
+		t.Fatal("expected the whole batch to fail")
+	}
+}
+
+func TestMissedReleases(t *testing.T) {
+	newestFirst := make([]repo.Release, 10)
+	for i := range newestFirst {
+		newestFirst[i] = repo.Release{CurrentReleaseID: fmt.Sprint("RE_", 9-i)}
+	}
+
+	ids := func(releases []repo.Release) string {
+		var ids []string
+		for _, release := range releases {
+			ids = append(ids, release.CurrentReleaseID)
+		}
+		return strings.Join(ids, ",")
+	}
+
+	cases := []struct {
+		name, lastSeen, want string
+	}{
+		{"up to date", "RE_9", ""},
+		{"two missed", "RE_7", "RE_8,RE_9"},
+		{"capped to the newest", "RE_0", "RE_5,RE_6,RE_7,RE_8,RE_9"},
+		{"last seen unknown", "RE_deleted", "RE_9"},
+	}
+
+	for _, c := range cases {
+		lastSeen := repo.Release{StableReleaseID: c.lastSeen}
+		if got := ids(missedReleases(newestFirst, lastSeen, false, tagFilter{})); got != c.want {
+			t.Errorf("%s: missedReleases = %q, want %q", c.name, got, c.want)
+		}
+	}
+
+	if got := missedReleases(nil, repo.Release{StableReleaseID: "RE_1"}, true, tagFilter{}); len(got) != 0 {
+		t.Errorf("missedReleases without releases = %v, want none", got)
+	}
+}
+
+func TestMissedReleasesByKind(t *testing.T) {
+	newestFirst := []repo.Release{
+		{CurrentReleaseID: "RC_2", IsPrerelease: true},
+		{CurrentReleaseID: "RE_2"},
+		{CurrentReleaseID: "RC_1", IsPrerelease: true},
+		{CurrentReleaseID: "RE_1"},
+	}
+
+	ids := func(releases []repo.Release) string {
+		var ids []string
+		for _, release := range releases {
+			ids = append(ids, release.CurrentReleaseID)
+		}
+		return strings.Join(ids, ",")
+	}
+
+	cases := []struct {
+		name            string
+		lastSeen        repo.Release
+		wantPrereleases bool
+		want            string
+	}{
+		{"stable only", repo.Release{StableReleaseID: "RE_1", PrereleaseID: "RC_1"}, false, "RE_2"},
+		{"with prereleases", repo.Release{StableReleaseID: "RE_1", PrereleaseID: "RC_1"}, true, "RE_2,RC_2"},
+		// a prerelease published after the newest stable one must not hide it
+		{"stable behind a prerelease", repo.Release{CurrentReleaseID: "RC_2", StableReleaseID: "RE_1", PrereleaseID: "RC_2"}, false, "RE_2"},
+		{"up to date", repo.Release{StableReleaseID: "RE_2", PrereleaseID: "RC_2"}, true, ""},
+		// repos stored before both kinds were tracked only know the current release
+		{"upgraded", repo.Release{CurrentReleaseID: "RE_2"}, true, "RC_2"},
+	}
+
+	for _, c := range cases {
+		if got := ids(missedReleases(newestFirst, c.lastSeen, c.wantPrereleases, tagFilter{})); got != c.want {
+			t.Errorf("%s: missedReleases = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestLatestReleases(t *testing.T) {
+	previous := repo.Release{
+		CurrentReleaseID: "RE_1", StableReleaseID: "RE_1", PrereleaseID: "RC_0",
+	}
+
+	got := latestReleases([]repo.Release{{CurrentReleaseID: "RC_2", IsPrerelease: true}}, previous)
+	want := repo.Release{
+		CurrentReleaseID: "RC_2", IsPrerelease: true, StableReleaseID: "RE_1", PrereleaseID: "RC_2",
+	}
+	if got != want {
+		t.Errorf("latestReleases = %+v, want %+v", got, want)
+	}
+
+	if got := latestReleases(nil, previous); got != previous {
+		t.Errorf("latestReleases without releases = %+v, want %+v", got, previous)
+	}
+}
@@ -0,0 +1,48 @@
+package behaviors
+
+import (
+	"context"
+
+	"github.com/chofnar/release-bot/internal/server/messages"
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// ChatAdmin tells if the user created the chat or is one of its administrators, and if they stay anonymous.
+// The messages of anonymous admins are sent as the chat itself.
+func (bh BehaviorHandler) ChatAdmin(ctx context.Context, chatID, userID int64) (admin, anonymous bool, err error) {
+	member, err := bh.Sender.GetChatMember(ctx, &telego.GetChatMemberParams{
+		ChatID: tu.ID(chatID),
+		UserID: userID,
+	})
+	if err != nil {
+		return false, false, err
+	}
+
+	switch member := member.(type) {
+	case *telego.ChatMemberOwner:
+		return true, member.IsAnonymous, nil
+	case *telego.ChatMemberAdministrator:
+		return true, member.IsAnonymous, nil
+	}
+
+	return false, false, nil
+}
+
+// AskForReply asks the member of a group who tapped a button for the answer as a reply to the bot's message,
+// nil asks an anonymous admin.
+func (bh BehaviorHandler) AskForReply(ctx context.Context, chatID int64, member *telego.User, prompt string) error {
+	_, err := bh.Sender.SendMessage(ctx, messages.ReplyPromptMessage(chatID, member, prompt))
+	return err
+}
+
+// AdminsOnly tells a member who sent a command changing the chat's settings that only admins may.
+func (bh BehaviorHandler) AdminsOnly(ctx context.Context, chatID int64) error {
+	_, err := bh.Sender.SendMessage(ctx, messages.AdminsOnlyMessage(chatID))
+	return err
+}
+
+// AdminsOnlyAlert answers a member who tapped a button managing the chat's repos or settings.
+func (bh BehaviorHandler) AdminsOnlyAlert(ctx context.Context, queryID string) error {
+	return bh.Sender.AnswerCallbackQuery(ctx, messages.AdminsOnlyAlert(queryID))
+}
@@ -0,0 +1,156 @@
+package behaviors
+
+import (
+	"context"
+	stderrors "errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chofnar/release-bot/internal/errors"
+	"github.com/chofnar/release-bot/internal/server/messages"
+	"github.com/chofnar/release-bot/internal/server/repo"
+	ta "github.com/mymmrac/telego/telegoapi"
+	"go.uber.org/zap"
+)
+
+const (
+	// outboxLease is how long a message just added to the outbox is left to the one delivering it,
+	// DeliverOutbox only picks it up after that, as if its first attempt failed.
+	outboxLease = 5 * time.Minute
+	// outboxBaseDelay is the wait after the first failed attempt, it doubles with every attempt up to outboxMaxDelay.
+	outboxBaseDelay = 30 * time.Second
+	outboxMaxDelay  = 2 * time.Hour
+	// outboxMaxAttempts makes for about a day of retries before a message is given up on.
+	outboxMaxAttempts = 16
+	// outboxRetention is how long the keys of delivered messages are kept, a release announced
+	// again within that time isn't sent again.
+	outboxRetention = 30 * 24 * time.Hour
+)
+
+// outboxKey identifies the notification of a chat about a release, or a tag as kind tells, of a repo.
+func outboxKey(kind, chatID, repoID, tagName string) string {
+	return kind + ":" + chatID + ":" + repoID + ":" + tagName
+}
+
+// retryDelay is how long to wait for the next attempt after the given number of failed ones.
+func retryDelay(attempts int) time.Duration {
+	delay := outboxBaseDelay
+	for range attempts - 1 {
+		delay *= 2
+		if delay >= outboxMaxDelay {
+			return outboxMaxDelay
+		}
+	}
+
+	return delay
+}
+
+// chatGone tells if Telegram refused a message because the chat can't get messages from the bot anymore: it was
+// blocked, kicked out of the group, the user deactivated their account, or the chat doesn't exist anymore.
+func chatGone(err error) bool {
+	var apiErr *ta.Error
+	if !stderrors.As(err, &apiErr) {
+		return false
+	}
+
+	switch apiErr.ErrorCode {
+	case http.StatusForbidden:
+		return true
+	case http.StatusBadRequest:
+		return apiErr.Description == "Bad Request: chat not found"
+	}
+
+	return false
+}
+
+// migratedTo tells the ID a group upgraded to a supergroup goes on under, if Telegram refused a message for that.
+func migratedTo(err error) (string, bool) {
+	var apiErr *ta.Error
+	if !stderrors.As(err, &apiErr) || apiErr.Parameters == nil || apiErr.Parameters.MigrateToChatID == 0 {
+		return "", false
+	}
+
+	return strconv.FormatInt(apiErr.Parameters.MigrateToChatID, 10), true
+}
+
+// deliver makes an attempt at sending a message from the outbox. A failed attempt is retried later, waiting longer
+// after every one, a chat that blocked the bot or is gone is unsubscribed from the repo instead. A group upgraded
+// to a supergroup takes its subscriptions and settings along, the message is sent there right away.
+func (bh BehaviorHandler) deliver(ctx context.Context, logger zap.SugaredLogger, message repo.OutboxMessage) error {
+	_, err := bh.Sender.SendMessage(ctx, messages.SendOutboxMessage(message, false))
+	if err != nil && message.PlainText != "" && strings.Contains(err.Error(), "can't parse entities") {
+		// the notes didn't convert into something Telegram accepts, they still make it as plain text
+		_, err = bh.Sender.SendMessage(ctx, messages.SendOutboxMessage(message, true))
+	}
+
+	now := time.Now()
+	if err == nil {
+		return bh.DB.MarkDelivered(ctx, message.Key, now)
+	}
+
+	if to, migrated := migratedTo(err); migrated {
+		errdb := bh.DB.MoveChat(ctx, message.ChatID, to)
+		if errdb == nil {
+			message.ChatID = to
+			return bh.deliver(ctx, logger, message)
+		}
+		// retried like any other failure, the move is finished then
+		logger.Error(errdb)
+	}
+
+	if chatGone(err) {
+		errdb := bh.DB.RemoveRepo(ctx, message.ChatID, message.RepoID)
+		if errdb != nil && errdb != errors.ErrRepoNotFound {
+			logger.Error(errdb)
+		}
+		return bh.DB.MarkDelivered(ctx, message.Key, now)
+	}
+
+	attempts := message.Attempts + 1
+	if attempts >= outboxMaxAttempts {
+		logger.Errorf("giving up on %s after %d attempts", message.Key, attempts)
+		errdb := bh.DB.MarkDelivered(ctx, message.Key, now)
+		if errdb != nil {
+			logger.Error(errdb)
+		}
+		return err
+	}
+
+	delay := retryDelay(attempts)
+	if after, limited := retryAfter(err); limited {
+		delay = max(delay, after)
+	}
+
+	errdb := bh.DB.RetryOutbox(ctx, message.Key, attempts, now.Add(delay), err.Error())
+	if errdb != nil {
+		logger.Error(errdb)
+	}
+	return err
+}
+
+// DeliverOutbox retries the messages of the outbox whose next attempt is due at now, and forgets
+// the messages delivered longer than outboxRetention ago.
+func (bh BehaviorHandler) DeliverOutbox(ctx context.Context, logger zap.SugaredLogger, now time.Time) []erroredRepo {
+	failedRepos := []erroredRepo{}
+
+	err := bh.DB.PruneOutbox(ctx, now.Add(-outboxRetention))
+	if err != nil {
+		failedRepos = append(failedRepos, erroredRepo{Err: err})
+	}
+
+	due, err := bh.DB.DueOutbox(ctx, now)
+	if err != nil {
+		return append(failedRepos, erroredRepo{Err: err})
+	}
+
+	for _, message := range due {
+		err = bh.deliver(ctx, logger, message)
+		if err != nil {
+			failedRepos = append(failedRepos, erroredRepo{Err: err, Repo: repo.Repo{RepoID: message.RepoID}})
+		}
+	}
+
+	return failedRepos
+}
@@ -0,0 +1,267 @@
+package behaviors
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chofnar/release-bot/internal/database/memory"
+	"github.com/chofnar/release-bot/internal/server/repo"
+	"github.com/mymmrac/telego"
+	ta "github.com/mymmrac/telego/telegoapi"
+	"go.uber.org/zap"
+)
+
+// flakyTelegram fails the first failures sendMessage calls with a server error and accepts the rest,
+// counting every call.
+func flakyTelegram(t *testing.T, calls *atomic.Int32, failures int32) *telego.Bot {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/sendMessage") {
+			t.Errorf("unexpected call to %s", r.URL.Path)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if calls.Add(1) <= failures {
+			_, _ = w.Write([]byte(`{"ok":false,"error_code":500,"description":"Internal Server Error"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":1,"date":0,"chat":{"id":1,"type":"private"}}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	bot, err := telego.NewBot("123456:"+strings.Repeat("a", 35), telego.WithAPIServer(server.URL), telego.WithDiscardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bot
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		5:  8 * time.Minute,
+		9:  outboxMaxDelay,
+		15: outboxMaxDelay,
+	} {
+		if got := retryDelay(attempts); got != want {
+			t.Errorf("retryDelay(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestChatGone(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{&ta.Error{ErrorCode: 403, Description: "Forbidden: bot was blocked by the user"}, true},
+		{&ta.Error{ErrorCode: 403, Description: "Forbidden: bot was kicked from the supergroup chat"}, true},
+		{&ta.Error{ErrorCode: 403, Description: "Forbidden: user is deactivated"}, true},
+		{&ta.Error{ErrorCode: 400, Description: "Bad Request: chat not found"}, true},
+		{&ta.Error{ErrorCode: 400, Description: "Bad Request: group chat was upgraded to a supergroup chat", Parameters: &ta.ResponseParameters{MigrateToChatID: -1001}}, false},
+		{&ta.Error{ErrorCode: 400, Description: "Bad Request: can't parse entities"}, false},
+		{&ta.Error{ErrorCode: 429, Description: "Too Many Requests: retry after 5"}, false},
+		{fmt.Errorf("telego: sendMessage: %w", &ta.Error{ErrorCode: 403, Description: "Forbidden: bot was kicked from the group chat"}), true},
+		{stderrors.New("connection reset by peer"), false},
+	} {
+		if got := chatGone(test.err); got != test.want {
+			t.Errorf("chatGone(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestOutboxDelivery(t *testing.T) {
+	logger := *zap.NewNop().Sugar()
+	db := memory.New(logger)
+	watched := repo.Repo{
+		RepoID:  "R_1",
+		Name:    "name",
+		Owner:   "owner",
+		Link:    "https://github.com/owner/name",
+		Release: repo.Release{CurrentReleaseTagName: "v1.0.0", StableReleaseTagName: "v1.0.0"},
+	}
+	if err := db.AddRepo(ctx, "1", &watched); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls atomic.Int32
+	bh := BehaviorHandler{Sender: NewSender(flakyTelegram(t, &calls, 1)), DB: db}
+
+	release := repo.Release{CurrentReleaseTagName: "v1.1.0", CurrentReleaseID: "release-2", StableReleaseTagName: "v1.1.0"}
+	latest := watched
+	latest.Release = release
+
+	// the first attempt fails, the release still counts as seen
+	if failed := bh.announceReleases(ctx, logger, watched, latest, []repo.Release{release}, nil); len(failed) != 1 {
+		t.Errorf("announceReleases failures = %+v, want the failed send", failed)
+	}
+	if repos, _ := db.GetRepos(ctx, "1"); repos[0].CurrentReleaseTagName != "v1.1.0" {
+		t.Errorf("last seen release is %s", repos[0].CurrentReleaseTagName)
+	}
+
+	if failed := bh.DeliverOutbox(ctx, logger, time.Now()); len(failed) != 0 || calls.Load() != 1 {
+		t.Errorf("retried before the backoff ran out: %+v, %d calls", failed, calls.Load())
+	}
+	if failed := bh.DeliverOutbox(ctx, logger, time.Now().Add(outboxBaseDelay)); len(failed) != 0 || calls.Load() != 2 {
+		t.Errorf("retry failed: %+v, %d calls", failed, calls.Load())
+	}
+
+	// announcing the same release again, as after a failed update of the last seen release, sends nothing
+	if failed := bh.announceReleases(ctx, logger, watched, latest, []repo.Release{release}, nil); len(failed) != 0 {
+		t.Errorf("announceReleases failures = %+v", failed)
+	}
+	if failed := bh.DeliverOutbox(ctx, logger, time.Now().Add(outboxLease)); len(failed) != 0 || calls.Load() != 2 {
+		t.Errorf("release sent again: %+v, %d calls", failed, calls.Load())
+	}
+}
+
+// unqueueable fails the first failures messages put into the outbox.
+type unqueueable struct {
+	*memory.Driver
+	failures int
+}
+
+func (db *unqueueable) EnqueueOutbox(ctx context.Context, message repo.OutboxMessage) (bool, error) {
+	if db.failures > 0 {
+		db.failures--
+		return false, stderrors.New("outbox unavailable")
+	}
+	return db.Driver.EnqueueOutbox(ctx, message)
+}
+
+func TestTagsAnnouncedAfterFailedEnqueue(t *testing.T) {
+	logger := *zap.NewNop().Sugar()
+	db := &unqueueable{Driver: memory.New(logger), failures: 1}
+	watched := repo.Repo{
+		RepoID:  "R_1",
+		Name:    "name",
+		Owner:   "owner",
+		Link:    "https://github.com/owner/name",
+		Release: repo.Release{LatestTagName: "v1.0.0", TagsDigest: "v1.0.0"},
+	}
+	if err := db.AddRepo(ctx, "1", &watched); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetTrackTags(ctx, "1", "R_1", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetLastTag(ctx, "1", "R_1", "v1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls atomic.Int32
+	bh := BehaviorHandler{Sender: NewSender(flakyTelegram(t, &calls, 0)), DB: db}
+
+	tags := []string{"v1.1.0", "v1.0.0"}
+	latest := watched
+	latest.Release = repo.Release{LatestTagName: "v1.1.0", TagsDigest: "v1.1.0"}
+
+	if failed := bh.announceReleases(ctx, logger, watched, latest, nil, tags); len(failed) != 1 || calls.Load() != 0 {
+		t.Errorf("announceReleases failures = %+v, %d calls, want the failed enqueue", failed, calls.Load())
+	}
+	repos, err := db.GetRepos(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repos[0].TagsDigest != "v1.0.0" {
+		t.Fatalf("stored the tags of a run that failed to announce them: %s", repos[0].TagsDigest)
+	}
+
+	// the next run sees the tags changed against what's stored and tries again
+	if sameReleases(repos[0].Release, latest.Release) {
+		t.Fatal("the next run would skip the repo")
+	}
+	if failed := bh.announceReleases(ctx, logger, repos[0], latest, nil, tags); len(failed) != 0 || calls.Load() != 1 {
+		t.Errorf("retry failed: %+v, %d calls", failed, calls.Load())
+	}
+	if repos, _ := db.GetRepos(ctx, "1"); repos[0].TagsDigest != "v1.1.0" {
+		t.Errorf("tags stored after the retry are %s", repos[0].TagsDigest)
+	}
+}
+
+// upgradedGroupTelegram refuses messages to the group -1, which became the supergroup -1001, and records
+// the chats of the messages it accepts.
+func upgradedGroupTelegram(t *testing.T, sent *[]string) *telego.Bot {
+	t.Helper()
+
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params struct {
+			ChatID json.Number `json:"chat_id"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&params)
+
+		w.Header().Set("Content-Type", "application/json")
+		if params.ChatID == "-1" {
+			_, _ = w.Write([]byte(`{"ok":false,"error_code":400,"description":"Bad Request: group chat was upgraded to a supergroup chat",` +
+				`"parameters":{"migrate_to_chat_id":-1001}}`))
+			return
+		}
+
+		mu.Lock()
+		*sent = append(*sent, params.ChatID.String())
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":1,"date":0,"chat":{"id":-1001,"type":"supergroup"}}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	bot, err := telego.NewBot("123456:"+strings.Repeat("a", 35), telego.WithAPIServer(server.URL), telego.WithDiscardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bot
+}
+
+func TestDeliverToUpgradedGroup(t *testing.T) {
+	logger := *zap.NewNop().Sugar()
+	db := memory.New(logger)
+	watched := repo.Repo{
+		RepoID:  "R_1",
+		Name:    "name",
+		Owner:   "owner",
+		Link:    "https://github.com/owner/name",
+		Release: repo.Release{CurrentReleaseTagName: "v1.0.0", StableReleaseTagName: "v1.0.0"},
+	}
+	if err := db.AddRepo(ctx, "-1", &watched); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetTemplate(ctx, "-1", "{{.Tag}}"); err != nil {
+		t.Fatal(err)
+	}
+
+	var sent []string
+	bh := BehaviorHandler{Sender: NewSender(upgradedGroupTelegram(t, &sent)), DB: db}
+
+	release := repo.Release{CurrentReleaseTagName: "v1.1.0", CurrentReleaseID: "release-2", StableReleaseTagName: "v1.1.0"}
+	latest := watched
+	latest.Release = release
+	if failed := bh.announceReleases(ctx, logger, watched, latest, []repo.Release{release}, nil); len(failed) != 0 {
+		t.Errorf("announceReleases failures = %+v", failed)
+	}
+
+	if len(sent) != 1 || sent[0] != "-1001" {
+		t.Errorf("sent to %v, want the supergroup", sent)
+	}
+	if repos, _ := db.GetRepos(ctx, "-1001"); len(repos) != 1 {
+		t.Errorf("the supergroup has %d repos, want the group's", len(repos))
+	}
+	if settings, _ := db.GetChatSettings(ctx, "-1001"); settings.Template != "{{.Tag}}" {
+		t.Errorf("the supergroup has settings %+v, want the group's", settings)
+	}
+	if due, _ := db.DueOutbox(ctx, time.Now().Add(outboxLease)); len(due) != 0 {
+		t.Errorf("still due: %+v", due)
+	}
+}
@@ -0,0 +1,123 @@
+package behaviors
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chofnar/release-bot/internal/errors"
+	"github.com/chofnar/release-bot/internal/server/consts"
+	"github.com/chofnar/release-bot/internal/server/messages"
+	"github.com/chofnar/release-bot/internal/server/repo"
+)
+
+// inQuietHours tells if now falls into the quiet hours of a chat.
+func inQuietHours(settings repo.ChatSettings, now time.Time) bool {
+	from, until := settings.QuietFrom, settings.QuietUntil
+	if from == until {
+		return false
+	}
+
+	hour := now.In(chatLocation(settings.TimeZone)).Hour()
+	if from < until {
+		return hour >= from && hour < until
+	}
+
+	// past midnight
+	return hour >= from || hour < until
+}
+
+// holdsReleases tells if the releases a chat is told about at now wait in the queue, for its next digest
+// or for its quiet hours to end.
+func holdsReleases(settings repo.ChatSettings, now time.Time) bool {
+	return settings.Delivery.Digest() || (inQuietHours(settings, now) && settings.QuietMode != repo.QuietSilent)
+}
+
+// Settings shows the settings applying to all of the chat's repos.
+func (bh BehaviorHandler) Settings(ctx context.Context, chatID int64, messageID int) error {
+	settings, err := bh.DB.GetChatSettings(ctx, fmt.Sprint(chatID))
+	if err != nil {
+		return err
+	}
+
+	_, err = bh.Sender.EditMessageText(ctx, messages.SettingsMessage(chatID, messageID, settings))
+	if err != nil && strings.Contains(err.Error(), "message is not modified") {
+		// tapping one of the labels shows the settings again as they are
+		return nil
+	}
+	return err
+}
+
+// SetQuietHours sets the quiet hours to those in data, QUIET_<from>_<until>.
+func (bh BehaviorHandler) SetQuietHours(ctx context.Context, chatID int64, messageID int, data string) error {
+	fromStr, untilStr, found := strings.Cut(strings.TrimPrefix(data, consts.QuietHoursOperationPrefix), "_")
+	from, errFrom := strconv.Atoi(fromStr)
+	until, errUntil := strconv.Atoi(untilStr)
+	if !found || errFrom != nil || errUntil != nil || from < 0 || from > 23 || until < 0 || until > 23 {
+		return errors.ErrInvalidCallbackData
+	}
+
+	settings, err := bh.DB.GetChatSettings(ctx, fmt.Sprint(chatID))
+	if err != nil {
+		return err
+	}
+
+	err = messages.SetQuietHours(ctx, chatID, from, until, settings.QuietMode, &bh.DB)
+	if err != nil {
+		return err
+	}
+
+	return bh.Settings(ctx, chatID, messageID)
+}
+
+// FlipQuietMode sets what happens to releases found during quiet hours to the mode in data, QMODE_<mode>.
+func (bh BehaviorHandler) FlipQuietMode(ctx context.Context, chatID int64, messageID int, data string) error {
+	mode := repo.QuietMode(strings.TrimPrefix(data, consts.QuietModeOperationPrefix))
+	if mode != repo.QuietHold && mode != repo.QuietSilent {
+		return errors.ErrInvalidCallbackData
+	}
+
+	settings, err := bh.DB.GetChatSettings(ctx, fmt.Sprint(chatID))
+	if err != nil {
+		return err
+	}
+
+	err = messages.SetQuietHours(ctx, chatID, settings.QuietFrom, settings.QuietUntil, mode, &bh.DB)
+	if err != nil {
+		return err
+	}
+
+	return bh.Settings(ctx, chatID, messageID)
+}
+
+// AskTimeZone asks the chat for its time zone, the next message it sends is handed to SentTimeZone.
+func (bh BehaviorHandler) AskTimeZone(ctx context.Context, chatID int64, messageID int) error {
+	_, err := bh.Sender.EditMessageText(ctx, messages.TimeZoneMessage(chatID, messageID))
+	return err
+}
+
+// SentTimeZone stores the time zone the chat sent, an IANA name like Europe/Berlin. It tells whether
+// the time zone was accepted, the chat is asked again otherwise.
+func (bh BehaviorHandler) SentTimeZone(ctx context.Context, messageText string, chatID int64) (bool, error) {
+	timeZone := strings.TrimSpace(messageText)
+	// Local is wherever the bot runs, and "" would be UTC
+	if _, err := time.LoadLocation(timeZone); err != nil || timeZone == "Local" || timeZone == "" {
+		_, err = bh.Sender.SendMessage(ctx, messages.TimeZoneInvalidMessage(chatID))
+		return false, err
+	}
+
+	err := messages.SetTimeZone(ctx, chatID, timeZone, &bh.DB)
+	if err != nil {
+		return false, err
+	}
+
+	settings, err := bh.DB.GetChatSettings(ctx, fmt.Sprint(chatID))
+	if err != nil {
+		return true, err
+	}
+
+	_, err = bh.Sender.SendMessage(ctx, messages.TimeZoneSavedMessage(chatID, settings))
+	return true, err
+}
@@ -0,0 +1,56 @@
+package behaviors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chofnar/release-bot/internal/server/repo"
+)
+
+func TestInQuietHours(t *testing.T) {
+	at := func(hour int) time.Time {
+		return time.Date(2025, 3, 5, hour, 30, 0, 0, time.UTC)
+	}
+
+	for _, test := range []struct {
+		settings repo.ChatSettings
+		now      time.Time
+		want     bool
+	}{
+		{repo.ChatSettings{}, at(3), false},
+		{repo.ChatSettings{QuietFrom: 22, QuietUntil: 7}, at(23), true},
+		{repo.ChatSettings{QuietFrom: 22, QuietUntil: 7}, at(3), true},
+		{repo.ChatSettings{QuietFrom: 22, QuietUntil: 7}, at(7), false},
+		{repo.ChatSettings{QuietFrom: 22, QuietUntil: 7}, at(12), false},
+		{repo.ChatSettings{QuietFrom: 1, QuietUntil: 6}, at(1), true},
+		{repo.ChatSettings{QuietFrom: 1, QuietUntil: 6}, at(6), false},
+		// 04:30 in Tokyo
+		{repo.ChatSettings{QuietFrom: 22, QuietUntil: 7, TimeZone: "Asia/Tokyo"}, at(19), true},
+		{repo.ChatSettings{QuietFrom: 22, QuietUntil: 7, TimeZone: "Asia/Tokyo"}, at(3), false},
+	} {
+		if got := inQuietHours(test.settings, test.now); got != test.want {
+			t.Errorf("inQuietHours(%+v, %v) = %v, want %v", test.settings, test.now, got, test.want)
+		}
+	}
+}
+
+func TestHoldsReleases(t *testing.T) {
+	night := time.Date(2025, 3, 5, 3, 0, 0, 0, time.UTC)
+	quiet := repo.ChatSettings{QuietFrom: 22, QuietUntil: 7}
+
+	if !holdsReleases(quiet, night) {
+		t.Error("release sent during quiet hours")
+	}
+	if holdsReleases(quiet, night.Add(6*time.Hour)) {
+		t.Error("release held after quiet hours")
+	}
+
+	quiet.QuietMode = repo.QuietSilent
+	if holdsReleases(quiet, night) {
+		t.Error("release held for a chat that wants it silently")
+	}
+
+	if !holdsReleases(repo.ChatSettings{Delivery: repo.DeliveryDaily}, night) {
+		t.Error("release not held for the digest")
+	}
+}
@@ -0,0 +1,117 @@
+package behaviors
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/chofnar/release-bot/internal/errors"
+	"golang.org/x/time/rate"
+)
+
+// githubRequestsPerSecond keeps the update workers well below GitHub's secondary rate limits.
+const githubRequestsPerSecond = 5
+
+// updateReserve is the part of the GraphQL rate limit update runs leave to chats adding repos.
+const updateReserve = 100
+
+// rateLimitField is the name of the rateLimit field in the batch query.
+const rateLimitField = "rateLimit"
+
+// rateLimit is what GitHub reports about the GraphQL rate limit of the token.
+type rateLimit struct {
+	Cost      int
+	Remaining int
+	ResetAt   string
+}
+
+// GitHubBudget paces GitHub requests and keeps track of the rate limit GitHub reports in their responses.
+// A nil *GitHubBudget lets every request through.
+type GitHubBudget struct {
+	limiter *rate.Limiter
+
+	mu        sync.Mutex
+	remaining int
+	// resetAt is zero until GitHub reported a rate limit for the current window
+	resetAt time.Time
+	// cost of the last request, used as the estimate for the next one
+	cost int
+}
+
+func NewGitHubBudget() *GitHubBudget {
+	return &GitHubBudget{
+		limiter: rate.NewLimiter(githubRequestsPerSecond, 1),
+		cost:    1,
+	}
+}
+
+// take waits for the next request slot and claims the estimated cost of the request.
+// It fails with errors.ErrRateLimitLow if fewer than keep points would be left afterwards.
+func (budget *GitHubBudget) take(ctx context.Context, keep int) error {
+	if budget == nil {
+		return nil
+	}
+
+	err := budget.limiter.Wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+
+	if !budget.resetAt.IsZero() && time.Now().After(budget.resetAt) {
+		budget.resetAt = time.Time{}
+	}
+
+	// nothing known about this window yet, the response will tell
+	if budget.resetAt.IsZero() {
+		return nil
+	}
+
+	if budget.remaining-budget.cost < keep {
+		return errors.ErrRateLimitLow
+	}
+	budget.remaining -= budget.cost
+
+	return nil
+}
+
+// observe records the rate limit reported with a response. Responses of concurrent requests
+// can arrive out of order, within one window the lowest remaining count wins.
+func (budget *GitHubBudget) observe(limit rateLimit) {
+	if budget == nil {
+		return
+	}
+
+	resetAt, err := time.Parse(time.RFC3339, limit.ResetAt)
+	if err != nil {
+		return
+	}
+
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+
+	if resetAt.Equal(budget.resetAt) {
+		budget.remaining = min(budget.remaining, limit.Remaining)
+	} else if resetAt.After(budget.resetAt) {
+		budget.resetAt = resetAt
+		budget.remaining = limit.Remaining
+	}
+
+	if limit.Cost > 0 {
+		budget.cost = limit.Cost
+	}
+}
+
+// resetTime returns when the current window ends, zero if unknown.
+func (budget *GitHubBudget) resetTime() time.Time {
+	if budget == nil {
+		return time.Time{}
+	}
+
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+
+	return budget.resetAt
+}
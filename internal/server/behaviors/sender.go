@@ -0,0 +1,178 @@
+package behaviors
+
+import (
+	"context"
+	stderrors "errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mymmrac/telego"
+	ta "github.com/mymmrac/telego/telegoapi"
+	"golang.org/x/time/rate"
+)
+
+// telegramMessagesPerSecond and chatMessageInterval keep to the limits of the Bot API, about 30 messages
+// a second overall and one a second per chat, see https://core.telegram.org/bots/faq#my-bot-is-hitting-limits-how-do-i-avoid-this
+const (
+	telegramMessagesPerSecond = 30
+	chatMessageInterval       = time.Second
+)
+
+// sendRetries is how often a message Telegram refused with 429 Too Many Requests is tried again.
+const sendRetries = 3
+
+// maxIdleChatLimiters is how many chats the Sender keeps limiters for before it drops those of idle chats.
+const maxIdleChatLimiters = 1000
+
+// Sender sends messages through Bot, throttled per chat and overall. When Telegram answers 429 Too Many Requests
+// every message waits for as long as Telegram asked, then the refused one is tried again.
+type Sender struct {
+	Bot    *telego.Bot
+	global *rate.Limiter
+
+	mu    sync.Mutex
+	chats map[int64]*rate.Limiter
+	// pausedUntil is when Telegram takes messages again after a 429
+	pausedUntil time.Time
+}
+
+func NewSender(bot *telego.Bot) *Sender {
+	return &Sender{
+		Bot:    bot,
+		global: rate.NewLimiter(telegramMessagesPerSecond, 1),
+		chats:  map[int64]*rate.Limiter{},
+	}
+}
+
+// SendMessage is telego.Bot.SendMessage, waiting for its turn first. It gives up once ctx is done.
+func (sender *Sender) SendMessage(ctx context.Context, params *telego.SendMessageParams) (*telego.Message, error) {
+	return sender.send(ctx, params.ChatID.ID, func() (*telego.Message, error) {
+		return sender.Bot.SendMessage(params)
+	})
+}
+
+// EditMessageText is telego.Bot.EditMessageText, waiting for its turn first. Edits count against the same limits.
+func (sender *Sender) EditMessageText(ctx context.Context, params *telego.EditMessageTextParams) (*telego.Message, error) {
+	return sender.send(ctx, params.ChatID.ID, func() (*telego.Message, error) {
+		return sender.Bot.EditMessageText(params)
+	})
+}
+
+// GetChatMember is telego.Bot.GetChatMember, waiting for its turn first. It only counts against the overall limit,
+// it doesn't send anything to the chat.
+func (sender *Sender) GetChatMember(ctx context.Context, params *telego.GetChatMemberParams) (telego.ChatMember, error) {
+	return throttled(ctx, sender, 0, func() (telego.ChatMember, error) {
+		return sender.Bot.GetChatMember(params)
+	})
+}
+
+// AnswerCallbackQuery is telego.Bot.AnswerCallbackQuery, waiting for its turn first. Like GetChatMember it only
+// counts against the overall limit.
+func (sender *Sender) AnswerCallbackQuery(ctx context.Context, params *telego.AnswerCallbackQueryParams) error {
+	_, err := throttled(ctx, sender, 0, func() (struct{}, error) {
+		return struct{}{}, sender.Bot.AnswerCallbackQuery(params)
+	})
+	return err
+}
+
+func (sender *Sender) send(ctx context.Context, chatID int64, call func() (*telego.Message, error)) (*telego.Message, error) {
+	return throttled(ctx, sender, chatID, call)
+}
+
+// throttled makes call once it is its turn, trying again after a 429. chatID is the chat call sends to,
+// 0 for calls that don't send anything.
+func throttled[T any](ctx context.Context, sender *Sender, chatID int64, call func() (T, error)) (T, error) {
+	for attempt := 0; ; attempt++ {
+		err := sender.wait(ctx, chatID)
+		if err != nil {
+			var zero T
+			return zero, err
+		}
+
+		result, err := call()
+		after, limited := retryAfter(err)
+		if !limited || attempt == sendRetries {
+			return result, err
+		}
+
+		sender.pause(after)
+	}
+}
+
+// wait blocks until a message to the chat may be sent, or ctx is done. Chat 0 only waits for the overall limit.
+func (sender *Sender) wait(ctx context.Context, chatID int64) error {
+	sender.mu.Lock()
+	paused := time.Until(sender.pausedUntil)
+	var chat *rate.Limiter
+	if chatID != 0 {
+		chat = sender.chatLimiter(chatID)
+	}
+	sender.mu.Unlock()
+
+	if paused > 0 {
+		timer := time.NewTimer(paused)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	// the chat goes first, so that a busy chat doesn't hold on to the turns of the others
+	if chat != nil {
+		err := chat.Wait(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return sender.global.Wait(ctx)
+}
+
+// chatLimiter must be called with the lock held.
+func (sender *Sender) chatLimiter(chatID int64) *rate.Limiter {
+	limiter, ok := sender.chats[chatID]
+	if ok {
+		return limiter
+	}
+
+	if len(sender.chats) >= maxIdleChatLimiters {
+		for id, idle := range sender.chats {
+			// a limiter with its full burst left is no different from a new one
+			if idle.Tokens() >= 1 {
+				delete(sender.chats, id)
+			}
+		}
+	}
+
+	limiter = rate.NewLimiter(rate.Every(chatMessageInterval), 1)
+	sender.chats[chatID] = limiter
+
+	return limiter
+}
+
+func (sender *Sender) pause(after time.Duration) {
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+
+	until := time.Now().Add(after)
+	if until.After(sender.pausedUntil) {
+		sender.pausedUntil = until
+	}
+}
+
+// retryAfter tells if err is Telegram's 429 Too Many Requests, and how long it asked to wait.
+func retryAfter(err error) (time.Duration, bool) {
+	var apiErr *ta.Error
+	if !stderrors.As(err, &apiErr) || apiErr.ErrorCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	if apiErr.Parameters == nil || apiErr.Parameters.RetryAfter <= 0 {
+		return chatMessageInterval, true
+	}
+
+	return time.Duration(apiErr.Parameters.RetryAfter) * time.Second, true
+}
@@ -0,0 +1,94 @@
+package behaviors
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// limitedTelegram answers the first call with 429 Too Many Requests, asking to retry after retryAfter
+// seconds, and accepts every other one.
+func limitedTelegram(t *testing.T, calls *atomic.Int32, retryAfter int) *telego.Bot {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if calls.Add(1) == 1 {
+			_, _ = fmt.Fprintf(w, `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after %d","parameters":{"retry_after":%d}}`, retryAfter, retryAfter)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/answerCallbackQuery") {
+			_, _ = w.Write([]byte(`{"ok":true,"result":true}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":1,"date":0,"chat":{"id":1,"type":"private"}}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	bot, err := telego.NewBot("123456:"+strings.Repeat("a", 35), telego.WithAPIServer(server.URL), telego.WithDiscardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bot
+}
+
+func TestSenderHonoursRetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	sender := NewSender(limitedTelegram(t, &calls, 1))
+
+	start := time.Now()
+	_, err := sender.SendMessage(ctx, tu.Message(tu.ID(1), "hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second || calls.Load() != 2 {
+		t.Errorf("sent after %v and %d calls, want a retry after a second", elapsed, calls.Load())
+	}
+}
+
+func TestSenderAnswersCallbackQueriesAfterRetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	sender := NewSender(limitedTelegram(t, &calls, 1))
+
+	start := time.Now()
+	err := sender.AnswerCallbackQuery(ctx, tu.CallbackQuery("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second || calls.Load() != 2 {
+		t.Errorf("answered after %v and %d calls, want a retry after a second", elapsed, calls.Load())
+	}
+}
+
+func TestSenderThrottlesPerChat(t *testing.T) {
+	var calls atomic.Int32
+	// past the 429
+	calls.Store(1)
+	sender := NewSender(limitedTelegram(t, &calls, 0))
+
+	start := time.Now()
+	for _, chatID := range []int64{2, 3, 4} {
+		if _, err := sender.SendMessage(ctx, tu.Message(tu.ID(chatID), "hi")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > chatMessageInterval/2 {
+		t.Errorf("messages to different chats took %v", elapsed)
+	}
+
+	start = time.Now()
+	if _, err := sender.SendMessage(ctx, tu.Message(tu.ID(2), "hi")); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < chatMessageInterval*9/10 {
+		t.Errorf("second message to a chat went out after %v", elapsed)
+	}
+}
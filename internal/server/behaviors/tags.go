@@ -0,0 +1,179 @@
+package behaviors
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chofnar/release-bot/internal/server/repo"
+	"golang.org/x/mod/semver"
+)
+
+// tagVersion returns tag as a semantic version, tags without the leading v are accepted too, and so are
+// the path prefixed tags of modules in monorepos, like service/dynamodb/v1.2.3. ok is false for tags that
+// aren't versions at all.
+func tagVersion(tag string) (version string, ok bool) {
+	version = tag[strings.LastIndex(tag, "/")+1:]
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+
+	return version, semver.IsValid(version)
+}
+
+// tagLine is what comes before the version of a tag, like service/dynamodb/ for service/dynamodb/v1.2.3.
+// Each module of a monorepo has its own line of versions, only versions on the same line are compared.
+func tagLine(tag string) string {
+	return tag[:strings.LastIndex(tag, "/")+1]
+}
+
+// newestTag picks the highest version out of tags, which are sorted by commit date, newest first. The tags of
+// a monorepo are only compared on the line of the newest versioned one, /filter picks another module.
+// Repos that don't version their tags get the tag on the newest commit.
+func newestTag(tags []string) string {
+	for _, tag := range tags {
+		if _, ok := tagVersion(tag); ok {
+			return newestOnLine(tags, tagLine(tag))
+		}
+	}
+
+	if len(tags) > 0 {
+		return tags[0]
+	}
+
+	return ""
+}
+
+// newestOnLine picks the highest version out of the tags on line, "" if there is none.
+func newestOnLine(tags []string, line string) string {
+	newest, newestVersion := "", ""
+	for _, tag := range tags {
+		version, ok := tagVersion(tag)
+		if ok && tagLine(tag) == line && (newestVersion == "" || semver.Compare(version, newestVersion) > 0) {
+			newest, newestVersion = tag, version
+		}
+	}
+
+	return newest
+}
+
+// seenTag is the last seen tag of a chat that saw lastSeen before and was told about the newer ones among tags.
+// A chat stays on the line of lastSeen, so it doesn't jump between the modules of a monorepo.
+func seenTag(tags []string, lastSeen string) string {
+	if _, ok := tagVersion(lastSeen); !ok {
+		return newestTag(tags)
+	}
+
+	newest := newestOnLine(tags, tagLine(lastSeen))
+	if newest == "" {
+		return lastSeen
+	}
+
+	return newest
+}
+
+// missedTags picks the tags with a higher version than lastSeen, as releases so they can be announced the same way.
+// Only the tags on the line of lastSeen count. Prerelease versions are left out unless wantPrereleases is set. Up to maxAnnouncedReleases of the highest
+// versions are returned, lowest first. If lastSeen is unknown or not a version, only the newest of the wanted tags is.
+func missedTags(tags []string, lastSeen string, wantPrereleases bool) []repo.Release {
+	wanted := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if wantPrereleases || !tagRelease(tag).IsPrerelease {
+			wanted = append(wanted, tag)
+		}
+	}
+
+	lastVersion, ok := tagVersion(lastSeen)
+	if lastSeen == "" || !ok {
+		newest := newestTag(wanted)
+		if newest == "" || newest == lastSeen {
+			return []repo.Release{}
+		}
+
+		return []repo.Release{tagRelease(newest)}
+	}
+
+	missed := []string{}
+	for _, tag := range wanted {
+		version, ok := tagVersion(tag)
+		if ok && tagLine(tag) == tagLine(lastSeen) && semver.Compare(version, lastVersion) > 0 {
+			missed = append(missed, tag)
+		}
+	}
+
+	sort.SliceStable(missed, func(i, j int) bool {
+		first, _ := tagVersion(missed[i])
+		second, _ := tagVersion(missed[j])
+		return semver.Compare(first, second) < 0
+	})
+
+	missed = missed[max(len(missed)-maxAnnouncedReleases, 0):]
+
+	releases := make([]repo.Release, len(missed))
+	for i, tag := range missed {
+		releases[i] = tagRelease(tag)
+	}
+
+	return releases
+}
+
+// tagsDigest sums up tags, so that a change to them is noticed without storing them all.
+func tagsDigest(tags []string) string {
+	hash := fnv.New64a()
+	for _, tag := range tags {
+		hash.Write([]byte(tag))
+		hash.Write([]byte{0})
+	}
+
+	return strconv.FormatUint(hash.Sum64(), 16)
+}
+
+// tagRelease stands in for a release when announcing tag.
+func tagRelease(tag string) repo.Release {
+	version, _ := tagVersion(tag)
+
+	return repo.Release{
+		CurrentReleaseTagName: tag,
+		CurrentReleaseID:      tag,
+		IsPrerelease:          semver.Prerelease(version) != "",
+	}
+}
+
+// versionBump tells how far tag moves on from the highest stable version up to it among known, the other release
+// or tag names of the repo on the same line. Tags that aren't versions, or that have nothing to compare against, count as a major bump
+// so that they reach every subscription.
+func versionBump(tag string, known []string) repo.UpdateLevel {
+	version, ok := tagVersion(tag)
+	if !ok {
+		return repo.UpdateLevelMajor
+	}
+
+	baseline := ""
+	for _, other := range known {
+		otherVersion, ok := tagVersion(other)
+		if other == tag || !ok || tagLine(other) != tagLine(tag) || semver.Prerelease(otherVersion) != "" || semver.Compare(otherVersion, version) > 0 {
+			continue
+		}
+
+		if baseline == "" || semver.Compare(otherVersion, baseline) > 0 {
+			baseline = otherVersion
+		}
+	}
+
+	switch {
+	case baseline == "" || semver.Major(version) != semver.Major(baseline):
+		return repo.UpdateLevelMajor
+	case semver.MajorMinor(version) != semver.MajorMinor(baseline):
+		return repo.UpdateLevelMinor
+	case versionCore(version) != versionCore(baseline):
+		return repo.UpdateLevelPatch
+	default:
+		return repo.UpdateLevelAll
+	}
+}
+
+// versionCore is version without its prerelease and build suffixes.
+func versionCore(version string) string {
+	return strings.TrimSuffix(semver.Canonical(version), semver.Prerelease(version))
+}
@@ -0,0 +1,125 @@
+package behaviors
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/chofnar/release-bot/internal/server/repo"
+)
+
+func TestNewestTag(t *testing.T) {
+	cases := []struct {
+		name string
+		tags []string
+		want string
+	}{
+		{"by version, not commit date", []string{"v1.9.1", "v2.0.0", "v1.10.0"}, "v2.0.0"},
+		{"without the leading v", []string{"1.2.0", "1.10.0"}, "1.10.0"},
+		{"prereleases count", []string{"v1.0.0", "v1.1.0-rc1"}, "v1.1.0-rc1"},
+		{"unversioned tags are skipped", []string{"nightly", "v1.0.0"}, "v1.0.0"},
+		{"no versions at all", []string{"nightly-2", "nightly-1"}, "nightly-2"},
+		{"no tags", nil, ""},
+		{"monorepo, on the line of the newest tag", []string{"cli/v1.5.0", "api/v2.0.0", "cli/v1.4.0"}, "cli/v1.5.0"},
+	}
+
+	for _, c := range cases {
+		if got := newestTag(c.tags); got != c.want {
+			t.Errorf("%s: newestTag = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestMissedTags(t *testing.T) {
+	tags := []string{"v1.3.0", "v1.2.0", "v2.0.0-rc1", "v1.1.0", "nightly", "v1.0.0"}
+
+	names := func(releases []repo.Release) string {
+		var names []string
+		for _, release := range releases {
+			names = append(names, release.CurrentReleaseTagName)
+		}
+		return strings.Join(names, ",")
+	}
+
+	cases := []struct {
+		name            string
+		lastSeen        string
+		wantPrereleases bool
+		want            string
+	}{
+		{"stable only", "v1.1.0", false, "v1.2.0,v1.3.0"},
+		{"with prereleases", "v1.1.0", true, "v1.2.0,v1.3.0,v2.0.0-rc1"},
+		{"up to date", "v2.0.0-rc1", true, ""},
+		{"unknown last seen", "", true, "v2.0.0-rc1"},
+		{"unknown last seen, stable only", "", false, "v1.3.0"},
+		{"unversioned last seen", "nightly", true, "v2.0.0-rc1"},
+	}
+
+	for _, c := range cases {
+		if got := names(missedTags(tags, c.lastSeen, c.wantPrereleases)); got != c.want {
+			t.Errorf("%s: missedTags = %q, want %q", c.name, got, c.want)
+		}
+	}
+
+	monorepo := []string{"api/v2.0.0", "cli/v1.5.0", "api/v1.9.0", "cli/v1.4.0"}
+	if got := names(missedTags(monorepo, "cli/v1.4.0", false)); got != "cli/v1.5.0" {
+		t.Errorf("missedTags = %q, want only the cli module", got)
+	}
+	if got := seenTag(monorepo, "cli/v1.4.0"); got != "cli/v1.5.0" {
+		t.Errorf("seenTag = %q, want it to stay on the cli module", got)
+	}
+	if got := seenTag(monorepo, ""); got != "api/v2.0.0" {
+		t.Errorf("seenTag without a last seen tag = %q", got)
+	}
+
+	many := []string{"v1.0.7", "v1.0.6", "v1.0.5", "v1.0.4", "v1.0.3", "v1.0.2", "v1.0.1", "v1.0.0"}
+	if got := names(missedTags(many, "v1.0.0", false)); got != "v1.0.3,v1.0.4,v1.0.5,v1.0.6,v1.0.7" {
+		t.Errorf("missedTags = %q, want the %d highest", got, maxAnnouncedReleases)
+	}
+}
+
+func TestVersionBump(t *testing.T) {
+	known := []string{"v2.0.0-rc1", "v1.4.2", "v1.4.1", "v1.3.0", "nightly"}
+
+	cases := []struct {
+		tag  string
+		want repo.UpdateLevel
+	}{
+		{"v1.4.3", repo.UpdateLevelPatch},
+		{"v1.5.0", repo.UpdateLevelMinor},
+		{"v2.0.0", repo.UpdateLevelMajor},
+		// measured against the last stable version, not the prerelease before it
+		{"v2.0.0-rc2", repo.UpdateLevelMajor},
+		{"1.4.3", repo.UpdateLevelPatch},
+		{"v1.4.2+rebuild", repo.UpdateLevelAll},
+		{"nightly-2", repo.UpdateLevelMajor},
+		{"v0.1.0", repo.UpdateLevelMajor},
+		// nothing to compare on another module's line
+		{"cli/v1.4.3", repo.UpdateLevelMajor},
+	}
+
+	for _, c := range cases {
+		if got := versionBump(c.tag, known); got != c.want {
+			t.Errorf("versionBump(%q) = %q, want %q", c.tag, got, c.want)
+		}
+	}
+}
+
+func TestUpdateLevelAllows(t *testing.T) {
+	cases := []struct {
+		level, bump repo.UpdateLevel
+		want        bool
+	}{
+		{repo.UpdateLevelAll, repo.UpdateLevelAll, true},
+		{"", repo.UpdateLevelPatch, true},
+		{repo.UpdateLevelPatch, repo.UpdateLevelAll, false},
+		{repo.UpdateLevelMinor, repo.UpdateLevelPatch, false},
+		{repo.UpdateLevelMinor, repo.UpdateLevelMajor, true},
+		{repo.UpdateLevelMajor, repo.UpdateLevelMinor, false},
+	}
+
+	for _, c := range cases {
+		if got := c.level.Allows(c.bump); got != c.want {
+			t.Errorf("%q.Allows(%q) = %v, want %v", c.level, c.bump, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package behaviors
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chofnar/release-bot/internal/errors"
+	"github.com/chofnar/release-bot/internal/server/messages"
+	"github.com/chofnar/release-bot/internal/server/repo"
+	"go.uber.org/zap"
+)
+
+type erroredRepo struct {
+	Err  error     `json:"err,omitempty"`
+	Repo repo.Repo `json:"repo,omitempty"`
+}
+
+// updateRun collects the outcome of an update run from all of its workers.
+type updateRun struct {
+	mu          sync.Mutex
+	failedRepos []erroredRepo
+	carried     []string
+}
+
+func (run *updateRun) fail(failed ...erroredRepo) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	run.failedRepos = append(run.failedRepos, failed...)
+}
+
+func (run *updateRun) carry(batch []repo.Repo) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	for _, watched := range batch {
+		run.carried = append(run.carried, watched.RepoID)
+	}
+}
+
+// UpdateRepos queries GitHub once per watched repo, batchSize repos per request,
+// and fans new releases out to the subscribed chats. Batches are handled by UpdateWorkers
+// workers at once, all of them drawing on the same GitHubBudget.
+//
+// Once the rate limit runs low the remaining repos are not checked but marked as carried over in the database,
+// the next run checks them before any other repo, even when it runs in another process. Digests that are due are sent at the end of every run,
+// as are the notifications of the outbox due for another attempt.
+func (bh BehaviorHandler) UpdateRepos(ctx context.Context, logger zap.SugaredLogger) []erroredRepo {
+	run := &updateRun{failedRepos: []erroredRepo{}}
+
+	carried := map[string]struct{}{}
+	carriedIDs, err := bh.DB.CarriedOverRepos(ctx)
+	if err != nil {
+		// the carried over repos are checked in their usual turn
+		run.fail(erroredRepo{Err: err})
+	}
+	for _, repoID := range carriedIDs {
+		carried[repoID] = struct{}{}
+	}
+
+	if len(carried) > 0 {
+		bh.checkWatched(ctx, logger, run, func(watched repo.Repo) bool {
+			_, ok := carried[watched.RepoID]
+			return ok
+		})
+	}
+	bh.checkWatched(ctx, logger, run, func(watched repo.Repo) bool {
+		_, ok := carried[watched.RepoID]
+		return !ok
+	})
+
+	run.fail(bh.SendDigests(ctx, logger, time.Now())...)
+	run.fail(bh.DeliverOutbox(ctx, logger, time.Now())...)
+
+	// the repos carried over to this run that were checked, the others stay marked
+	checked := []string{}
+	stillCarried := map[string]struct{}{}
+	for _, repoID := range run.carried {
+		stillCarried[repoID] = struct{}{}
+	}
+	for repoID := range carried {
+		if _, ok := stillCarried[repoID]; !ok {
+			checked = append(checked, repoID)
+		}
+	}
+	run.fail(bh.setCarriedOver(ctx, checked, false)...)
+
+	if len(run.carried) > 0 {
+		logger.Infof("GitHub rate limit is running low until %s, carrying %d repos over to the next update run", bh.GitHubBudget.resetTime(), len(run.carried))
+		run.fail(bh.setCarriedOver(ctx, run.carried, true)...)
+	}
+
+	return run.failedRepos
+}
+
+func (bh BehaviorHandler) setCarriedOver(ctx context.Context, repoIDs []string, carried bool) []erroredRepo {
+	if len(repoIDs) == 0 {
+		return nil
+	}
+
+	err := bh.DB.SetCarriedOver(ctx, repoIDs, carried)
+	if err != nil {
+		return []erroredRepo{{Err: err}}
+	}
+
+	return nil
+}
+
+// TryUpdateRepos is UpdateRepos, unless another run holding UpdateGuard is still in progress,
+// in which case it returns errors.ErrUpdateRunning without doing anything.
+func (bh BehaviorHandler) TryUpdateRepos(ctx context.Context, logger zap.SugaredLogger) ([]erroredRepo, error) {
+	if bh.UpdateGuard != nil {
+		if !bh.UpdateGuard.TryLock() {
+			return nil, errors.ErrUpdateRunning
+		}
+		defer bh.UpdateGuard.Unlock()
+	}
+
+	return bh.UpdateRepos(ctx, logger), nil
+}
+
+// checkWatched runs the watched repos matching include through the worker pool.
+func (bh BehaviorHandler) checkWatched(ctx context.Context, logger zap.SugaredLogger, run *updateRun, include func(repo.Repo) bool) {
+	batches := make(chan []repo.Repo)
+	var wg sync.WaitGroup
+	for range max(bh.UpdateWorkers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				bh.updateBatch(ctx, logger, run, batch)
+			}
+		}()
+	}
+
+	batch := make([]repo.Repo, 0, batchSize)
+	for watched, err := range bh.DB.IterWatched(ctx) {
+		if err != nil {
+			run.fail(erroredRepo{Err: err})
+			break
+		}
+
+		if !include(watched) {
+			continue
+		}
+
+		batch = append(batch, watched)
+		if len(batch) == batchSize {
+			batches <- batch
+			batch = make([]repo.Repo, 0, batchSize)
+		}
+	}
+
+	if len(batch) > 0 {
+		batches <- batch
+	}
+	close(batches)
+	wg.Wait()
+}
+
+func (bh BehaviorHandler) updateBatch(ctx context.Context, logger zap.SugaredLogger, run *updateRun, batch []repo.Repo) {
+	err := bh.GitHubBudget.take(ctx, updateReserve)
+	if err == errors.ErrRateLimitLow {
+		run.carry(batch)
+		return
+	}
+
+	var retrieved []retrievedRepo
+	if err == nil {
+		refs := make([]repoRef, len(batch))
+		for i, watched := range batch {
+			refs[i] = repoRef{Owner: watched.Owner, Name: watched.Name}
+
+			// the filters of the chats tracking tags tell which directories of a monorepo to look into
+			subscribers, errdb := bh.DB.GetSubscribers(ctx, watched.RepoID)
+			if errdb != nil {
+				run.fail(erroredRepo{Err: errdb, Repo: watched})
+			}
+			refs[i].TagPrefixes = tagPrefixes(subscribers)
+		}
+
+		retrieved, err = bh.retrieveRepos(ctx, refs)
+	}
+	if err != nil {
+		for _, watched := range batch {
+			run.fail(erroredRepo{Err: err, Repo: watched})
+		}
+		return
+	}
+
+	for i, watched := range batch {
+		run.fail(bh.updateRepo(ctx, logger, watched, retrieved[i])...)
+	}
+}
+
+func (bh BehaviorHandler) updateRepo(ctx context.Context, logger zap.SugaredLogger, watched repo.Repo, retrieved retrievedRepo) []erroredRepo {
+	failedRepos := []erroredRepo{}
+
+	newlyRetrievedRepo, err := retrieved.Repo, retrieved.Err
+	// repos that only push tags are still of use to the chats tracking tags
+	if err == errors.ErrNoReleases && len(retrieved.Tags) > 0 {
+		err = nil
+	}
+	if err != nil {
+		failedRepos = append(failedRepos, erroredRepo{Err: err, Repo: newlyRetrievedRepo})
+		// Could not resolve
+		if strings.Contains(err.Error(), "Could not resolve to a Repository with the name") {
+			subscribers, errdb := bh.DB.GetSubscribers(ctx, watched.RepoID)
+			if errdb != nil {
+				logger.Error(errdb)
+			}
+			for _, subscription := range subscribers {
+				errdb = bh.DB.RemoveRepo(ctx, subscription.ChatID, subscription.RepoID)
+				if errdb != nil {
+					logger.Error(errdb)
+				}
+			}
+		}
+		return failedRepos
+	}
+
+	newlyRetrievedRepo.Release = latestReleases(retrieved.Releases, watched.Release)
+	if len(retrieved.Tags) > 0 {
+		newlyRetrievedRepo.LatestTagName = newestTag(retrieved.Tags)
+		newlyRetrievedRepo.TagsDigest = tagsDigest(retrieved.Tags)
+	}
+	if sameReleases(newlyRetrievedRepo.Release, watched.Release) {
+		return failedRepos
+	}
+
+	return bh.announceReleases(ctx, logger, watched, newlyRetrievedRepo, retrieved.Releases, retrieved.Tags)
+}
+
+// announceReleases puts a notification about each of the releases, sorted newest first, a subscriber hasn't
+// heard of yet into the outbox, records the release of newlyRetrievedRepo as the last seen one of the watched repo
+// and then delivers the notifications. Subscribers tracking tags are notified about the tags instead.
+//
+// The release only counts as seen once the notifications of every subscriber were queued. Until then the next
+// run announces it again, the keys of the outbox keep those queued already from being sent twice.
+func (bh BehaviorHandler) announceReleases(ctx context.Context, logger zap.SugaredLogger, watched repo.Repo, newlyRetrievedRepo repo.Repo, releases []repo.Release, tags []string) []erroredRepo {
+	failedRepos := []erroredRepo{}
+	repoID := watched.RepoID
+
+	subscribers, err := bh.DB.GetSubscribers(ctx, repoID)
+	if err != nil {
+		failedRepos = append(failedRepos, erroredRepo{Err: err, Repo: newlyRetrievedRepo})
+		return failedRepos
+	}
+
+	// what the version bump of an announced release is measured against
+	knownReleases := []string{watched.StableReleaseTagName}
+	for _, release := range releases {
+		knownReleases = append(knownReleases, release.CurrentReleaseTagName)
+	}
+
+	outbox := []repo.OutboxMessage{}
+	queued := true
+	for _, repository := range subscribers {
+		filter, err := newTagFilter(repository.IncludeTags, repository.ExcludeTags)
+		if err != nil {
+			// filters are checked when they are set, this one can't be applied anyway
+			logger.Warnf("ignoring the filters of chat %s for %s: %s", repository.ChatID, repoID, err)
+		}
+
+		if !repository.TrackTags {
+			announced := missedReleases(releases, watched.Release, repository.ShouldNotifyPrerelease, filter)
+			added, failed := bh.announceTo(ctx, logger, repository, newlyRetrievedRepo, announced, knownReleases)
+			outbox, failedRepos = append(outbox, added...), append(failedRepos, failed...)
+			queued = queued && len(failed) == 0
+			continue
+		}
+
+		// each chat has its own last seen tag, the filters make for a different newest tag
+		matching := filter.filterTags(tags)
+		announced := missedTags(matching, repository.LastTagName, repository.ShouldNotifyPrerelease)
+		added, failed := bh.announceTo(ctx, logger, repository, newlyRetrievedRepo, announced, append(matching, repository.LastTagName))
+		outbox, failedRepos = append(outbox, added...), append(failedRepos, failed...)
+		if len(failed) > 0 {
+			// keeping the stored tags makes the next run announce these tags again
+			queued = false
+			continue
+		}
+
+		newest := seenTag(matching, repository.LastTagName)
+		if newest == "" || newest == repository.LastTagName {
+			continue
+		}
+
+		err = bh.DB.SetLastTag(ctx, repository.ChatID, repoID, newest)
+		if err != nil && err != errors.ErrRepoNotFound {
+			failedRepos = append(failedRepos, erroredRepo{Err: err, Repo: newlyRetrievedRepo})
+		}
+	}
+
+	if queued {
+		err = bh.DB.UpdateRelease(ctx, repoID, newlyRetrievedRepo.Release)
+		// errors.ErrRepoNotFound: the last subscriber left while the update was running
+		if err != nil && err != errors.ErrRepoNotFound {
+			failedRepos = append(failedRepos, erroredRepo{Err: err, Repo: newlyRetrievedRepo})
+		}
+	}
+
+	for _, message := range outbox {
+		err = bh.deliver(ctx, logger, message)
+		if err != nil {
+			failedRepos = append(failedRepos, erroredRepo{Err: err, Repo: newlyRetrievedRepo})
+		}
+	}
+
+	return failedRepos
+}
+
+// announceTo queues a notification for the subscriber about each release in announced that bumps the version,
+// as measured against the names in known, by at least the subscriber's update level. Releases are written with
+// the chat's template and put into the outbox, or queued for its next digest or the end of its quiet hours.
+// Chats that rather have them during quiet hours get them without a sound. It returns the messages it added
+// to the outbox, those queued before are left to their first delivery.
+func (bh BehaviorHandler) announceTo(ctx context.Context, logger zap.SugaredLogger, repository repo.RepoWithChatID, newlyRetrievedRepo repo.Repo, announced []repo.Release, known []string) ([]repo.OutboxMessage, []erroredRepo) {
+	outbox := []repo.OutboxMessage{}
+	failedRepos := []erroredRepo{}
+
+	var settings repo.ChatSettings
+	if len(announced) > 0 {
+		var err error
+		settings, err = bh.DB.GetChatSettings(ctx, repository.ChatID)
+		if err != nil {
+			// the default template and instant delivery do just as well
+			logger.Warnf("could not read the settings of chat %s: %s", repository.ChatID, err)
+		}
+	}
+	now := time.Now()
+
+	for _, release := range announced {
+		if !repository.UpdateLevel.Allows(versionBump(release.CurrentReleaseTagName, known)) {
+			continue
+		}
+
+		if holdsReleases(settings, now) {
+			err := bh.queueNotification(ctx, repository, release)
+			if err != nil {
+				failedRepos = append(failedRepos, erroredRepo{Err: err, Repo: newlyRetrievedRepo})
+			}
+			continue
+		}
+
+		withChatID := repo.RepoWithChatID{
+			Repo:   newlyRetrievedRepo,
+			ChatID: repository.ChatID,
+		}
+		withChatID.CurrentReleaseTagName, withChatID.CurrentReleaseID = release.CurrentReleaseTagName, release.CurrentReleaseID
+		withChatID.IsPrerelease, withChatID.Notes = release.IsPrerelease, release.Notes
+
+		var message repo.OutboxMessage
+		if repository.TrackTags {
+			key := outboxKey("tag", repository.ChatID, repository.RepoID, release.CurrentReleaseTagName)
+			message = messages.OutboxMessage(key, repository.RepoID, messages.TagMessage(withChatID), nil)
+		} else {
+			key := outboxKey("release", repository.ChatID, repository.RepoID, release.CurrentReleaseTagName)
+			message = messages.OutboxMessage(key, repository.RepoID,
+				messages.UpdateMessage(withChatID, release.IsPrerelease, settings.Template),
+				messages.PlainUpdateMessage(withChatID, release.IsPrerelease))
+		}
+		message.Silent = inQuietHours(settings, now)
+		message.CreatedAt, message.NextAttemptAt = now, now.Add(outboxLease)
+
+		added, err := bh.DB.EnqueueOutbox(ctx, message)
+		if err != nil {
+			failedRepos = append(failedRepos, erroredRepo{Err: err, Repo: withChatID.Repo})
+			continue
+		}
+		if added {
+			outbox = append(outbox, message)
+		}
+	}
+
+	return outbox, failedRepos
+}
@@ -0,0 +1,223 @@
+package behaviors
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chofnar/release-bot/internal/database/memory"
+	"github.com/chofnar/release-bot/internal/server/repo"
+	"github.com/hasura/go-graphql-client"
+	"go.uber.org/zap"
+)
+
+// unchangedGitHub answers batch queries for repos named "name-<id>" with the release "release-<id>",
+// counting the requests it served. Every response reports remaining points of rate limit left.
+func unchangedGitHub(t *testing.T, requests *atomic.Int32, remaining int) *graphql.Client {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		var body struct {
+			Variables map[string]string `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+
+		data := map[string]any{}
+		for variable, name := range body.Variables {
+			if !strings.HasPrefix(variable, "n") {
+				continue
+			}
+			id := strings.TrimPrefix(name, "name-")
+			data["r"+strings.TrimPrefix(variable, "n")] = map[string]any{
+				"id": id, "name": name, "owner": map[string]any{"login": "owner"},
+				"releases": map[string]any{"nodes": []any{map[string]any{"tagName": "v1.0.0", "id": "release-" + id}}},
+			}
+		}
+
+		data["rateLimit"] = map[string]any{
+			"cost": 1, "remaining": remaining, "resetAt": time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"data": data})
+	}))
+	t.Cleanup(server.Close)
+
+	return graphql.NewClient(server.URL, server.Client())
+}
+
+func watchedRepos(t *testing.T, count int) *memory.Driver {
+	t.Helper()
+
+	db := memory.New(*zap.NewNop().Sugar())
+	for i := 0; i < count; i++ {
+		id := fmt.Sprint("R_", i)
+		err := db.AddRepo(ctx, fmt.Sprint(i%3), &repo.Repo{
+			RepoID:  id,
+			Name:    "name-" + id,
+			Owner:   "owner",
+			Release: repo.Release{CurrentReleaseTagName: "v1.0.0", CurrentReleaseID: "release-" + id},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return db
+}
+
+func TestUpdateReposWorkers(t *testing.T) {
+	db := watchedRepos(t, 2*batchSize+1)
+
+	var requests atomic.Int32
+	bh := BehaviorHandler{
+		GQLClient:     unchangedGitHub(t, &requests, 5000),
+		GitHubBudget:  NewGitHubBudget(),
+		DB:            db,
+		UpdateWorkers: 3,
+	}
+
+	failed := bh.UpdateRepos(ctx, *zap.NewNop().Sugar())
+	if len(failed) != 0 {
+		t.Errorf("got failures %+v", failed)
+	}
+	if got := requests.Load(); got != 3 {
+		t.Errorf("served %d requests, want one per batch (3)", got)
+	}
+}
+
+func TestUpdateReposCarriesOverOnLowRateLimit(t *testing.T) {
+	db := watchedRepos(t, 3*batchSize)
+
+	var requests atomic.Int32
+	bh := BehaviorHandler{
+		GQLClient:     unchangedGitHub(t, &requests, updateReserve),
+		GitHubBudget:  NewGitHubBudget(),
+		DB:            db,
+		UpdateWorkers: 1,
+	}
+
+	failed := bh.UpdateRepos(ctx, *zap.NewNop().Sugar())
+	if len(failed) != 0 {
+		t.Errorf("repos left out for the rate limit were reported as failures: %+v", failed)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("served %d requests, want to stop after the first one", got)
+	}
+
+	carried, err := db.CarriedOverRepos(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(carried) != 2*batchSize {
+		t.Fatalf("carried over %d repos, want %d", len(carried), 2*batchSize)
+	}
+
+	// the next run, maybe in a fresh process, checks the carried over repos first
+	first := map[string]bool{}
+	for _, repoID := range carried {
+		first[repoID] = true
+	}
+	requests.Store(0)
+	bh.GitHubBudget = NewGitHubBudget()
+	bh.UpdateRepos(ctx, *zap.NewNop().Sugar())
+
+	carried, err = db.CarriedOverRepos(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(carried) != 2*batchSize {
+		t.Fatalf("carried over %d repos on the second run, want %d", len(carried), 2*batchSize)
+	}
+	left := 0
+	for _, repoID := range carried {
+		if first[repoID] {
+			left++
+		}
+	}
+	if left != batchSize {
+		t.Errorf("%d of the repos carried over to the second run were left out again, want %d", left, batchSize)
+	}
+}
+
+// monorepoGitHub answers for a repo whose 20 newest tags, and then some, are all in service/s3/. The tags
+// in service/dynamodb/ only come back when asked for with their refPrefix, as GitHub names them relative to it.
+func monorepoGitHub(t *testing.T) *graphql.Client {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Query     string            `json:"query"`
+			Variables map[string]string `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+
+		s3Tags := []any{}
+		for i := 25; i > 0; i-- {
+			s3Tags = append(s3Tags, map[string]any{"name": fmt.Sprintf("service/s3/v1.%d.0", i)})
+		}
+		repository := map[string]any{
+			"id": "R_1", "url": "https://github.com/aws/aws-sdk-go-v2", "name": "aws-sdk-go-v2", "owner": map[string]any{"login": "aws"},
+			"releases": map[string]any{"nodes": []any{}},
+			"refs":     map[string]any{"nodes": s3Tags},
+		}
+		if strings.Contains(body.Query, "p0: refs(refPrefix: $p0_0") && body.Variables["p0_0"] == "refs/tags/service/dynamodb/" {
+			repository["p0"] = map[string]any{"nodes": []any{map[string]any{"name": "v1.41.0"}, map[string]any{"name": "v1.40.0"}}}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"r0": repository}})
+	}))
+	t.Cleanup(server.Close)
+
+	return graphql.NewClient(server.URL, server.Client())
+}
+
+func TestUpdateReposFindsFilteredTagsBehindOthers(t *testing.T) {
+	logger := *zap.NewNop().Sugar()
+	db := memory.New(logger)
+	if err := db.AddRepo(ctx, "1", &repo.Repo{RepoID: "R_1", Name: "aws-sdk-go-v2", Owner: "aws"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetTrackTags(ctx, "1", "R_1", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetTagFilters(ctx, "1", "R_1", `^service/dynamodb/`, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetLastTag(ctx, "1", "R_1", "service/dynamodb/v1.40.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls atomic.Int32
+	bh := BehaviorHandler{
+		Sender:    NewSender(flakyTelegram(t, &calls, 0)),
+		GQLClient: monorepoGitHub(t),
+		DB:        db,
+	}
+
+	if failed := bh.UpdateRepos(ctx, logger); len(failed) != 0 {
+		t.Errorf("got failures %+v", failed)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("sent %d notifications, want one about service/dynamodb/v1.41.0", calls.Load())
+	}
+	subscribers, err := db.GetSubscribers(ctx, "R_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subscribers[0].LastTagName != "service/dynamodb/v1.41.0" {
+		t.Errorf("last seen tag is %q, want service/dynamodb/v1.41.0", subscribers[0].LastTagName)
+	}
+}
@@ -0,0 +1,60 @@
+package behaviors
+
+import (
+	"context"
+
+	"github.com/chofnar/release-bot/internal/errors"
+	"go.uber.org/zap"
+)
+
+// releasePublished is the action GitHub sends once a release or prerelease becomes public.
+const releasePublished = "published"
+
+// ReleaseEvent is the part of a GitHub release webhook payload the bot reads. Anyone holding the webhook
+// secret can sign a payload, so it only tells which repo to check, the releases are read from GitHub.
+type ReleaseEvent struct {
+	Action  string `json:"action"`
+	Release struct {
+		Draft bool `json:"draft"`
+	} `json:"release"`
+	Repository struct {
+		NodeID string `json:"node_id"`
+	} `json:"repository"`
+}
+
+// ReleaseWebhook checks the repo GitHub pushed a release event for right away, the same way an update run would.
+// Events for repos nobody watches are ignored.
+func (bh BehaviorHandler) ReleaseWebhook(ctx context.Context, logger zap.SugaredLogger, event ReleaseEvent) []erroredRepo {
+	if event.Action != releasePublished || event.Release.Draft {
+		return []erroredRepo{}
+	}
+
+	subscribers, err := bh.DB.GetSubscribers(ctx, event.Repository.NodeID)
+	if err != nil {
+		return []erroredRepo{{Err: err}}
+	}
+
+	if len(subscribers) == 0 {
+		return []erroredRepo{}
+	}
+
+	// every subscription shares the repo's owner, name and last seen release
+	watched := subscribers[0].Repo
+
+	err = bh.GitHubBudget.take(ctx, updateReserve)
+	if err == errors.ErrRateLimitLow {
+		// the next update run announces the release
+		logger.Warnf("not checking %s/%s for the webhook, the GitHub rate limit is low", watched.Owner, watched.Name)
+		return []erroredRepo{}
+	}
+	if err != nil {
+		return []erroredRepo{{Err: err, Repo: watched}}
+	}
+
+	retrieved, err := bh.retrieveRepos(ctx, []repoRef{{Owner: watched.Owner, Name: watched.Name, TagPrefixes: tagPrefixes(subscribers)}})
+	if err != nil {
+		return []erroredRepo{{Err: err, Repo: watched}}
+	}
+
+	return bh.updateRepo(ctx, logger, watched, retrieved[0])
+}
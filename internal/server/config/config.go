@@ -3,13 +3,21 @@ package config
 import (
 	"os"
 	"strconv"
+	"time"
 )
 
 type BotConfig struct {
 	TelegramToken, WebhookSite, WebhookPort, Port, GithubGQLToken, ResetWebhookUrl string
-	Limit                                                                          int
+	// GithubWebhookSecret enables the GitHub release webhook endpoint when set
+	GithubWebhookSecret string
+	Limit               int
+	UpdateWorkers       int
+	// PollInterval enables the built-in scheduler when set, PollJitter adds up to that much random delay to every wait
+	PollInterval, PollJitter time.Duration
 }
 
+const defaultUpdateWorkers = 4
+
 func LoadBotConfig() *BotConfig {
 	if os.Getenv("FROM_FILE") == "1" {
 		// TODO: implement
@@ -20,14 +28,40 @@ func LoadBotConfig() *BotConfig {
 			panic("Limit cannot be read or is 0!")
 		}
 
+		updateWorkers := defaultUpdateWorkers
+		if value := os.Getenv("UPDATE_WORKERS"); value != "" {
+			updateWorkers, err = strconv.Atoi(value)
+			if err != nil || updateWorkers < 1 {
+				panic("UPDATE_WORKERS must be a positive number!")
+			}
+		}
+
+		var pollInterval, pollJitter time.Duration
+		if value := os.Getenv("POLL_INTERVAL"); value != "" {
+			pollInterval, err = time.ParseDuration(value)
+			if err != nil || pollInterval <= 0 {
+				panic("POLL_INTERVAL must be a positive duration!")
+			}
+		}
+		if value := os.Getenv("POLL_JITTER"); value != "" {
+			pollJitter, err = time.ParseDuration(value)
+			if err != nil || pollJitter < 0 {
+				panic("POLL_JITTER must be a duration!")
+			}
+		}
+
 		return &BotConfig{
-			TelegramToken:   os.Getenv("TELEGRAM_BOT_TOKEN"),
-			WebhookSite:     os.Getenv("TELEGRAM_BOT_SITE_URL"),
-			Port:            os.Getenv("PORT"),
-			WebhookPort:     os.Getenv("WEBHOOK_PORT"),
-			GithubGQLToken:  os.Getenv("GRAPHQL_TOKEN"),
-			ResetWebhookUrl: os.Getenv("RESET_WEBHOOK_URL"),
-			Limit:           limit,
+			TelegramToken:       os.Getenv("TELEGRAM_BOT_TOKEN"),
+			WebhookSite:         os.Getenv("TELEGRAM_BOT_SITE_URL"),
+			Port:                os.Getenv("PORT"),
+			WebhookPort:         os.Getenv("WEBHOOK_PORT"),
+			GithubGQLToken:      os.Getenv("GRAPHQL_TOKEN"),
+			ResetWebhookUrl:     os.Getenv("RESET_WEBHOOK_URL"),
+			GithubWebhookSecret: os.Getenv("GITHUB_WEBHOOK_SECRET"),
+			Limit:               limit,
+			UpdateWorkers:       updateWorkers,
+			PollInterval:        pollInterval,
+			PollJitter:          pollJitter,
 		}
 	}
 }
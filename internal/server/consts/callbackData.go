@@ -1,7 +1,9 @@
 package consts
 
 const (
-	SeeAllCallback = "1"
-	AddCallback    = "2"
-	MenuCallback   = "101"
+	SeeAllCallback   = "1"
+	AddCallback      = "2"
+	SettingsCallback = "3"
+	TimeZoneCallback = "4"
+	MenuCallback     = "101"
 )
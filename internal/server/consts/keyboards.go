@@ -18,6 +18,12 @@ var StartKeyboard *telego.InlineKeyboardMarkup = tu.InlineKeyboard(
 			CallbackData: AddCallback,
 		},
 	),
+	tu.InlineKeyboardRow(
+		telego.InlineKeyboardButton{
+			Text:         SettingsButton,
+			CallbackData: SettingsCallback,
+		},
+	),
 )
 
 var AddAnotherRepoKeyboard *telego.InlineKeyboardMarkup = tu.InlineKeyboard(
@@ -41,3 +47,12 @@ var CancelAddKeyboard *telego.InlineKeyboardMarkup = tu.InlineKeyboard(
 		},
 	),
 )
+
+var CancelTimeZoneKeyboard *telego.InlineKeyboardMarkup = tu.InlineKeyboard(
+	tu.InlineKeyboardRow(
+		telego.InlineKeyboardButton{
+			Text:         ShowingAddRepoCancel,
+			CallbackData: SettingsCallback,
+		},
+	),
+)
@@ -1,10 +1,14 @@
 package consts
 
+import "github.com/chofnar/release-bot/internal/server/repo"
+
 const (
 	SeeAllReposMessage = "See all repos"
 
 	AddRepoMessage = "Add a repo"
 
+	SettingsButton = "Settings"
+
 	AboutMessage = "Find my source code at github.com/chofnar/release-bot\nConsider supporting this project :) https://www.buymeacoffee.com/chofnar\nThe bot is based on the Telegram API implementation in Go made by Artem Yadelskyi: https://github.com/mymmrac/telego\nFor suggestions and issues, contact the creator of the bot at catalin.hofnar@gmail.com"
 
 	StartMessage = "Pick one of the options below"
@@ -17,7 +21,7 @@ const (
 
 	InvalidRepoMessage = "Error: Invalid repo. Send a message containing your repo in one of the following formats: user/repo, https://github.com/user/repo"
 
-	ShowingAllReposMessage = "Here's all your added repos with their releases. The third button being active means you will be notified of prereleases for the repo."
+	ShowingAllReposMessage = "Here's all your added repos with their releases. The buttons below each repo choose if you are notified of prereleases, if the repo's releases or its git tags are followed, and the smallest version bump you want to hear about."
 
 	ShowingAllReposButNoneFoundMessage = "There are no watched repos. Add one?"
 
@@ -26,6 +30,9 @@ const (
 	PreReleasesActive   = "Pre: ✔️"
 	PreReleasesInactive = "Pre: ❌"
 
+	TrackingReleases = "Releases"
+	TrackingTags     = "Tags"
+
 	// Very creative
 	Yes = "Yes"
 
@@ -33,15 +40,92 @@ const (
 
 	AddedRepoSuccessfully = "Repo added successfully. Add another?"
 
-	AddedRepoSuccessfullyNoReleases = "Repo added successfully but it has no releases. I will ping you when there is one. Add another?"
+	AddedRepoSuccessfullyNoReleases = "Repo added successfully but it has no releases. I will ping you when there is one, or switch it to tags from your repo list if it only pushes tags. Add another?"
 
 	RepoExists = "Repo already exists in your watched list. Try another?"
 
 	RepoNotFound = "I could not find the repo. Try again?"
 
+	RateLimitedMessage = "GitHub asked me to slow down, I can't look up the repo right now. Try again later?"
+
 	CheckRepo = "Check it out"
 
-	FlipOperationPrefix     = "FLOP_"
-	PreviousOperationPrefix = "PRV_"
-	ForwardOperationPrefix  = "FWD_"
+	FilterUsageMessage = "Usage: /filter owner/repo [include|exclude] <regex>, or /filter owner/repo clear. A bare regex is an include filter, /filter owner/repo alone shows the current filters. Only releases or tags whose names match the include filter, and don't match the exclude filter, are announced."
+
+	FilterRepoNotWatchedMessage = "That repo is not in your watched list."
+
+	FilterInvalidMessage = "That is not a valid filter: "
+
+	FiltersMessage = "Filters for %s/%s\ninclude: %s\nexclude: %s"
+
+	NoFilter = "none"
+
+	TemplateUsageMessage = "Usage: /template <template>, or /template reset to go back to the default one. Templates are Go templates writing Telegram HTML, like <b>{{.Repo}}</b> {{.Tag}}. They can use {{.Owner}}, {{.Repo}}, {{.Tag}}, {{.IsPrerelease}}, {{.URL}}, {{.Title}}, {{.Author}}, {{.PublishedAt}} and {{.Notes}}, the release notes cut to fit, along with if, with, and, or, not, eq and ne."
+
+	CurrentTemplateMessage = "Your release notifications are written with this template:\n\n"
+
+	TemplateInvalidMessage = "That template can't be used: "
+
+	TemplateSavedMessage = "Template saved. A release now looks like this:"
+
+	TemplateResetMessage = "Back to the default template."
+
+	DeliveryUsageMessage = "Usage: /digest instant, or /digest daily|weekly [hour] [time zone], like /digest daily 9 Europe/Berlin. A digest gathers the releases found since the last one into a single message, sent at that hour, weekly ones on Mondays. The hour is in UTC unless a time zone is given."
+
+	DeliveryInstantMessage = "New releases are sent as soon as they are found."
+
+	DeliveryDailyMessage = "New releases are gathered into a daily digest, sent at %02d:00 (%s)."
+
+	DeliveryWeeklyMessage = "New releases are gathered into a weekly digest, sent on Mondays at %02d:00 (%s)."
+
+	DeliveryInvalidMessage = "That can't be used: "
+
+	DigestHeader = "Your %s digest:"
+
+	DigestMore = "…and %d more"
+
+	DigestPrerelease = " (prerelease)"
+
+	QuietHoursHeader = "Held back during your quiet hours:"
+
+	ShowingSettingsMessage = "These settings apply to all of your repos. During quiet hours new releases are held back until they end, or sent without a sound. Hours are in your time zone. Digests are set up with /digest."
+
+	QuietHoursOn      = "Turn quiet hours on"
+	QuietHoursOff     = "Turn quiet hours off"
+	QuietFromButton   = "From %02d:00"
+	QuietUntilButton  = "Until %02d:00"
+	EarlierButton     = "−1h"
+	LaterButton       = "+1h"
+	QuietHoldButton   = "During them: held back"
+	QuietSilentButton = "During them: sent silently"
+	TimeZoneButton    = "Time zone: %s"
+	BackToMenuButton  = "Back to Menu"
+
+	ShowingTimeZoneMessage = "Send the name of your time zone, like Europe/Berlin or America/New_York, or UTC."
+
+	TimeZoneInvalidMessage = "I don't know that time zone. Send one like Europe/Berlin or America/New_York, or UTC."
+
+	TimeZoneSavedMessage = "Time zone saved."
+
+	AdminsOnlyMessage = "Only the admins of this chat can change its repos and settings."
+
+	AddRepoReplyPrompt = "Send the repo as a reply to this message, like user/repo or https://github.com/user/repo."
+
+	TimeZoneReplyPrompt = "Send the time zone as a reply to this message, like Europe/Berlin or UTC."
+
+	FlipOperationPrefix        = "FLOP_"
+	TrackTagsOperationPrefix   = "TAGS_"
+	UpdateLevelOperationPrefix = "LVL_"
+	PreviousOperationPrefix    = "PRV_"
+	ForwardOperationPrefix     = "FWD_"
+	QuietHoursOperationPrefix  = "QUIET_"
+	QuietModeOperationPrefix   = "QMODE_"
 )
+
+// UpdateLevelButtons label the update level of a repo in the repo list, tapping one moves on to the next level.
+var UpdateLevelButtons = map[repo.UpdateLevel]string{
+	repo.UpdateLevelAll:   "Bumps: all",
+	repo.UpdateLevelPatch: "Bumps: patch+",
+	repo.UpdateLevelMinor: "Bumps: minor+",
+	repo.UpdateLevelMajor: "Bumps: major",
+}
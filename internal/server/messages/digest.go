@@ -0,0 +1,75 @@
+package messages
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+
+	"github.com/chofnar/release-bot/internal/server/consts"
+	"github.com/chofnar/release-bot/internal/server/repo"
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// DigestMessage gathers the pending notifications of a chat into one message, grouped by repo in the order
+// they were queued. What doesn't fit into a message is only counted. Chats without digests only have
+// notifications pending that were held back during their quiet hours.
+func DigestMessage(chatID string, mode repo.DeliveryMode, pending []repo.PendingNotification) *telego.SendMessageParams {
+	var order []string
+	byRepo := map[string][]repo.PendingNotification{}
+	for _, notification := range pending {
+		if _, ok := byRepo[notification.RepoID]; !ok {
+			order = append(order, notification.RepoID)
+		}
+		byRepo[notification.RepoID] = append(byRepo[notification.RepoID], notification)
+	}
+
+	text := html.EscapeString(fmt.Sprintf(consts.DigestHeader, mode))
+	if !mode.Digest() {
+		text = html.EscapeString(consts.QuietHoursHeader)
+	}
+	// room for the count of what didn't fit
+	room := maxMessageLength - utf16Length(fmt.Sprintf("\n"+consts.DigestMore, len(pending)))
+	shown := 0
+
+digest:
+	for _, repoID := range order {
+		notifications := byRepo[repoID]
+		section := "\n\n<b>" + html.EscapeString(notifications[0].RepoName) + "</b>"
+
+		for _, notification := range notifications {
+			line := "\n• " + digestLine(notification)
+			if visibleLength(text+section+line) > room {
+				break digest
+			}
+
+			text += section + line
+			section = ""
+			shown++
+		}
+	}
+
+	if shown < len(pending) {
+		text += "\n" + fmt.Sprintf(consts.DigestMore, len(pending)-shown)
+	}
+
+	intID, _ := strconv.Atoi(chatID)
+
+	return tu.Message(tu.ID(int64(intID)), text).
+		WithParseMode(telego.ModeHTML).
+		WithLinkPreviewOptions(&telego.LinkPreviewOptions{IsDisabled: true})
+}
+
+func digestLine(notification repo.PendingNotification) string {
+	link := notification.RepoLink + "/releases/" + notification.TagName
+	if notification.IsTag {
+		link = notification.RepoLink + "/releases/tag/" + notification.TagName
+	}
+
+	line := `<a href="` + html.EscapeString(link) + `">` + html.EscapeString(notification.TagName) + "</a>"
+	if notification.IsPrerelease {
+		line += consts.DigestPrerelease
+	}
+
+	return line
+}
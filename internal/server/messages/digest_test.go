@@ -0,0 +1,59 @@
+package messages
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/chofnar/release-bot/internal/server/repo"
+)
+
+func TestDigestMessage(t *testing.T) {
+	pending := []repo.PendingNotification{
+		{RepoID: "R_1", RepoName: "a", RepoLink: "https://github.com/o/a", TagName: "v1.1.0"},
+		{RepoID: "R_2", RepoName: "b<c", RepoLink: "https://github.com/o/b", TagName: "v0.2.0", IsTag: true},
+		{RepoID: "R_1", RepoName: "a", RepoLink: "https://github.com/o/a", TagName: "v2.0.0-rc.1", IsPrerelease: true},
+	}
+
+	want := `Your daily digest:
+
+<b>a</b>
+• <a href="https://github.com/o/a/releases/v1.1.0">v1.1.0</a>
+• <a href="https://github.com/o/a/releases/v2.0.0-rc.1">v2.0.0-rc.1</a> (prerelease)
+
+<b>b&lt;c</b>
+• <a href="https://github.com/o/b/releases/tag/v0.2.0">v0.2.0</a>`
+	if got := DigestMessage("1", repo.DeliveryDaily, pending).Text; got != want {
+		t.Errorf("DigestMessage = %q, want %q", got, want)
+	}
+
+	if got := DigestMessage("1", "", pending[:1]).Text; !strings.HasPrefix(got, "Held back during your quiet hours:\n\n<b>a</b>") {
+		t.Errorf("DigestMessage of held releases = %q", got)
+	}
+
+	many := []repo.PendingNotification{}
+	for i := range 1000 {
+		many = append(many, repo.PendingNotification{RepoID: fmt.Sprint(i), RepoName: fmt.Sprint("repo", i), TagName: "v1.0.0"})
+	}
+	text := DigestMessage("1", repo.DeliveryWeekly, many).Text
+	if visibleLength(text) > maxMessageLength || !strings.Contains(text, "more") {
+		t.Errorf("DigestMessage of many releases is %d long and ends in %q", visibleLength(text), text[len(text)-20:])
+	}
+}
+
+func TestQuietHourStep(t *testing.T) {
+	for _, test := range []struct {
+		hour, step, other, want int
+	}{
+		{22, 1, 7, 23},
+		{23, 1, 7, 0},
+		{0, -1, 7, 23},
+		// the quiet hours never shrink to nothing
+		{6, 1, 7, 8},
+		{8, -1, 7, 6},
+	} {
+		if got := quietHourStep(test.hour, test.step, test.other); got != test.want {
+			t.Errorf("quietHourStep(%d, %d, %d) = %d, want %d", test.hour, test.step, test.other, got, test.want)
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package messages
 
 import (
+	"context"
 	"fmt"
+	"html"
 	"strconv"
 
 	"github.com/chofnar/release-bot/internal/database"
@@ -16,32 +18,19 @@ func StartMessage(chatID int64) *telego.SendMessageParams {
 	return tu.Message(tu.ID(chatID), consts.StartMessage).WithReplyMarkup(consts.StartKeyboard)
 }
 
-func UpdateMessage(repository repo.RepoWithChatID, isPre bool) *telego.SendMessageParams {
-	currentRow := make([]telego.InlineKeyboardButton, 1)
-
-	kbd := telego.InlineKeyboardMarkup{
-		InlineKeyboard: [][]telego.InlineKeyboardButton{
-			{
-				telego.InlineKeyboardButton{
-					Text: consts.CheckRepo,
-					URL:  repository.Link + "/releases/" + repository.CurrentReleaseTagName,
-				},
+func TagMessage(repository repo.RepoWithChatID) *telego.SendMessageParams {
+	kbd := tu.InlineKeyboard(
+		tu.InlineKeyboardRow(
+			telego.InlineKeyboardButton{
+				Text: consts.CheckRepo,
+				URL:  repository.Link + "/releases/tag/" + repository.CurrentReleaseTagName,
 			},
-		},
-	}
-	releaseButton := telego.InlineKeyboardButton{
-		Text: consts.CheckRepo,
-		URL:  repository.Link + "/releases/" + repository.CurrentReleaseTagName,
-	}
-	currentRow[0] = releaseButton
+		),
+	)
 
 	intID, _ := strconv.Atoi(repository.ChatID)
 
-	pre := ""
-	if isPre {
-		pre = "pre"
-	}
-	return tu.Message(tu.ID(int64(intID)), "New "+pre+"release: "+repository.Name+" : "+repository.CurrentReleaseTagName).WithReplyMarkup(&kbd)
+	return tu.Message(tu.ID(int64(intID)), "New tag: "+repository.Name+" : "+repository.CurrentReleaseTagName).WithReplyMarkup(kbd)
 }
 
 func EditedStartMessage(chatID int64, messageID int) *telego.EditMessageTextParams {
@@ -61,6 +50,27 @@ func UnknownCommandMessage(chatID int64) *telego.SendMessageParams {
 	return tu.Message(tu.ID(chatID), consts.UnknownCommandMessage)
 }
 
+func AdminsOnlyMessage(chatID int64) *telego.SendMessageParams {
+	return tu.Message(tu.ID(chatID), consts.AdminsOnlyMessage)
+}
+
+func AdminsOnlyAlert(queryID string) *telego.AnswerCallbackQueryParams {
+	return tu.CallbackQuery(queryID).WithText(consts.AdminsOnlyMessage).WithShowAlert()
+}
+
+// ReplyPromptMessage asks for an answer as a reply to the message. With the bot's privacy mode on, replies to its
+// messages are all the bot gets to see of a group. The selective ForceReply opens the reply for the mentioned member
+// only, a nil member is an anonymous admin, who isn't mentioned so as not to give them away.
+func ReplyPromptMessage(chatID int64, member *telego.User, prompt string) *telego.SendMessageParams {
+	message := tu.Message(tu.ID(chatID), html.EscapeString(prompt)).WithParseMode(telego.ModeHTML)
+	if member == nil {
+		return message
+	}
+
+	message.Text = `<a href="tg://user?id=` + strconv.FormatInt(member.ID, 10) + `">` + html.EscapeString(member.FirstName) + "</a>\n" + message.Text
+	return message.WithReplyMarkup(tu.ForceReply().WithSelective())
+}
+
 func SeeAllReposMessage(chatID int64, messageID int, markup telego.InlineKeyboardMarkup) *telego.EditMessageTextParams {
 	return &telego.EditMessageTextParams{
 		ChatID:      tu.ID(chatID),
@@ -79,8 +89,8 @@ func SeeAllReposButNoneFoundMessage(chatID int64, messageID int, markup telego.I
 	}
 }
 
-func SeeReposMarkup(chatID int64, messageID, limit, page int, database *database.Database) (*telego.EditMessageReplyMarkupParams, error) {
-	repoList, err := (*database).GetRepos(fmt.Sprint(chatID))
+func SeeReposMarkup(ctx context.Context, chatID int64, messageID, limit, page int, database *database.Database) (*telego.EditMessageReplyMarkupParams, error) {
+	repoList, err := (*database).GetRepos(ctx, fmt.Sprint(chatID))
 	if err != nil {
 		return nil, err
 	}
@@ -101,10 +111,12 @@ func SeeReposMarkup(chatID int64, messageID, limit, page int, database *database
 		end = len(repoList) - limit*page
 	}
 
-	rows := make([][]telego.InlineKeyboardButton, end)
+	// every repo gets a row with its latest release and one with its settings
+	rows := make([][]telego.InlineKeyboardButton, 0, 2*end)
 
-	for index, repo := range repoList[start : start+end] {
-		currentRow := make([]telego.InlineKeyboardButton, 4)
+	for _, repo := range repoList[start : start+end] {
+		currentRow := make([]telego.InlineKeyboardButton, 3)
+		settingsRow := make([]telego.InlineKeyboardButton, 3)
 
 		repoNameButton := telego.InlineKeyboardButton{
 			Text: repo.Name,
@@ -113,7 +125,17 @@ func SeeReposMarkup(chatID int64, messageID, limit, page int, database *database
 		currentRow[0] = repoNameButton
 
 		var releaseButton telego.InlineKeyboardButton
-		if repo.CurrentReleaseTagName != "" {
+		if repo.TrackTags && repo.LatestTagName != "" {
+			releaseButton = telego.InlineKeyboardButton{
+				Text: repo.LatestTagName,
+				URL:  repo.Link + "/releases/tag/" + repo.LatestTagName,
+			}
+		} else if repo.TrackTags {
+			releaseButton = telego.InlineKeyboardButton{
+				Text: "N/A",
+				URL:  repo.Link + "/tags",
+			}
+		} else if repo.CurrentReleaseTagName != "" {
 			releaseButton = telego.InlineKeyboardButton{
 				Text: repo.CurrentReleaseTagName,
 				URL:  repo.Link + "/releases/" + repo.CurrentReleaseTagName,
@@ -136,15 +158,34 @@ func SeeReposMarkup(chatID int64, messageID, limit, page int, database *database
 			Text:         notifyPre,
 			CallbackData: consts.FlipOperationPrefix + newVal + "_" + repo.RepoID,
 		}
-		currentRow[2] = preReleaseNotifyButton
+		settingsRow[0] = preReleaseNotifyButton
+
+		tracking := consts.TrackingReleases
+		newTrackTags := "T"
+		if repo.TrackTags {
+			tracking = consts.TrackingTags
+			newTrackTags = "F"
+		}
+		trackTagsButton := telego.InlineKeyboardButton{
+			Text:         tracking,
+			CallbackData: consts.TrackTagsOperationPrefix + newTrackTags + "_" + repo.RepoID,
+		}
+		settingsRow[1] = trackTagsButton
+
+		level := repo.UpdateLevel.Normalize()
+		levelButton := telego.InlineKeyboardButton{
+			Text:         consts.UpdateLevelButtons[level],
+			CallbackData: consts.UpdateLevelOperationPrefix + string(level.Next()) + "_" + repo.RepoID,
+		}
+		settingsRow[2] = levelButton
 
 		deleteButton := telego.InlineKeyboardButton{
 			Text:         consts.DelteRepoEmoji,
 			CallbackData: repo.RepoID,
 		}
-		currentRow[3] = deleteButton
+		currentRow[2] = deleteButton
 
-		rows[index] = currentRow
+		rows = append(rows, currentRow, settingsRow)
 	}
 
 	// prev/forward
@@ -236,12 +277,259 @@ func RepoNotFoundMessage(chatID int64) *telego.SendMessageParams {
 	}
 }
 
-func DeleteRepo(chatID int64, repoID string, database *database.Database) error {
-	err := (*database).RemoveRepo(fmt.Sprint(chatID), repoID)
+func RateLimitedMessage(chatID int64) *telego.SendMessageParams {
+	return &telego.SendMessageParams{
+		ChatID:      tu.ID(chatID),
+		Text:        consts.RateLimitedMessage,
+		ReplyMarkup: consts.AddAnotherRepoKeyboard,
+	}
+}
+
+func FilterUsageMessage(chatID int64) *telego.SendMessageParams {
+	return tu.Message(tu.ID(chatID), consts.FilterUsageMessage)
+}
+
+func FilterRepoNotWatchedMessage(chatID int64) *telego.SendMessageParams {
+	return tu.Message(tu.ID(chatID), consts.FilterRepoNotWatchedMessage)
+}
+
+func FilterInvalidMessage(chatID int64, err error) *telego.SendMessageParams {
+	return tu.Message(tu.ID(chatID), consts.FilterInvalidMessage+err.Error())
+}
+
+func FiltersMessage(chatID int64, repository repo.Repo) *telego.SendMessageParams {
+	include, exclude := repository.IncludeTags, repository.ExcludeTags
+	if include == "" {
+		include = consts.NoFilter
+	}
+	if exclude == "" {
+		exclude = consts.NoFilter
+	}
+
+	return tu.Message(tu.ID(chatID), fmt.Sprintf(consts.FiltersMessage, repository.Owner, repository.Name, include, exclude))
+}
+
+func TemplateMessage(chatID int64, template string) *telego.SendMessageParams {
+	if template == "" {
+		template = DefaultTemplate
+	}
+
+	return tu.Message(tu.ID(chatID), consts.CurrentTemplateMessage+template+"\n\n"+consts.TemplateUsageMessage)
+}
+
+func TemplateInvalidMessage(chatID int64, err error) *telego.SendMessageParams {
+	return tu.Message(tu.ID(chatID), consts.TemplateInvalidMessage+err.Error())
+}
+
+func TemplateSavedMessage(chatID int64) *telego.SendMessageParams {
+	return tu.Message(tu.ID(chatID), consts.TemplateSavedMessage)
+}
+
+func TemplateResetMessage(chatID int64) *telego.SendMessageParams {
+	return tu.Message(tu.ID(chatID), consts.TemplateResetMessage)
+}
+
+func DeliveryMessage(chatID int64, settings repo.ChatSettings, withUsage bool) *telego.SendMessageParams {
+	timeZone := timeZoneName(settings)
+
+	var text string
+	switch settings.Delivery {
+	case repo.DeliveryDaily:
+		text = fmt.Sprintf(consts.DeliveryDailyMessage, settings.DigestHour, timeZone)
+	case repo.DeliveryWeekly:
+		text = fmt.Sprintf(consts.DeliveryWeeklyMessage, settings.DigestHour, timeZone)
+	default:
+		text = consts.DeliveryInstantMessage
+	}
+	if withUsage {
+		text += "\n\n" + consts.DeliveryUsageMessage
+	}
+
+	return tu.Message(tu.ID(chatID), text)
+}
+
+func DeliveryUsageMessage(chatID int64) *telego.SendMessageParams {
+	return tu.Message(tu.ID(chatID), consts.DeliveryUsageMessage)
+}
+
+func DeliveryInvalidMessage(chatID int64, err error) *telego.SendMessageParams {
+	return tu.Message(tu.ID(chatID), consts.DeliveryInvalidMessage+err.Error())
+}
+
+func timeZoneName(settings repo.ChatSettings) string {
+	if settings.TimeZone == "" {
+		return "UTC"
+	}
+
+	return settings.TimeZone
+}
+
+// defaultQuietFrom and defaultQuietUntil are the quiet hours a chat starts out with when it turns them on.
+const (
+	defaultQuietFrom  = 22
+	defaultQuietUntil = 7
+)
+
+// quietHourStep moves hour by step around the clock, skipping other so that the quiet hours don't turn off.
+func quietHourStep(hour, step, other int) int {
+	hour = (hour + step + 24) % 24
+	if hour == other {
+		hour = (hour + step + 24) % 24
+	}
+
+	return hour
+}
+
+func settingsKeyboard(settings repo.ChatSettings) *telego.InlineKeyboardMarkup {
+	from, until := settings.QuietFrom, settings.QuietUntil
+	quietHours := func(from, until int) string {
+		return consts.QuietHoursOperationPrefix + strconv.Itoa(from) + "_" + strconv.Itoa(until)
+	}
+
+	rows := [][]telego.InlineKeyboardButton{}
+	if from == until {
+		rows = append(rows, tu.InlineKeyboardRow(
+			telego.InlineKeyboardButton{
+				Text:         consts.QuietHoursOn,
+				CallbackData: quietHours(defaultQuietFrom, defaultQuietUntil),
+			},
+		))
+	} else {
+		modeButton := telego.InlineKeyboardButton{
+			Text:         consts.QuietHoldButton,
+			CallbackData: consts.QuietModeOperationPrefix + string(repo.QuietSilent),
+		}
+		if settings.QuietMode == repo.QuietSilent {
+			modeButton = telego.InlineKeyboardButton{
+				Text:         consts.QuietSilentButton,
+				CallbackData: consts.QuietModeOperationPrefix + string(repo.QuietHold),
+			}
+		}
+
+		rows = append(rows,
+			tu.InlineKeyboardRow(
+				telego.InlineKeyboardButton{
+					Text:         consts.EarlierButton,
+					CallbackData: quietHours(quietHourStep(from, -1, until), until),
+				},
+				telego.InlineKeyboardButton{
+					Text:         fmt.Sprintf(consts.QuietFromButton, from),
+					CallbackData: consts.SettingsCallback,
+				},
+				telego.InlineKeyboardButton{
+					Text:         consts.LaterButton,
+					CallbackData: quietHours(quietHourStep(from, 1, until), until),
+				},
+			),
+			tu.InlineKeyboardRow(
+				telego.InlineKeyboardButton{
+					Text:         consts.EarlierButton,
+					CallbackData: quietHours(from, quietHourStep(until, -1, from)),
+				},
+				telego.InlineKeyboardButton{
+					Text:         fmt.Sprintf(consts.QuietUntilButton, until),
+					CallbackData: consts.SettingsCallback,
+				},
+				telego.InlineKeyboardButton{
+					Text:         consts.LaterButton,
+					CallbackData: quietHours(from, quietHourStep(until, 1, from)),
+				},
+			),
+			tu.InlineKeyboardRow(modeButton),
+			tu.InlineKeyboardRow(
+				telego.InlineKeyboardButton{
+					Text:         consts.QuietHoursOff,
+					CallbackData: quietHours(0, 0),
+				},
+			),
+		)
+	}
+
+	rows = append(rows,
+		tu.InlineKeyboardRow(
+			telego.InlineKeyboardButton{
+				Text:         fmt.Sprintf(consts.TimeZoneButton, timeZoneName(settings)),
+				CallbackData: consts.TimeZoneCallback,
+			},
+		),
+		tu.InlineKeyboardRow(
+			telego.InlineKeyboardButton{
+				Text:         consts.BackToMenuButton,
+				CallbackData: consts.MenuCallback,
+			},
+		),
+	)
+
+	return tu.InlineKeyboard(rows...)
+}
+
+func SettingsMessage(chatID int64, messageID int, settings repo.ChatSettings) *telego.EditMessageTextParams {
+	return &telego.EditMessageTextParams{
+		ChatID:      tu.ID(chatID),
+		MessageID:   messageID,
+		Text:        consts.ShowingSettingsMessage,
+		ReplyMarkup: settingsKeyboard(settings),
+	}
+}
+
+func TimeZoneMessage(chatID int64, messageID int) *telego.EditMessageTextParams {
+	return &telego.EditMessageTextParams{
+		ChatID:      tu.ID(chatID),
+		MessageID:   messageID,
+		Text:        consts.ShowingTimeZoneMessage,
+		ReplyMarkup: consts.CancelTimeZoneKeyboard,
+	}
+}
+
+func TimeZoneInvalidMessage(chatID int64) *telego.SendMessageParams {
+	return tu.Message(tu.ID(chatID), consts.TimeZoneInvalidMessage).WithReplyMarkup(consts.CancelTimeZoneKeyboard)
+}
+
+func TimeZoneSavedMessage(chatID int64, settings repo.ChatSettings) *telego.SendMessageParams {
+	return tu.Message(tu.ID(chatID), consts.TimeZoneSavedMessage+"\n\n"+consts.ShowingSettingsMessage).WithReplyMarkup(settingsKeyboard(settings))
+}
+
+func DeleteRepo(ctx context.Context, chatID int64, repoID string, database *database.Database) error {
+	err := (*database).RemoveRepo(ctx, fmt.Sprint(chatID), repoID)
+	return err
+}
+
+func SetPreReleaseRetrieve(ctx context.Context, chatID int64, repoID string, newValue bool, database *database.Database) error {
+	err := (*database).SetPreReleaseRetrieve(ctx, fmt.Sprint(chatID), repoID, newValue)
+	return err
+}
+
+func SetTrackTags(ctx context.Context, chatID int64, repoID string, newValue bool, database *database.Database) error {
+	err := (*database).SetTrackTags(ctx, fmt.Sprint(chatID), repoID, newValue)
+	return err
+}
+
+func SetUpdateLevel(ctx context.Context, chatID int64, repoID string, level repo.UpdateLevel, database *database.Database) error {
+	err := (*database).SetUpdateLevel(ctx, fmt.Sprint(chatID), repoID, level)
+	return err
+}
+
+func SetTagFilters(ctx context.Context, chatID int64, repoID, include, exclude string, database *database.Database) error {
+	err := (*database).SetTagFilters(ctx, fmt.Sprint(chatID), repoID, include, exclude)
+	return err
+}
+
+func SetTemplate(ctx context.Context, chatID int64, template string, database *database.Database) error {
+	err := (*database).SetTemplate(ctx, fmt.Sprint(chatID), template)
+	return err
+}
+
+func SetDelivery(ctx context.Context, chatID int64, mode repo.DeliveryMode, digestHour int, timeZone string, database *database.Database) error {
+	err := (*database).SetDelivery(ctx, fmt.Sprint(chatID), mode, digestHour, timeZone)
+	return err
+}
+
+func SetQuietHours(ctx context.Context, chatID int64, from, until int, mode repo.QuietMode, database *database.Database) error {
+	err := (*database).SetQuietHours(ctx, fmt.Sprint(chatID), from, until, mode)
 	return err
 }
 
-func SetPreReleaseRetrieve(chatID int64, repoID string, newValue bool, database *database.Database) error {
-	err := (*database).SetPreReleaseRetrieve(fmt.Sprint(chatID), repoID, newValue)
+func SetTimeZone(ctx context.Context, chatID int64, timeZone string, database *database.Database) error {
+	err := (*database).SetTimeZone(ctx, fmt.Sprint(chatID), timeZone)
 	return err
 }
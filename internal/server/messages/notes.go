@@ -0,0 +1,219 @@
+package messages
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/chofnar/release-bot/internal/server/consts"
+	"github.com/chofnar/release-bot/internal/server/repo"
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// maxMessageLength is how long, in UTF-16 code units after entities are parsed, Telegram lets a message be.
+const maxMessageLength = 4096
+
+// maxTitleLength keeps a release title from eating up the room of its notes.
+const maxTitleLength = 256
+
+var (
+	htmlComment = regexp.MustCompile(`(?s)<!--.*?-->`)
+	blankLines  = regexp.MustCompile(`\n{3,}`)
+	heading     = regexp.MustCompile(`^#{1,6}\s+(.*?)(?:\s+#+)?\s*$`)
+	listItem    = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	link        = regexp.MustCompile(`!?\[([^\]\n]+)\]\((https?://[^)\s"]+)\)`)
+	boldText    = regexp.MustCompile(`\*\*([^*\n]+)\*\*|__([^_\n]+)__`)
+	struckText  = regexp.MustCompile(`~~([^~\n]+)~~`)
+)
+
+// PlainUpdateMessage is what UpdateMessage sends with DefaultTemplate, without any formatting. The notes are
+// sent as they were written. It is the fallback for messages Telegram refuses to parse.
+func PlainUpdateMessage(repository repo.RepoWithChatID, isPre bool) *telego.SendMessageParams {
+	header, title, byline := updateHeader(repository, isPre)
+	notes, _ := truncate(cleanNotes(repository.Notes.Description), notesRoom(header, title, byline))
+
+	text := header
+	for _, line := range []string{title, byline} {
+		if line != "" {
+			text += "\n" + line
+		}
+	}
+	if notes != "" {
+		text += "\n\n" + notes
+	}
+
+	return tu.Message(tu.ID(chatID(repository)), text).
+		WithLinkPreviewOptions(&telego.LinkPreviewOptions{IsDisabled: true}).
+		WithReplyMarkup(releaseKeyboard(repository))
+}
+
+func releaseKeyboard(repository repo.RepoWithChatID) *telego.InlineKeyboardMarkup {
+	return tu.InlineKeyboard(
+		tu.InlineKeyboardRow(
+			telego.InlineKeyboardButton{
+				Text: consts.CheckRepo,
+				URL:  repository.Link + "/releases/" + repository.CurrentReleaseTagName,
+			},
+		),
+	)
+}
+
+func chatID(repository repo.RepoWithChatID) int64 {
+	intID, _ := strconv.Atoi(repository.ChatID)
+	return int64(intID)
+}
+
+// updateHeader returns the plain text lines heading the announcement of a release,
+// the title and byline are empty when there is nothing to tell.
+func updateHeader(repository repo.RepoWithChatID, isPre bool) (header, title, byline string) {
+	pre := ""
+	if isPre {
+		pre = "pre"
+	}
+	header = "New " + pre + "release: " + repository.Name + " : " + repository.CurrentReleaseTagName
+
+	notes := repository.Notes
+	if notes.Name != repository.CurrentReleaseTagName {
+		title, _ = truncate(strings.TrimSpace(notes.Name), maxTitleLength)
+	}
+
+	var by []string
+	if notes.Author != "" {
+		by = append(by, "by "+notes.Author)
+	}
+	if !notes.PublishedAt.IsZero() {
+		by = append(by, "published "+notes.PublishedAt.UTC().Format("2006-01-02"))
+	}
+	byline = strings.Join(by, ", ")
+
+	return header, title, byline
+}
+
+// notesRoom is how long the notes may get next to the given header lines.
+func notesRoom(lines ...string) int {
+	room := maxMessageLength - len("\n\n")
+	for _, line := range lines {
+		room -= utf16Length(line) + len("\n")
+	}
+
+	return max(room, 0)
+}
+
+// cleanNotes drops what never shows on GitHub either, like the comments left by generated release notes.
+func cleanNotes(markdown string) string {
+	markdown = strings.ReplaceAll(markdown, "\r\n", "\n")
+	markdown = htmlComment.ReplaceAllString(markdown, "")
+	markdown = blankLines.ReplaceAllString(markdown, "\n\n")
+
+	return strings.TrimSpace(markdown)
+}
+
+// truncate cuts text down to at most limit UTF-16 code units, ending it with an ellipsis.
+// It prefers to cut at the end of a line, unless that throws away more than half of what fits.
+func truncate(text string, limit int) (string, bool) {
+	if utf16Length(text) <= limit {
+		return text, false
+	}
+	if limit <= 0 {
+		return "", true
+	}
+
+	// one code unit is left for the ellipsis
+	length, end := 0, len(text)
+	for i, r := range text {
+		length += utf16.RuneLen(r)
+		if length > limit-1 {
+			end = i
+			break
+		}
+	}
+
+	cut := text[:end]
+	if newline := strings.LastIndexByte(cut, '\n'); newline > len(cut)/2 {
+		cut = cut[:newline]
+	}
+
+	return strings.TrimRight(cut, " \t\n") + "…", true
+}
+
+func utf16Length(text string) int {
+	length := 0
+	for _, r := range text {
+		length += utf16.RuneLen(r)
+	}
+
+	return length
+}
+
+// releaseNotesHTML converts the parts of GitHub markdown Telegram has a counterpart for: headings, list items,
+// bold and struck through text, links, inline code and code blocks. Everything else is kept as written.
+// The visible text never gets longer than markdown, and the tags are always balanced, even when markdown
+// was cut off in the middle of a code block.
+func releaseNotesHTML(markdown string) string {
+	var out []string
+	var code []string
+	inCode := false
+
+	for _, line := range strings.Split(markdown, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			if inCode {
+				out = append(out, "<pre>"+strings.Join(code, "\n")+"</pre>")
+				code = nil
+			}
+			inCode = !inCode
+			continue
+		}
+
+		if inCode {
+			code = append(code, html.EscapeString(line))
+			continue
+		}
+
+		if match := heading.FindStringSubmatch(line); match != nil {
+			out = append(out, "<b>"+inlineHTML(match[1])+"</b>")
+			continue
+		}
+
+		if match := listItem.FindStringSubmatch(line); match != nil {
+			out = append(out, match[1]+"• "+inlineHTML(match[2]))
+			continue
+		}
+
+		out = append(out, inlineHTML(line))
+	}
+
+	if inCode {
+		out = append(out, "<pre>"+strings.Join(code, "\n")+"</pre>")
+	}
+
+	return strings.Join(out, "\n")
+}
+
+// inlineHTML converts the formatting within a single line. Text between backticks is code and left alone,
+// an unmatched backtick is kept as it is.
+func inlineHTML(line string) string {
+	parts := strings.Split(line, "`")
+	if len(parts)%2 == 0 {
+		last := len(parts) - 1
+		parts = append(parts[:last-1], parts[last-1]+"`"+parts[last])
+	}
+
+	var converted strings.Builder
+	for i, part := range parts {
+		if i%2 == 1 {
+			converted.WriteString("<code>" + html.EscapeString(part) + "</code>")
+			continue
+		}
+
+		text := html.EscapeString(part)
+		text = link.ReplaceAllString(text, `<a href="$2">$1</a>`)
+		text = boldText.ReplaceAllString(text, "<b>$1$2</b>")
+		text = struckText.ReplaceAllString(text, "<s>$1</s>")
+		converted.WriteString(text)
+	}
+
+	return converted.String()
+}
@@ -0,0 +1,91 @@
+package messages
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chofnar/release-bot/internal/server/repo"
+)
+
+func TestReleaseNotesHTML(t *testing.T) {
+	for _, test := range []struct {
+		markdown, want string
+	}{
+		{"## What's Changed", "<b>What&#39;s Changed</b>"},
+		{"* fix `a<b` by @x in https://github.com/a/b/pull/1", "• fix <code>a&lt;b</code> by @x in https://github.com/a/b/pull/1"},
+		{"  - **nested** ~~gone~~", "  • <b>nested</b> <s>gone</s>"},
+		{"see [the docs](https://example.com/?a=1&b=2)", `see <a href="https://example.com/?a=1&amp;b=2">the docs</a>`},
+		{"[local](docs/x.md) and an odd ` backtick", "[local](docs/x.md) and an odd ` backtick"},
+		{"```go\nif a < b {}\n```\nafter", "<pre>if a &lt; b {}</pre>\nafter"},
+		// cut off in the middle of a code block
+		{"```\nunfinished", "<pre>unfinished</pre>"},
+	} {
+		if got := releaseNotesHTML(test.markdown); got != test.want {
+			t.Errorf("releaseNotesHTML(%q) = %q, want %q", test.markdown, got, test.want)
+		}
+	}
+}
+
+func TestCleanNotes(t *testing.T) {
+	markdown := "<!-- Release notes generated using configuration in .github/release.yml -->\r\n\r\n## Changes\r\n\r\n\r\n\r\n* one\r\n"
+	if got, want := cleanNotes(markdown), "## Changes\n\n* one"; got != want {
+		t.Errorf("cleanNotes = %q, want %q", got, want)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got, cut := truncate("short", 10); got != "short" || cut {
+		t.Errorf("truncate kept %q, %v", got, cut)
+	}
+
+	if got, _ := truncate("first line\nsecond line", 16); got != "first line…" {
+		t.Errorf("truncate = %q, want the cut at the line end", got)
+	}
+
+	// 😀 takes two UTF-16 code units
+	if got, _ := truncate("😀😀😀", 5); got != "😀😀…" {
+		t.Errorf("truncate = %q", got)
+	}
+}
+
+func TestUpdateMessageFitsTelegram(t *testing.T) {
+	repository := repo.RepoWithChatID{
+		ChatID: "1",
+		Repo: repo.Repo{
+			Name: "b",
+			Link: "https://github.com/a/b",
+			Release: repo.Release{
+				CurrentReleaseTagName: "v1.0.0",
+				Notes: repo.ReleaseNotes{
+					Name:        "The big one",
+					Description: strings.Repeat("* **change** <with> `code`\n", 1000),
+					PublishedAt: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC),
+					Author:      "octocat",
+				},
+			},
+		},
+	}
+
+	message := UpdateMessage(repository, false, "")
+	if !strings.HasPrefix(message.Text, "New release: b : v1.0.0\n<b>The big one</b>\n<i>by octocat, published 2025-03-01</i>\n\n• ") {
+		t.Errorf("unexpected message start %q", message.Text[:100])
+	}
+	if !strings.HasSuffix(message.Text, "…") {
+		t.Error("notes were not cut short")
+	}
+
+	plain := PlainUpdateMessage(repository, false)
+	if length := utf16Length(plain.Text); length > maxMessageLength {
+		t.Errorf("plain message is %d long", length)
+	}
+	if plain.ParseMode != "" {
+		t.Errorf("plain message parses %s", plain.ParseMode)
+	}
+
+	// without notes the message is what it always was
+	repository.Notes = repo.ReleaseNotes{Name: "v1.0.0"}
+	if message := UpdateMessage(repository, true, ""); message.Text != "New prerelease: b : v1.0.0" {
+		t.Errorf("message = %q", message.Text)
+	}
+}
@@ -0,0 +1,56 @@
+package messages
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/chofnar/release-bot/internal/server/repo"
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// OutboxMessage turns a notification into what the outbox keeps of it. The text of plain, which may be nil,
+// is sent instead when Telegram can't parse the entities of message.
+func OutboxMessage(key, repoID string, message, plain *telego.SendMessageParams) repo.OutboxMessage {
+	outbox := repo.OutboxMessage{
+		Key:       key,
+		ChatID:    message.ChatID.String(),
+		RepoID:    repoID,
+		Text:      message.Text,
+		ParseMode: message.ParseMode,
+		Silent:    message.DisableNotification,
+	}
+	if keyboard, ok := message.ReplyMarkup.(*telego.InlineKeyboardMarkup); ok && keyboard != nil {
+		encoded, err := json.Marshal(keyboard)
+		if err == nil {
+			outbox.Keyboard = string(encoded)
+		}
+	}
+	if plain != nil {
+		outbox.PlainText = plain.Text
+	}
+
+	return outbox
+}
+
+// SendOutboxMessage is what is sent for a message from the outbox, its plain text if plain is set.
+func SendOutboxMessage(message repo.OutboxMessage, plain bool) *telego.SendMessageParams {
+	intID, _ := strconv.Atoi(message.ChatID)
+
+	params := tu.Message(tu.ID(int64(intID)), message.Text).
+		WithParseMode(message.ParseMode).
+		WithLinkPreviewOptions(&telego.LinkPreviewOptions{IsDisabled: true})
+	if plain {
+		params.Text, params.ParseMode = message.PlainText, ""
+	}
+	params.DisableNotification = message.Silent
+
+	if message.Keyboard != "" {
+		var keyboard telego.InlineKeyboardMarkup
+		if json.Unmarshal([]byte(message.Keyboard), &keyboard) == nil {
+			params.ReplyMarkup = &keyboard
+		}
+	}
+
+	return params
+}
@@ -0,0 +1,35 @@
+package messages
+
+import (
+	"testing"
+
+	"github.com/chofnar/release-bot/internal/server/repo"
+	"github.com/mymmrac/telego"
+)
+
+func TestOutboxMessageRoundTrip(t *testing.T) {
+	repository := repo.RepoWithChatID{
+		ChatID: "42",
+		Repo: repo.Repo{
+			Name:    "b",
+			Link:    "https://github.com/a/b",
+			Release: repo.Release{CurrentReleaseTagName: "v1.0.0"},
+		},
+	}
+
+	message := UpdateMessage(repository, false, "<b>{{.Tag}}</b>")
+	outbox := OutboxMessage("key", "R_1", message, PlainUpdateMessage(repository, false))
+	if outbox.ChatID != "42" || outbox.ParseMode != telego.ModeHTML || outbox.PlainText != "New release: b : v1.0.0" {
+		t.Errorf("OutboxMessage = %+v", outbox)
+	}
+
+	sent := SendOutboxMessage(outbox, false)
+	keyboard, ok := sent.ReplyMarkup.(*telego.InlineKeyboardMarkup)
+	if sent.Text != "<b>v1.0.0</b>" || sent.ChatID.ID != 42 || !ok || keyboard.InlineKeyboard[0][0].URL != "https://github.com/a/b/releases/v1.0.0" {
+		t.Errorf("SendOutboxMessage = %+v", sent)
+	}
+
+	if plain := SendOutboxMessage(outbox, true); plain.Text != outbox.PlainText || plain.ParseMode != "" {
+		t.Errorf("plain SendOutboxMessage = %+v", plain)
+	}
+}
@@ -0,0 +1,322 @@
+package messages
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+	"text/template/parse"
+
+	"github.com/chofnar/release-bot/internal/server/repo"
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// maxTemplateLength bounds the templates a chat may store, and how long they render without the notes.
+const maxTemplateLength = 1024
+
+// DefaultTemplate renders the release notifications of chats that didn't set a template of their own.
+const DefaultTemplate = `New {{if .IsPrerelease}}pre{{end}}release: {{.Repo}} : {{.Tag}}
+{{- if .Title}}
+<b>{{.Title}}</b>
+{{- end}}
+{{- if or .Author .PublishedAt}}
+<i>{{with .Author}}by {{.}}{{end}}{{if and .Author .PublishedAt}}, {{end}}{{with .PublishedAt}}published {{.}}{{end}}</i>
+{{- end}}
+{{- with .Notes}}
+
+{{.}}
+{{- end}}`
+
+var defaultTemplate = template.Must(ParseTemplate(DefaultTemplate))
+
+// templateFuncs are the only functions a template may call, none of them can blow up the message.
+var templateFuncs = map[string]bool{"and": true, "or": true, "not": true, "eq": true, "ne": true}
+
+// telegramTags are the HTML tags Telegram understands, see https://core.telegram.org/bots/api#html-style
+var telegramTags = map[string]bool{
+	"b": true, "strong": true, "i": true, "em": true, "u": true, "ins": true, "s": true, "strike": true, "del": true,
+	"a": true, "code": true, "pre": true, "span": true, "tg-spoiler": true, "tg-emoji": true, "blockquote": true,
+}
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// templateData are the variables of a template. Title is "" when it is just the tag, Author, PublishedAt
+// and Notes are "" when unknown. Notes is the excerpt of the release notes that fits into the message.
+type templateData struct {
+	Owner        string
+	Repo         string
+	Tag          string
+	IsPrerelease bool
+	URL          string
+	Title        string
+	Author       string
+	PublishedAt  string
+	Notes        template.HTML
+}
+
+func newTemplateData(repository repo.RepoWithChatID, isPre bool) templateData {
+	_, title, _ := updateHeader(repository, isPre)
+
+	data := templateData{
+		Owner:        repository.Owner,
+		Repo:         repository.Name,
+		Tag:          repository.CurrentReleaseTagName,
+		IsPrerelease: isPre,
+		URL:          repository.Link + "/releases/" + repository.CurrentReleaseTagName,
+		Title:        title,
+		Author:       repository.Notes.Author,
+	}
+	if !repository.Notes.PublishedAt.IsZero() {
+		data.PublishedAt = repository.Notes.PublishedAt.UTC().Format("2006-01-02")
+	}
+
+	return data
+}
+
+// sampleTemplateData is what templates are tried out with before they are saved.
+var sampleTemplateData = []templateData{
+	{
+		Owner: "octocat", Repo: "hello-world", Tag: "v1.2.3", URL: "https://github.com/octocat/hello-world/releases/v1.2.3",
+		Title: "Hello again", Author: "octocat", PublishedAt: "2025-01-02", Notes: "<b>What&#39;s Changed</b>\n• more greetings",
+	},
+	{Owner: "octocat", Repo: "hello-world", Tag: "v2.0.0-rc.1", IsPrerelease: true, URL: "https://github.com/octocat/hello-world/releases/v2.0.0-rc.1"},
+}
+
+// ParseTemplate parses a notification template and makes sure every message it renders is accepted by Telegram.
+// Templates are Go templates producing Telegram HTML. Besides if and with, they may only use the comparison and
+// logic functions, so rendering one stays cheap.
+func ParseTemplate(text string) (*template.Template, error) {
+	if utf16Length(text) > maxTemplateLength {
+		return nil, fmt.Errorf("a template may be at most %d characters long", maxTemplateLength)
+	}
+
+	tmpl, err := template.New("notification").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tmpl.Templates()) > 1 {
+		return nil, fmt.Errorf("define and block are not supported")
+	}
+
+	err = checkNodes(tmpl.Tree.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, data := range sampleTemplateData {
+		rendered, err := execute(tmpl, data)
+		if err != nil {
+			return nil, err
+		}
+
+		err = checkTelegramHTML(rendered)
+		if err != nil {
+			return nil, err
+		}
+
+		data.Notes = ""
+		rendered, _ = execute(tmpl, data)
+		if visibleLength(rendered) > maxTemplateLength {
+			return nil, fmt.Errorf("without the notes a message may be at most %d characters long", maxTemplateLength)
+		}
+	}
+
+	if rendered, _ := execute(tmpl, sampleTemplateData[0]); strings.TrimSpace(visibleText(rendered)) == "" {
+		return nil, fmt.Errorf("the template renders an empty message")
+	}
+
+	return tmpl, nil
+}
+
+// checkNodes rejects what could make a template expensive to render: loops, other templates and any
+// function outside of templateFuncs.
+func checkNodes(node parse.Node) error {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return nil
+		}
+		for _, child := range node.Nodes {
+			err := checkNodes(child)
+			if err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		return checkNodes(node.Pipe)
+	case *parse.IfNode:
+		return checkBranch(node.BranchNode)
+	case *parse.WithNode:
+		return checkBranch(node.BranchNode)
+	case *parse.PipeNode:
+		if node == nil {
+			return nil
+		}
+		for _, command := range node.Cmds {
+			for _, arg := range command.Args {
+				err := checkNodes(arg)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	case *parse.ChainNode:
+		return checkNodes(node.Node)
+	case *parse.IdentifierNode:
+		if !templateFuncs[node.Ident] {
+			return fmt.Errorf("the function %s is not supported", node.Ident)
+		}
+	case *parse.RangeNode, *parse.TemplateNode, *parse.BreakNode, *parse.ContinueNode:
+		return fmt.Errorf("%s is not supported", node)
+	}
+
+	return nil
+}
+
+func checkBranch(branch parse.BranchNode) error {
+	for _, node := range []parse.Node{branch.Pipe, branch.List, branch.ElseList} {
+		err := checkNodes(node)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkTelegramHTML tells if text only uses the tags Telegram understands, each of them closed in order.
+func checkTelegramHTML(text string) error {
+	var open []string
+	for {
+		start := strings.IndexByte(text, '<')
+		if start < 0 {
+			break
+		}
+
+		end := strings.IndexByte(text[start:], '>')
+		if end < 0 {
+			return fmt.Errorf("write &lt; for a < that doesn't start a tag")
+		}
+
+		tag := text[start+1 : start+end]
+		text = text[start+end+1:]
+
+		closing := strings.HasPrefix(tag, "/")
+		name := ""
+		if fields := strings.Fields(strings.TrimPrefix(tag, "/")); len(fields) > 0 {
+			name = strings.ToLower(fields[0])
+		}
+		if !telegramTags[name] {
+			return fmt.Errorf("the tag <%s> is not supported by Telegram", name)
+		}
+
+		if !closing {
+			open = append(open, name)
+			continue
+		}
+
+		if len(open) == 0 || open[len(open)-1] != name {
+			return fmt.Errorf("</%s> does not close the last opened tag", name)
+		}
+		open = open[:len(open)-1]
+	}
+
+	if len(open) > 0 {
+		return fmt.Errorf("<%s> is never closed", open[len(open)-1])
+	}
+
+	return nil
+}
+
+func execute(tmpl *template.Template, data templateData) (string, error) {
+	var rendered strings.Builder
+	err := tmpl.Execute(&rendered, data)
+	return rendered.String(), err
+}
+
+// visibleText is what Telegram shows of a message in HTML.
+func visibleText(rendered string) string {
+	return html.UnescapeString(htmlTag.ReplaceAllString(rendered, ""))
+}
+
+func visibleLength(rendered string) int {
+	return utf16Length(visibleText(rendered))
+}
+
+// renderUpdate renders the notification of a release with tmpl, fitting in as much of the notes as Telegram allows.
+func renderUpdate(tmpl *template.Template, repository repo.RepoWithChatID, isPre bool) (string, error) {
+	data := newTemplateData(repository, isPre)
+	withoutNotes, err := execute(tmpl, data)
+	if err != nil {
+		return "", err
+	}
+
+	notes := cleanNotes(repository.Notes.Description)
+	room := maxMessageLength - visibleLength(withoutNotes) - len("\n\n")
+	// a template may show the notes more than once, or add to them, the second try makes up for it
+	for range 2 {
+		excerpt, _ := truncate(notes, room)
+		if excerpt == "" {
+			break
+		}
+
+		data.Notes = template.HTML(releaseNotesHTML(excerpt))
+		rendered, err := execute(tmpl, data)
+		if err != nil {
+			return "", err
+		}
+
+		overflow := visibleLength(rendered) - maxMessageLength
+		if overflow <= 0 && strings.TrimSpace(visibleText(rendered)) != "" {
+			return rendered, nil
+		}
+
+		// shrinking every copy of the notes by its share of the overflow
+		copies := max(1, (visibleLength(rendered)-visibleLength(withoutNotes))/max(1, visibleLength(string(data.Notes))))
+		room -= (overflow + copies - 1) / copies
+	}
+
+	if strings.TrimSpace(visibleText(withoutNotes)) == "" {
+		return "", fmt.Errorf("template rendered an empty message for %s", data.Tag)
+	}
+	if visibleLength(withoutNotes) > maxMessageLength {
+		return "", fmt.Errorf("template rendered a message too long for Telegram for %s", data.Tag)
+	}
+
+	return withoutNotes, nil
+}
+
+// UpdateMessage announces a release with the chat's template, DefaultTemplate if it is "". The release notes
+// are converted from GitHub markdown to Telegram HTML and cut short to keep the message under maxMessageLength.
+// A template that stopped working falls back to DefaultTemplate.
+func UpdateMessage(repository repo.RepoWithChatID, isPre bool, chatTemplate string) *telego.SendMessageParams {
+	tmpl := defaultTemplate
+	if chatTemplate != "" {
+		parsed, err := ParseTemplate(chatTemplate)
+		if err == nil {
+			tmpl = parsed
+		}
+	}
+
+	text, err := renderUpdate(tmpl, repository, isPre)
+	if err != nil {
+		text, _ = renderUpdate(defaultTemplate, repository, isPre)
+	}
+
+	return tu.Message(tu.ID(chatID(repository)), text).
+		WithParseMode(telego.ModeHTML).
+		WithLinkPreviewOptions(&telego.LinkPreviewOptions{IsDisabled: true}).
+		WithReplyMarkup(releaseKeyboard(repository))
+}
+
+// TemplatePreviewMessage shows how tmpl renders a release.
+func TemplatePreviewMessage(chatID int64, tmpl *template.Template) *telego.SendMessageParams {
+	rendered, _ := execute(tmpl, sampleTemplateData[0])
+
+	return tu.Message(tu.ID(chatID), rendered).
+		WithParseMode(telego.ModeHTML).
+		WithLinkPreviewOptions(&telego.LinkPreviewOptions{IsDisabled: true})
+}
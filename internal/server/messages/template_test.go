@@ -0,0 +1,72 @@
+package messages
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chofnar/release-bot/internal/server/repo"
+)
+
+func TestParseTemplate(t *testing.T) {
+	for _, valid := range []string{
+		DefaultTemplate,
+		"{{.Tag}}",
+		`<b>{{.Owner}}/{{.Repo}}</b> <a href="{{.URL}}">{{.Tag}}</a>{{if not .IsPrerelease}} stable{{end}}`,
+		"{{.Tag}}{{with .Author}} by {{.}}{{else}} by someone{{end}}{{if eq .Owner .Repo}}!{{end}}",
+	} {
+		if _, err := ParseTemplate(valid); err != nil {
+			t.Errorf("ParseTemplate(%q) = %v", valid, err)
+		}
+	}
+
+	for _, invalid := range []string{
+		"{{.Tag",
+		"{{.Unknown}}",
+		"{{range 1000000000}}x{{end}}",
+		`{{printf "%0999999d" 1}}`,
+		`{{define "x"}}{{.Tag}}{{end}}{{template "x" .}}`,
+		"<b>{{.Tag}}",
+		"<div>{{.Tag}}</div>",
+		"<b><i>{{.Tag}}</b></i>",
+		"{{if .IsPrerelease}}{{.Tag}}{{end}}",
+		strings.Repeat("x", maxTemplateLength+1),
+	} {
+		if _, err := ParseTemplate(invalid); err == nil {
+			t.Errorf("ParseTemplate(%q) accepted it", invalid)
+		}
+	}
+}
+
+func TestUpdateMessageTemplate(t *testing.T) {
+	repository := repo.RepoWithChatID{
+		ChatID: "1",
+		Repo: repo.Repo{
+			Owner: "a",
+			Name:  "b",
+			Link:  "https://github.com/a/b",
+			Release: repo.Release{
+				CurrentReleaseTagName: "v1.0.0",
+				Notes: repo.ReleaseNotes{
+					Description: strings.Repeat("line\n", 2000),
+					PublishedAt: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC),
+				},
+			},
+		},
+	}
+
+	if got := UpdateMessage(repository, false, "{{.Owner}}/{{.Repo}} {{.Tag}} {{.PublishedAt}}").Text; got != "a/b v1.0.0 2025-03-01" {
+		t.Errorf("UpdateMessage = %q", got)
+	}
+
+	// the notes shown twice still have to fit
+	message := UpdateMessage(repository, false, "{{.Tag}}\n{{.Notes}}\n{{.Notes}}")
+	if length := visibleLength(message.Text); length > maxMessageLength || !strings.Contains(message.Text, "line") {
+		t.Errorf("notes twice rendered %d long", length)
+	}
+
+	// a template that no longer passes is replaced by the default one
+	if got := UpdateMessage(repository, false, "{{.Gone}}").Text; !strings.HasPrefix(got, "New release: b : v1.0.0\n") {
+		t.Errorf("UpdateMessage with a broken template = %q", got[:50])
+	}
+}
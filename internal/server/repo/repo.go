@@ -1,17 +1,99 @@
 package repo
 
+import "time"
+
+// Release is the last seen release of a repo. The newest release of either kind is the current one,
+// the newest stable release and the newest prerelease are also kept on their own so that chats
+// which only want stable releases aren't thrown off by prereleases and the other way around.
+// LatestTagName is the newest git tag, for chats tracking tags instead of releases,
+// TagsDigest tells whether the fetched tags changed since the last check.
 type Release struct {
 	CurrentReleaseTagName string `dynamodbav:"currentReleaseTagName,string" json:"tag_name"`
 	CurrentReleaseID      string `dynamodbav:"currentReleaseID,string" json:"id"`
 	IsPrerelease          bool   `json:"isPrerelease"`
+	StableReleaseTagName  string `dynamodbav:"stableReleaseTagName,string" json:"stable_tag_name,omitempty"`
+	StableReleaseID       string `dynamodbav:"stableReleaseID,string" json:"stable_id,omitempty"`
+	PrereleaseTagName     string `dynamodbav:"prereleaseTagName,string" json:"prerelease_tag_name,omitempty"`
+	PrereleaseID          string `dynamodbav:"prereleaseID,string" json:"prerelease_id,omitempty"`
+	LatestTagName         string `dynamodbav:"latestTagName,string" json:"latest_tag_name,omitempty"`
+	TagsDigest            string `dynamodbav:"tagsDigest,string" json:"tags_digest,omitempty"`
+	// Notes only come along with freshly fetched releases, they are never stored
+	Notes ReleaseNotes `dynamodbav:"-" json:"-"`
+}
+
+// ReleaseNotes is what the announcement of a release tells about it besides its tag.
+type ReleaseNotes struct {
+	Name string
+	// Description is the markdown body of the release
+	Description string
+	PublishedAt time.Time
+	Author      string
+}
+
+// UpdateLevel is the smallest version bump a subscription wants to hear about.
+type UpdateLevel string
+
+const (
+	// UpdateLevelAll also lets through releases that don't bump the version, like another build of it.
+	UpdateLevelAll   UpdateLevel = "all"
+	UpdateLevelPatch UpdateLevel = "patch"
+	UpdateLevelMinor UpdateLevel = "minor"
+	UpdateLevelMajor UpdateLevel = "major"
+)
+
+// UpdateLevels lists the levels from the most to the least notifications.
+var UpdateLevels = []UpdateLevel{UpdateLevelAll, UpdateLevelPatch, UpdateLevelMinor, UpdateLevelMajor}
+
+// Allows tells if a release bumping the version by bump is announced to a subscription at level.
+func (level UpdateLevel) Allows(bump UpdateLevel) bool {
+	return level.rank() <= bump.rank()
+}
+
+func (level UpdateLevel) rank() int {
+	for i, known := range UpdateLevels {
+		if level == known {
+			return i
+		}
+	}
+
+	// unset, as on subscriptions stored before levels existed
+	return 0
+}
+
+// Normalize turns an unset level into UpdateLevelAll, which is how it is treated.
+func (level UpdateLevel) Normalize() UpdateLevel {
+	return UpdateLevels[level.rank()]
+}
+
+// Next is the level after this one in UpdateLevels, wrapping around.
+func (level UpdateLevel) Next() UpdateLevel {
+	return UpdateLevels[(level.rank()+1)%len(UpdateLevels)]
+}
+
+// Valid tells if level is one of UpdateLevels.
+func (level UpdateLevel) Valid() bool {
+	for _, known := range UpdateLevels {
+		if level == known {
+			return true
+		}
+	}
+
+	return false
 }
 
 type Repo struct {
-	RepoID                 string `dynamobav:"repoID,string" json:"repo_id,omitempty"`
-	Name                   string `dynamodbav:"repoName,string" json:"name,omitempty"`
-	Owner                  string `dynamodbav:"repoOwner,string" json:"owner,omitempty"`
-	Link                   string `dynamodbav:"repoLink,string" json:"link,omitempty"`
-	ShouldNotifyPrerelease bool   `dynamodbav:"shouldPre,bool" json:"shouldPre,omitempty"`
+	RepoID                 string      `dynamobav:"repoID,string" json:"repo_id,omitempty"`
+	Name                   string      `dynamodbav:"repoName,string" json:"name,omitempty"`
+	Owner                  string      `dynamodbav:"repoOwner,string" json:"owner,omitempty"`
+	Link                   string      `dynamodbav:"repoLink,string" json:"link,omitempty"`
+	ShouldNotifyPrerelease bool        `dynamodbav:"shouldPre,bool" json:"shouldPre,omitempty"`
+	TrackTags              bool        `dynamodbav:"trackTags,bool" json:"trackTags,omitempty"`
+	UpdateLevel            UpdateLevel `dynamodbav:"updateLevel,string" json:"updateLevel,omitempty"`
+	// IncludeTags and ExcludeTags are regular expressions release and tag names must, or must not, match
+	IncludeTags string `dynamodbav:"includeTags,string" json:"includeTags,omitempty"`
+	ExcludeTags string `dynamodbav:"excludeTags,string" json:"excludeTags,omitempty"`
+	// LastTagName is the newest tag, out of those passing the filters, the chat tracking tags has heard of
+	LastTagName string `dynamodbav:"lastTagName,string" json:"lastTagName,omitempty"`
 	Release
 }
 
@@ -19,3 +101,82 @@ type RepoWithChatID struct {
 	Repo
 	ChatID string `dynamobav:"chatID,string"`
 }
+
+// DeliveryMode is when a chat is told about new releases.
+type DeliveryMode string
+
+const (
+	// DeliveryInstant sends every release as soon as it is found, it is what an unset mode stands for.
+	DeliveryInstant DeliveryMode = "instant"
+	// DeliveryDaily and DeliveryWeekly queue the releases up for a digest at the chat's digest hour,
+	// weekly digests go out on Mondays.
+	DeliveryDaily  DeliveryMode = "daily"
+	DeliveryWeekly DeliveryMode = "weekly"
+)
+
+// Digest tells if mode queues releases up for a digest.
+func (mode DeliveryMode) Digest() bool {
+	return mode == DeliveryDaily || mode == DeliveryWeekly
+}
+
+// QuietMode is what happens to the releases found during a chat's quiet hours.
+type QuietMode string
+
+const (
+	// QuietHold keeps the releases in the queue until the quiet hours end, it is what an unset mode stands for.
+	QuietHold QuietMode = "hold"
+	// QuietSilent sends them right away, without a sound.
+	QuietSilent QuietMode = "silent"
+)
+
+// ChatSettings are the settings of a chat that apply to all of its repos.
+type ChatSettings struct {
+	ChatID string
+	// Template renders the chat's release notifications, "" stands for the default one
+	Template string
+	Delivery DeliveryMode
+	// DigestHour is the hour of the day, in TimeZone, digests are sent at
+	DigestHour int
+	// TimeZone is an IANA time zone name, "" stands for UTC
+	TimeZone string
+	// QuietFrom and QuietUntil are the hours, in TimeZone, the quiet hours start and end at. They may wrap
+	// around midnight, the same hour for both means there are none.
+	QuietFrom  int
+	QuietUntil int
+	QuietMode  QuietMode
+}
+
+// PendingNotification is a release, or a tag, waiting in the queue for the next digest of a chat,
+// or for its quiet hours to end.
+// A chat has at most one per repo and tag.
+type PendingNotification struct {
+	ChatID       string
+	RepoID       string
+	TagName      string
+	RepoName     string
+	RepoLink     string
+	IsPrerelease bool
+	IsTag        bool
+	QueuedAt     time.Time
+}
+
+// OutboxMessage is a notification on its way to a chat. It stays in the outbox until Telegram took it, Key keeps
+// the same release from being queued, and so sent, to a chat twice.
+type OutboxMessage struct {
+	Key       string
+	ChatID    string
+	RepoID    string
+	Text      string
+	ParseMode string
+	// Keyboard is the JSON of the message's inline keyboard, "" for none
+	Keyboard string
+	// PlainText is sent instead of Text when Telegram can't parse Text, "" if there is nothing to fall back to
+	PlainText     string
+	Silent        bool
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	CreatedAt     time.Time
+	// DeliveredAt is zero until the message left the outbox, sent or given up on
+	DeliveredAt time.Time
+}
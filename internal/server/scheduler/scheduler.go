@@ -0,0 +1,46 @@
+package scheduler
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// Scheduler calls Run over and over, waiting Interval plus a random delay of up to Jitter
+// after every run. Runs never overlap, the wait only starts once the previous run returned.
+type Scheduler struct {
+	Interval, Jitter time.Duration
+	Run              func(ctx context.Context)
+}
+
+// Start schedules runs until ctx is done. The returned channel is closed once the
+// scheduler stopped and the last run, which gets the same ctx, has returned.
+func (s Scheduler) Start(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for {
+			timer := time.NewTimer(s.nextWait())
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+
+			s.Run(ctx)
+		}
+	}()
+
+	return done
+}
+
+func (s Scheduler) nextWait() time.Duration {
+	if s.Jitter <= 0 {
+		return s.Interval
+	}
+
+	return s.Interval + rand.N(s.Jitter)
+}
@@ -0,0 +1,38 @@
+package scheduler
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSchedulerRunsUntilCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var runs, running atomic.Int32
+	done := Scheduler{
+		Interval: time.Millisecond,
+		Jitter:   time.Millisecond,
+		Run: func(ctx context.Context) {
+			if running.Add(1) > 1 {
+				t.Error("runs overlap")
+			}
+			defer running.Add(-1)
+
+			if runs.Add(1) == 3 {
+				cancel()
+			}
+		},
+	}.Start(ctx)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("scheduler did not stop after its context was cancelled")
+	}
+
+	if got := runs.Load(); got != 3 {
+		t.Errorf("got %d runs, want 3", got)
+	}
+}
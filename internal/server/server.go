@@ -9,6 +9,7 @@ import (
 	"os/signal"
 	"regexp"
 	"strconv"
+	"sync"
 
 	"github.com/chofnar/release-bot/internal/database"
 	databaseLoader "github.com/chofnar/release-bot/internal/database/loader"
@@ -17,6 +18,7 @@ import (
 	botConfig "github.com/chofnar/release-bot/internal/server/config"
 	"github.com/chofnar/release-bot/internal/server/consts"
 	"github.com/chofnar/release-bot/internal/server/logger"
+	"github.com/chofnar/release-bot/internal/server/scheduler"
 	myHandlers "github.com/chofnar/release-bot/internal/server/telegohandlers"
 	th "github.com/mymmrac/telego/telegohandler"
 
@@ -32,6 +34,11 @@ import (
 func Initialize(logger zap.SugaredLogger) (*botConfig.BotConfig, database.Database) {
 	conf := botConfig.LoadBotConfig()
 	db := databaseLoader.GetDatabase(logger)
+	if db == nil {
+		logger.Error(errors.ErrUnknownDatabaseDriver)
+
+		panic(errors.ErrUnknownDatabaseDriver)
+	}
 	return conf, db
 }
 
@@ -58,20 +65,25 @@ func Start() {
 	directRegex, _ := regexp.Compile("(.*)[/](.*)")
 
 	behaviorHandler := behaviors.BehaviorHandler{
-		Bot:         bot,
-		LinkRegex:   linkRegex,
-		DirectRegex: directRegex,
-		GQLClient:   githubGQLClient,
-		DB:          db,
+		Sender:        behaviors.NewSender(bot),
+		LinkRegex:     linkRegex,
+		DirectRegex:   directRegex,
+		GQLClient:     githubGQLClient,
+		GitHubBudget:  behaviors.NewGitHubBudget(),
+		UpdateGuard:   &sync.Mutex{},
+		DB:            db,
+		UpdateWorkers: botConf.UpdateWorkers,
 	}
 
-	awaitingAddRepo := map[int64]struct{}{}
+	awaitingAddRepo := map[myHandlers.Awaiting]struct{}{}
+	awaitingTimeZone := map[myHandlers.Awaiting]struct{}{}
 
 	handler := myHandlers.Handler{
-		BehaviorHandler: behaviorHandler,
-		Logger:          *logger,
-		AwaitingAddRepo: awaitingAddRepo,
-		Limit:           botConf.Limit,
+		BehaviorHandler:  behaviorHandler,
+		Logger:           *logger,
+		AwaitingAddRepo:  awaitingAddRepo,
+		AwaitingTimeZone: awaitingTimeZone,
+		Limit:            botConf.Limit,
 	}
 
 	if botConf.ResetWebhookUrl != "" {
@@ -94,6 +106,10 @@ func Start() {
 	mux.Handle("/stats", stats.ServeHTTP(&behaviorHandler, *logger))
 	up := UpdatePath{}
 	mux.Handle("/updateRepos", up.UpdateRepos(&behaviorHandler, *logger))
+	webhook := &WebhookPath{}
+	if botConf.GithubWebhookSecret != "" {
+		mux.Handle("/githubWebhook", webhook.ServeHTTP(&behaviorHandler, *logger, botConf.GithubWebhookSecret))
+	}
 
 	updates, err := bot.UpdatesViaWebhook("/bot/"+bot.Token(), telego.WithWebhookServer(telego.HTTPWebhookServer{
 		Logger:   logger,
@@ -109,16 +125,27 @@ func Start() {
 		panic(err)
 	}
 
-	// register handlers
+	me, err := bot.GetMe()
+	if err != nil {
+		panic(err)
+	}
+
+	// register handlers, commands meant for other bots in a group go nowhere
+	botHandler.Handle(handler.Ignore(), th.Not(myHandlers.ForBot(me.Username)))
 	botHandler.Handle(handler.Start(), th.CommandEqual("start"))
 	botHandler.Handle(handler.About(), th.CommandEqual("about"))
+	botHandler.Handle(handler.Filter(), th.CommandEqual("filter"))
+	botHandler.Handle(handler.Template(), th.CommandEqual("template"))
+	botHandler.Handle(handler.Digest(), th.CommandEqual("digest"))
 	botHandler.Handle(handler.UnknownOrSent(), th.AnyMessageWithText())
 
 	// Callback queries
-	botHandler.HandleCallbackQuery(handler.SeeRepos(botConf.Limit, 0), th.CallbackDataEqual(consts.SeeAllCallback))
-	botHandler.HandleCallbackQuery(handler.Add(), th.CallbackDataEqual(consts.AddCallback))
-	botHandler.HandleCallbackQuery(handler.Menu(), th.CallbackDataEqual(consts.MenuCallback))
-	botHandler.HandleCallbackQuery(handler.AnyCallbackRouter(), th.AnyCallbackQuery())
+	botHandler.HandleCallbackQueryCtx(handler.SeeRepos(botConf.Limit, 0), th.CallbackDataEqual(consts.SeeAllCallback))
+	botHandler.HandleCallbackQueryCtx(handler.Add(), th.CallbackDataEqual(consts.AddCallback))
+	botHandler.HandleCallbackQueryCtx(handler.Menu(), th.CallbackDataEqual(consts.MenuCallback))
+	botHandler.HandleCallbackQueryCtx(handler.Settings(), th.CallbackDataEqual(consts.SettingsCallback))
+	botHandler.HandleCallbackQueryCtx(handler.TimeZone(), th.CallbackDataEqual(consts.TimeZoneCallback))
+	botHandler.HandleCallbackQueryCtx(handler.AnyCallbackRouter(), th.AnyCallbackQuery())
 
 	// start listening
 
@@ -142,7 +169,46 @@ func Start() {
 	nctx, stop := signal.NotifyContext(ctx, os.Interrupt, os.Kill)
 
 	defer stop()
+
+	var schedulerDone <-chan struct{}
+	if botConf.PollInterval > 0 {
+		logger.Infof("checking repos every %s with up to %s jitter", botConf.PollInterval, botConf.PollJitter)
+		schedulerDone = scheduler.Scheduler{
+			Interval: botConf.PollInterval,
+			Jitter:   botConf.PollJitter,
+			Run: func(ctx context.Context) {
+				scheduledUpdate(ctx, &behaviorHandler, *logger)
+			},
+		}.Start(nctx)
+	}
+
 	<-nctx.Done()
+
+	if schedulerDone != nil {
+		// the running update sees the cancelled context and stops early
+		<-schedulerDone
+	}
+
+	// no delivery comes in once the server is down, those already accepted still get their notifications out
+	_ = bot.StopWebhook()
+	webhook.announcing.Wait()
+}
+
+func scheduledUpdate(ctx context.Context, behaviorHandler *behaviors.BehaviorHandler, logger zap.SugaredLogger) {
+	defer logger.Sync()
+
+	failedRepoErrors, err := behaviorHandler.TryUpdateRepos(ctx, logger)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+
+	if len(failedRepoErrors) != 0 {
+		logger.Error(failedRepoErrors)
+		return
+	}
+
+	logger.Info("Repos updated successfully with no funky business")
 }
 
 type StatsPath struct{}
@@ -172,20 +238,19 @@ func (hp StatsPath) ServeHTTP(behaviorHandler *behaviors.BehaviorHandler, logger
 			return
 		}
 
-		repos, err := behaviorHandler.DB.AllRepos()
-		if err != nil {
-			msg := "Something went wrong querying the database: " + err.Error()
-			logger.Error([]byte(msg))
-			_, writeErr := w.Write([]byte(msg))
-			if writeErr != nil {
-				logger.Error(writeErr)
-			}
-			return
-		}
-
 		uniqueUsersSet, uniqueReposSet := mapset.NewSet[string](), mapset.NewSet[string]()
 
-		for _, repo := range repos {
+		for repo, err := range behaviorHandler.DB.IterRepos(r.Context()) {
+			if err != nil {
+				msg := "Something went wrong querying the database: " + err.Error()
+				logger.Error([]byte(msg))
+				_, writeErr := w.Write([]byte(msg))
+				if writeErr != nil {
+					logger.Error(writeErr)
+				}
+				return
+			}
+
 			uniqueUsersSet.Add(repo.ChatID)
 			uniqueReposSet.Add(repo.RepoID)
 		}
@@ -224,7 +289,16 @@ func (up UpdatePath) UpdateRepos(behaviorHandler *behaviors.BehaviorHandler, log
 			return
 		}
 
-		failedRepoErrors := behaviorHandler.UpdateRepos(logger)
+		failedRepoErrors, err := behaviorHandler.TryUpdateRepos(r.Context(), logger)
+		if err != nil {
+			logger.Error(err)
+			_, writeErr := w.Write([]byte(err.Error()))
+			if writeErr != nil {
+				logger.Error(writeErr)
+			}
+			return
+		}
+
 		marshaledErrors, err := json.Marshal(failedRepoErrors)
 		if err != nil {
 			logger.Error(err)
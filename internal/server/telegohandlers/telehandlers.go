@@ -1,30 +1,206 @@
 package telegohandlers
 
 import (
+	"context"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/chofnar/release-bot/internal/server/behaviors"
 	"github.com/chofnar/release-bot/internal/server/consts"
 	"github.com/mymmrac/telego"
 	"github.com/mymmrac/telego/telegohandler"
+	tu "github.com/mymmrac/telego/telegoutil"
 	"go.uber.org/zap"
 )
 
+// Awaiting is a member of a chat the bot waits for a message from. In groups every member is asked on their own,
+// so that the message of one isn't taken for the answer of another.
+type Awaiting struct {
+	ChatID int64
+	UserID int64
+}
+
 type Handler struct {
 	BehaviorHandler behaviors.BehaviorHandler
 	Logger          zap.SugaredLogger
-	AwaitingAddRepo map[int64]struct{}
-	Limit           int
+	AwaitingAddRepo map[Awaiting]struct{}
+	// AwaitingTimeZone holds the members asked for the chat's time zone in the settings
+	AwaitingTimeZone map[Awaiting]struct{}
+	Limit            int
+
+	// mu guards both awaiting maps, telego runs the handlers of different updates at the same time
+	mu sync.Mutex
 }
 
 type void struct{}
 
 var set void
 
+// handlerTimeout bounds the database and GitHub calls made for a single update.
+// Telego detaches the update context from the webhook request, so there is no deadline otherwise.
+const handlerTimeout = 30 * time.Second
+
+// ForBot matches every update but the commands addressed to another bot, like /start@otherbot in a group.
+// Telegram usernames are case-insensitive.
+func ForBot(username string) telegohandler.Predicate {
+	return func(update telego.Update) bool {
+		if update.Message == nil {
+			return true
+		}
+
+		_, addressee, _ := tu.ParseCommandPayload(update.Message.Text)
+		return addressee == "" || strings.EqualFold(strings.TrimPrefix(addressee, "@"), username)
+	}
+}
+
+// Ignore drops an update.
+func (hc *Handler) Ignore() telegohandler.Handler {
+	return func(bot *telego.Bot, update telego.Update) {}
+}
+
+// await makes the member the one awaited in awaiting, and no longer in the other map.
+func (hc *Handler) await(awaiting map[Awaiting]struct{}, member Awaiting) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	delete(hc.AwaitingAddRepo, member)
+	delete(hc.AwaitingTimeZone, member)
+	awaiting[member] = set
+}
+
+func (hc *Handler) awaiting(awaiting map[Awaiting]struct{}, member Awaiting) bool {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	_, ok := awaiting[member]
+	return ok
+}
+
+// forget stops waiting for a message of the member.
+func (hc *Handler) forget(member Awaiting) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	delete(hc.AwaitingAddRepo, member)
+	delete(hc.AwaitingTimeZone, member)
+}
+
+// messageAwaiting is who sent the message. Anonymous admins send theirs as the group itself, they are awaited as
+// the group.
+func messageAwaiting(message *telego.Message) Awaiting {
+	awaiting := Awaiting{ChatID: message.Chat.ID}
+	if message.SenderChat != nil && message.SenderChat.ID == message.Chat.ID {
+		awaiting.UserID = message.Chat.ID
+	} else if message.From != nil {
+		awaiting.UserID = message.From.ID
+	}
+
+	return awaiting
+}
+
+func queryAwaiting(query telego.CallbackQuery) Awaiting {
+	return Awaiting{ChatID: query.Message.GetChat().ID, UserID: query.From.ID}
+}
+
+// canManage tells if the user may change the chat's repos and settings: anyone in a private chat, the admins
+// in groups. anonymous tells if they are an admin who sends their messages as the group.
+func (hc *Handler) canManage(ctx context.Context, chat telego.Chat, userID int64) (allowed, anonymous bool) {
+	if chat.Type == telego.ChatTypePrivate {
+		return true, false
+	}
+
+	admin, anonymous, err := hc.BehaviorHandler.ChatAdmin(ctx, chat.ID, userID)
+	if err != nil {
+		hc.Logger.Error(err)
+		return false, false
+	}
+
+	return admin, anonymous
+}
+
+// commandAllowed tells if the sender of a command changing the chat's settings may, and tells them otherwise.
+func (hc *Handler) commandAllowed(ctx context.Context, message *telego.Message) bool {
+	// anonymous admins send their messages as the group itself
+	if message.SenderChat != nil && message.SenderChat.ID == message.Chat.ID {
+		return true
+	}
+
+	if message.From != nil {
+		if allowed, _ := hc.canManage(ctx, message.Chat, message.From.ID); allowed {
+			return true
+		}
+	}
+
+	err := hc.BehaviorHandler.AdminsOnly(ctx, message.Chat.ID)
+	if err != nil {
+		hc.Logger.Error(err)
+	}
+
+	return false
+}
+
+// queryAllowed tells if the member who tapped a button managing the chat may, and tells them otherwise.
+// It returns whom to await an answer from, the group for an anonymous admin.
+func (hc *Handler) queryAllowed(ctx context.Context, query telego.CallbackQuery) (Awaiting, bool) {
+	chat := query.Message.GetChat()
+	allowed, anonymous := hc.canManage(ctx, chat, query.From.ID)
+	if allowed && anonymous {
+		return Awaiting{ChatID: chat.ID, UserID: chat.ID}, true
+	}
+	if allowed {
+		return queryAwaiting(query), true
+	}
+
+	err := hc.BehaviorHandler.AdminsOnlyAlert(ctx, query.ID)
+	if err != nil {
+		hc.Logger.Error(err)
+	}
+
+	return Awaiting{}, false
+}
+
+// forgetQuery stops waiting for an answer of the member who tapped a button. Whether they are an anonymous admin,
+// awaited as the group, takes asking Telegram, which is only done while the group is awaited.
+func (hc *Handler) forgetQuery(ctx context.Context, query telego.CallbackQuery) {
+	member := queryAwaiting(query)
+	hc.forget(member)
+
+	group := Awaiting{ChatID: member.ChatID, UserID: member.ChatID}
+	if group == member || !(hc.awaiting(hc.AwaitingAddRepo, group) || hc.awaiting(hc.AwaitingTimeZone, group)) {
+		return
+	}
+
+	if _, anonymous := hc.canManage(ctx, query.Message.GetChat(), query.From.ID); anonymous {
+		hc.forget(group)
+	}
+}
+
+// askForReply asks a member of a group to answer with a reply, the bot may not see their message otherwise.
+func (hc *Handler) askForReply(ctx context.Context, query telego.CallbackQuery, member Awaiting, prompt string) {
+	chat := query.Message.GetChat()
+	if chat.Type == telego.ChatTypePrivate {
+		return
+	}
+
+	var user *telego.User
+	if member.UserID != chat.ID {
+		user = &query.From
+	}
+
+	err := hc.BehaviorHandler.AskForReply(ctx, chat.ID, user, prompt)
+	if err != nil {
+		hc.Logger.Error(err)
+	}
+}
+
 func (hc *Handler) Start() telegohandler.Handler {
 	return func(bot *telego.Bot, update telego.Update) {
-		err := hc.BehaviorHandler.Start(update.Message.Chat.ID)
+		ctx, cancel := context.WithTimeout(update.Context(), handlerTimeout)
+		defer cancel()
+
+		err := hc.BehaviorHandler.Start(ctx, update.Message.Chat.ID)
 		if err != nil {
 			hc.Logger.Error(err)
 		}
@@ -33,7 +209,64 @@ func (hc *Handler) Start() telegohandler.Handler {
 
 func (hc *Handler) About() telegohandler.Handler {
 	return func(bot *telego.Bot, update telego.Update) {
-		err := hc.BehaviorHandler.About(update.Message.Chat.ID)
+		ctx, cancel := context.WithTimeout(update.Context(), handlerTimeout)
+		defer cancel()
+
+		err := hc.BehaviorHandler.About(ctx, update.Message.Chat.ID)
+		if err != nil {
+			hc.Logger.Error(err)
+		}
+	}
+}
+
+func (hc *Handler) Filter() telegohandler.Handler {
+	return func(bot *telego.Bot, update telego.Update) {
+		ctx, cancel := context.WithTimeout(update.Context(), handlerTimeout)
+		defer cancel()
+
+		_, _, payload := tu.ParseCommandPayload(update.Message.Text)
+		// "/filter owner/repo" only shows the filters of the repo
+		if len(strings.Fields(payload)) > 1 && !hc.commandAllowed(ctx, update.Message) {
+			return
+		}
+
+		err := hc.BehaviorHandler.Filter(ctx, update.Message.Chat.ID, payload)
+		if err != nil {
+			hc.Logger.Error(err)
+		}
+	}
+}
+
+func (hc *Handler) Template() telegohandler.Handler {
+	return func(bot *telego.Bot, update telego.Update) {
+		ctx, cancel := context.WithTimeout(update.Context(), handlerTimeout)
+		defer cancel()
+
+		_, _, payload := tu.ParseCommandPayload(update.Message.Text)
+		// without a payload the template is only shown
+		if len(strings.Fields(payload)) > 0 && !hc.commandAllowed(ctx, update.Message) {
+			return
+		}
+
+		err := hc.BehaviorHandler.Template(ctx, update.Message.Chat.ID, payload)
+		if err != nil {
+			hc.Logger.Error(err)
+		}
+	}
+}
+
+func (hc *Handler) Digest() telegohandler.Handler {
+	return func(bot *telego.Bot, update telego.Update) {
+		ctx, cancel := context.WithTimeout(update.Context(), handlerTimeout)
+		defer cancel()
+
+		_, _, payload := tu.ParseCommandPayload(update.Message.Text)
+		// without a payload the delivery is only shown
+		if len(strings.Fields(payload)) > 0 && !hc.commandAllowed(ctx, update.Message) {
+			return
+		}
+
+		err := hc.BehaviorHandler.Digest(ctx, update.Message.Chat.ID, payload)
 		if err != nil {
 			hc.Logger.Error(err)
 		}
@@ -42,61 +275,156 @@ func (hc *Handler) About() telegohandler.Handler {
 
 func (hc *Handler) UnknownOrSent() telegohandler.Handler {
 	return func(bot *telego.Bot, update telego.Update) {
-		if _, ok := hc.AwaitingAddRepo[update.Message.Chat.ID]; !ok {
-			err := hc.BehaviorHandler.UnknownCommand(update.Message.Chat.ID)
+		ctx, cancel := context.WithTimeout(update.Context(), handlerTimeout)
+		defer cancel()
+
+		awaiting := messageAwaiting(update.Message)
+		if hc.awaiting(hc.AwaitingTimeZone, awaiting) {
+			accepted, err := hc.BehaviorHandler.SentTimeZone(ctx, update.Message.Text, update.Message.Chat.ID)
 			if err != nil {
 				hc.Logger.Error(err)
 			}
-		} else {
-			err := hc.BehaviorHandler.SentRepo(update.Message.Text, update.Message.MessageID, update.Message.Chat.ID)
+			if accepted {
+				hc.forget(awaiting)
+			}
+		} else if hc.awaiting(hc.AwaitingAddRepo, awaiting) {
+			err := hc.BehaviorHandler.SentRepo(ctx, update.Message.Text, update.Message.MessageID, update.Message.Chat.ID)
 			if err != nil {
 				hc.Logger.Error(err)
 			}
+		} else if update.Message.Chat.Type == telego.ChatTypePrivate || telegohandler.CommandRegexp.MatchString(update.Message.Text) {
+			// what members of a group say to each other is none of the bot's business
+			err := hc.BehaviorHandler.UnknownCommand(ctx, update.Message.Chat.ID)
+			if err != nil {
+				hc.Logger.Error(err)
+			}
+		}
+	}
+}
+
+func (hc *Handler) SeeRepos(limit, page int) telegohandler.CallbackQueryHandlerCtx {
+	return func(ctx context.Context, bot *telego.Bot, query telego.CallbackQuery) {
+		ctx, cancel := context.WithTimeout(ctx, handlerTimeout)
+		defer cancel()
+
+		messageChatId := query.Message.GetChat().ID
+		messageId := query.Message.GetMessageID()
+		err := hc.BehaviorHandler.SeeRepos(ctx, messageChatId, messageId, limit, page)
+		if err != nil {
+			hc.Logger.Error(err)
 		}
 	}
 }
 
-func (hc *Handler) SeeRepos(limit, page int) telegohandler.CallbackQueryHandler {
-	return func(bot *telego.Bot, query telego.CallbackQuery) {
+func (hc *Handler) Menu() telegohandler.CallbackQueryHandlerCtx {
+	return func(ctx context.Context, bot *telego.Bot, query telego.CallbackQuery) {
+		ctx, cancel := context.WithTimeout(ctx, handlerTimeout)
+		defer cancel()
+
 		messageChatId := query.Message.GetChat().ID
 		messageId := query.Message.GetMessageID()
-		err := hc.BehaviorHandler.SeeRepos(messageChatId, messageId, limit, page)
+		err := hc.BehaviorHandler.Menu(ctx, messageChatId, messageId)
 		if err != nil {
 			hc.Logger.Error(err)
 		}
+		hc.forgetQuery(ctx, query)
 	}
 }
 
-func (hc *Handler) Menu() telegohandler.CallbackQueryHandler {
-	return func(bot *telego.Bot, query telego.CallbackQuery) {
+func (hc *Handler) Add() telegohandler.CallbackQueryHandlerCtx {
+	return func(ctx context.Context, bot *telego.Bot, query telego.CallbackQuery) {
+		ctx, cancel := context.WithTimeout(ctx, handlerTimeout)
+		defer cancel()
+
+		member, allowed := hc.queryAllowed(ctx, query)
+		if !allowed {
+			return
+		}
+
 		messageChatId := query.Message.GetChat().ID
 		messageId := query.Message.GetMessageID()
-		err := hc.BehaviorHandler.Menu(messageChatId, messageId)
+		err := hc.BehaviorHandler.Add(ctx, messageChatId, messageId)
 		if err != nil {
 			hc.Logger.Error(err)
 		}
-		delete(hc.AwaitingAddRepo, messageChatId)
+		hc.await(hc.AwaitingAddRepo, member)
+		hc.askForReply(ctx, query, member, consts.AddRepoReplyPrompt)
 	}
 }
 
-func (hc *Handler) Add() telegohandler.CallbackQueryHandler {
-	return func(bot *telego.Bot, query telego.CallbackQuery) {
+func (hc *Handler) Settings() telegohandler.CallbackQueryHandlerCtx {
+	return func(ctx context.Context, bot *telego.Bot, query telego.CallbackQuery) {
+		ctx, cancel := context.WithTimeout(ctx, handlerTimeout)
+		defer cancel()
+
 		messageChatId := query.Message.GetChat().ID
 		messageId := query.Message.GetMessageID()
-		err := hc.BehaviorHandler.Add(messageChatId, messageId)
+		err := hc.BehaviorHandler.Settings(ctx, messageChatId, messageId)
 		if err != nil {
 			hc.Logger.Error(err)
 		}
-		hc.AwaitingAddRepo[messageChatId] = set
+		hc.forgetQuery(ctx, query)
 	}
 }
 
-func (hc *Handler) AnyCallbackRouter() telegohandler.CallbackQueryHandler {
-	return func(bot *telego.Bot, query telego.CallbackQuery) {
+func (hc *Handler) TimeZone() telegohandler.CallbackQueryHandlerCtx {
+	return func(ctx context.Context, bot *telego.Bot, query telego.CallbackQuery) {
+		ctx, cancel := context.WithTimeout(ctx, handlerTimeout)
+		defer cancel()
+
+		member, allowed := hc.queryAllowed(ctx, query)
+		if !allowed {
+			return
+		}
+
+		messageChatId := query.Message.GetChat().ID
+		messageId := query.Message.GetMessageID()
+		err := hc.BehaviorHandler.AskTimeZone(ctx, messageChatId, messageId)
+		if err != nil {
+			hc.Logger.Error(err)
+		}
+		hc.await(hc.AwaitingTimeZone, member)
+		hc.askForReply(ctx, query, member, consts.TimeZoneReplyPrompt)
+	}
+}
+
+func (hc *Handler) AnyCallbackRouter() telegohandler.CallbackQueryHandlerCtx {
+	return func(ctx context.Context, bot *telego.Bot, query telego.CallbackQuery) {
+		ctx, cancel := context.WithTimeout(ctx, handlerTimeout)
+		defer cancel()
+
+		// paging through the repo list is all that's left to members who aren't admins
+		paging := strings.HasPrefix(query.Data, consts.PreviousOperationPrefix) || strings.HasPrefix(query.Data, consts.ForwardOperationPrefix)
+		if !paging {
+			if _, allowed := hc.queryAllowed(ctx, query); !allowed {
+				return
+			}
+		}
+
 		messageChatId := query.Message.GetChat().ID
 		messageId := query.Message.GetMessageID()
 		if strings.HasPrefix(query.Data, consts.FlipOperationPrefix) {
-			err := hc.BehaviorHandler.FlipPreRelease(messageChatId, messageId, query.Data)
+			err := hc.BehaviorHandler.FlipPreRelease(ctx, messageChatId, messageId, query.Data)
+			if err != nil {
+				hc.Logger.Error(err)
+			}
+		} else if strings.HasPrefix(query.Data, consts.TrackTagsOperationPrefix) {
+			err := hc.BehaviorHandler.FlipTrackTags(ctx, messageChatId, messageId, query.Data)
+			if err != nil {
+				hc.Logger.Error(err)
+			}
+		} else if strings.HasPrefix(query.Data, consts.UpdateLevelOperationPrefix) {
+			err := hc.BehaviorHandler.FlipUpdateLevel(ctx, messageChatId, messageId, query.Data)
+			if err != nil {
+				hc.Logger.Error(err)
+			}
+		} else if strings.HasPrefix(query.Data, consts.QuietHoursOperationPrefix) {
+			err := hc.BehaviorHandler.SetQuietHours(ctx, messageChatId, messageId, query.Data)
+			if err != nil {
+				hc.Logger.Error(err)
+			}
+		} else if strings.HasPrefix(query.Data, consts.QuietModeOperationPrefix) {
+			err := hc.BehaviorHandler.FlipQuietMode(ctx, messageChatId, messageId, query.Data)
 			if err != nil {
 				hc.Logger.Error(err)
 			}
@@ -107,7 +435,7 @@ func (hc *Handler) AnyCallbackRouter() telegohandler.CallbackQueryHandler {
 				return
 			}
 
-			err = hc.BehaviorHandler.SeeRepos(messageChatId, messageId, hc.Limit, page)
+			err = hc.BehaviorHandler.SeeRepos(ctx, messageChatId, messageId, hc.Limit, page)
 			if err != nil {
 				hc.Logger.Error(err)
 			}
@@ -118,12 +446,12 @@ func (hc *Handler) AnyCallbackRouter() telegohandler.CallbackQueryHandler {
 				return
 			}
 
-			err = hc.BehaviorHandler.SeeRepos(messageChatId, messageId, hc.Limit, page)
+			err = hc.BehaviorHandler.SeeRepos(ctx, messageChatId, messageId, hc.Limit, page)
 			if err != nil {
 				hc.Logger.Error(err)
 			}
 		} else {
-			err := hc.BehaviorHandler.DeleteRepo(messageChatId, messageId, query.Data)
+			err := hc.BehaviorHandler.DeleteRepo(ctx, messageChatId, messageId, query.Data)
 			if err != nil {
 				hc.Logger.Error(err)
 			}
@@ -0,0 +1,153 @@
+package telegohandlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/chofnar/release-bot/internal/database/memory"
+	"github.com/chofnar/release-bot/internal/server/behaviors"
+	"github.com/mymmrac/telego"
+	"go.uber.org/zap"
+)
+
+func TestForBot(t *testing.T) {
+	forBot := ForBot("ReleaseBot")
+
+	for text, want := range map[string]bool{
+		"/start":                   true,
+		"/start@ReleaseBot":        true,
+		"/digest@releasebot daily": true,
+		"/start@OtherBot":          false,
+		"github.com/a/b":           true,
+	} {
+		update := telego.Update{Message: &telego.Message{Text: text}}
+		if got := forBot(update); got != want {
+			t.Errorf("ForBot(%q) = %v, want %v", text, got, want)
+		}
+	}
+
+	if !forBot(telego.Update{CallbackQuery: &telego.CallbackQuery{}}) {
+		t.Error("ForBot dropped a callback query")
+	}
+}
+
+// testHandler returns a Handler whose bot talks to a stand-in Bot API accepting every message, and answering
+// getChatMember with member. Every call is recorded as its method followed by its body.
+func testHandler(t *testing.T, member string) (*Handler, func() []string) {
+	t.Helper()
+
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		calls = append(calls, path.Base(r.URL.Path)+" "+string(body))
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/getChatMember") {
+			_, _ = w.Write([]byte(`{"ok": true, "result": ` + member + `}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok": true, "result": {"message_id": 1, "date": 0, "chat": {"id": 1, "type": "private"}}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	bot, err := telego.NewBot("123456:"+strings.Repeat("a", 35), telego.WithAPIServer(server.URL), telego.WithDiscardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := *zap.NewNop().Sugar()
+	return &Handler{
+		BehaviorHandler: behaviors.BehaviorHandler{
+			Sender:      behaviors.NewSender(bot),
+			LinkRegex:   regexp.MustCompile("(?:https://)github.com[:/](.*)[:/](.*)"),
+			DirectRegex: regexp.MustCompile("(.*)[/](.*)"),
+			DB:          memory.New(logger),
+		},
+		Logger:           logger,
+		AwaitingAddRepo:  map[Awaiting]struct{}{},
+		AwaitingTimeZone: map[Awaiting]struct{}{},
+	}, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), calls...)
+	}
+}
+
+// TestConcurrentUpdates runs the handlers of many chats at once, go test -race catches unguarded state.
+func TestConcurrentUpdates(t *testing.T) {
+	hc, _ := testHandler(t, "")
+	add, timeZone, menu, sent := hc.Add(), hc.TimeZone(), hc.Menu(), hc.UnknownOrSent()
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		chat := telego.Chat{ID: int64(i + 1), Type: telego.ChatTypePrivate}
+		user := telego.User{ID: int64(i + 1)}
+		query := telego.CallbackQuery{
+			ID:      fmt.Sprint(i),
+			From:    user,
+			Message: &telego.Message{MessageID: 1, Chat: chat},
+		}
+		prompt, answer := add, "no repo"
+		if i%2 == 1 {
+			prompt, answer = timeZone, "Europe/Berlin"
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			prompt(context.Background(), nil, query)
+			sent(nil, telego.Update{Message: &telego.Message{Chat: chat, From: &user, Text: answer}})
+			menu(context.Background(), nil, query)
+		}()
+	}
+	wg.Wait()
+
+	if len(hc.AwaitingAddRepo) != 0 || len(hc.AwaitingTimeZone) != 0 {
+		t.Errorf("still awaiting %d repos and %d time zones after going back to the menu", len(hc.AwaitingAddRepo), len(hc.AwaitingTimeZone))
+	}
+}
+
+func TestGroupAddRepo(t *testing.T) {
+	group := telego.Chat{ID: -100, Type: telego.ChatTypeSupergroup}
+	admin := telego.User{ID: 5, FirstName: "Ada"}
+	query := telego.CallbackQuery{ID: "1", From: admin, Message: &telego.Message{MessageID: 1, Chat: group}}
+
+	hc, calls := testHandler(t, `{"status": "administrator", "user": {"id": 5, "is_bot": false, "first_name": "Ada"}}`)
+	hc.Add()(context.Background(), nil, query)
+
+	prompt := calls()[len(calls())-1]
+	if !strings.HasPrefix(prompt, "sendMessage ") || !strings.Contains(prompt, `"force_reply":true`) || !strings.Contains(prompt, `"selective":true`) {
+		t.Errorf("an admin of a group was asked with %s, want a selective ForceReply", prompt)
+	}
+	if !hc.awaiting(hc.AwaitingAddRepo, Awaiting{ChatID: group.ID, UserID: admin.ID}) {
+		t.Error("the admin is not awaited")
+	}
+
+	// anonymous admins reply as the group, through Telegram's GroupAnonymousBot
+	hc, calls = testHandler(t, `{"status": "administrator", "is_anonymous": true, "user": {"id": 5, "is_bot": false, "first_name": "Ada"}}`)
+	hc.Add()(context.Background(), nil, query)
+	if prompt := calls()[len(calls())-1]; strings.Contains(prompt, "Ada") {
+		t.Errorf("the prompt gave an anonymous admin away: %s", prompt)
+	}
+
+	before := len(calls())
+	anonymousBot := telego.User{ID: 1087968824, IsBot: true, FirstName: "Group"}
+	hc.UnknownOrSent()(nil, telego.Update{Message: &telego.Message{Chat: group, From: &anonymousBot, SenderChat: &group, Text: "no repo"}})
+	if answered := calls()[before:]; len(answered) != 1 || !strings.Contains(answered[0], "Invalid repo") {
+		t.Errorf("the anonymous admin's answer got %v, want it taken for the repo", answered)
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chofnar/release-bot/internal/errors"
+	"github.com/chofnar/release-bot/internal/server/behaviors"
+	"go.uber.org/zap"
+)
+
+const (
+	signatureHeader = "X-Hub-Signature-256"
+	signaturePrefix = "sha256="
+	eventHeader     = "X-GitHub-Event"
+)
+
+// webhookTimeout bounds the notifications sent for a single delivery. They are sent after the response,
+// GitHub gives up waiting for it after 10 seconds and the messages are paced to Telegram's limits.
+const webhookTimeout = 2 * time.Minute
+
+type WebhookPath struct {
+	// announcing tracks the deliveries whose notifications are still being sent
+	announcing sync.WaitGroup
+}
+
+func (wp *WebhookPath) ServeHTTP(behaviorHandler *behaviors.BehaviorHandler, logger zap.SugaredLogger, secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer logger.Sync()
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			msg := "could not read request body"
+			logger.Error(msg)
+			_, writeErr := w.Write([]byte(msg))
+			if writeErr != nil {
+				logger.Error(writeErr)
+			}
+			return
+		}
+
+		if !validSignature(secret, r.Header.Get(signatureHeader), b) {
+			logger.Error(errors.ErrWebhookSignature)
+			w.WriteHeader(http.StatusUnauthorized)
+			_, writeErr := w.Write([]byte(errors.ErrWebhookSignature.Error()))
+			if writeErr != nil {
+				logger.Error(writeErr)
+			}
+			return
+		}
+
+		// GitHub sends a ping when the webhook is created, anything but releases is acknowledged and dropped
+		if r.Header.Get(eventHeader) != "release" {
+			_, writeErr := w.Write([]byte("ignored " + r.Header.Get(eventHeader) + " event"))
+			if writeErr != nil {
+				logger.Error(writeErr)
+			}
+			return
+		}
+
+		var event behaviors.ReleaseEvent
+		err = json.Unmarshal(b, &event)
+		if err != nil {
+			logger.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			_, writeErr := w.Write([]byte(err.Error()))
+			if writeErr != nil {
+				logger.Error(writeErr)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		_, writeErr := w.Write([]byte("accepted"))
+		if writeErr != nil {
+			logger.Error(writeErr)
+		}
+
+		// a redelivery while this one is running can't announce the release twice, the outbox keys see to it
+		wp.announcing.Add(1)
+		go func() {
+			defer wp.announcing.Done()
+
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), webhookTimeout)
+			defer cancel()
+
+			failedRepoErrors := behaviorHandler.ReleaseWebhook(ctx, logger, event)
+			if len(failedRepoErrors) != 0 {
+				logger.Error(failedRepoErrors)
+			}
+		}()
+	}
+}
+
+// validSignature checks the X-Hub-Signature-256 header, an HMAC-SHA256 of the body keyed with the webhook secret.
+func validSignature(secret, header string, body []byte) bool {
+	if secret == "" || !strings.HasPrefix(header, signaturePrefix) {
+		return false
+	}
+
+	got, err := hex.DecodeString(strings.TrimPrefix(header, signaturePrefix))
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hmac.Equal(got, mac.Sum(nil))
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/chofnar/release-bot/internal/database/memory"
+	"github.com/chofnar/release-bot/internal/server/behaviors"
+	"github.com/chofnar/release-bot/internal/server/repo"
+	"github.com/hasura/go-graphql-client"
+	"github.com/mymmrac/telego"
+	"go.uber.org/zap"
+)
+
+const testSecret = "webhook-secret"
+
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// sentMessage is a message the stand-in Bot API got, with the chat it went to.
+type sentMessage struct {
+	chatID string
+	body   string
+}
+
+// fakeTelegram returns a bot talking to a stand-in Bot API that records every sent message.
+func fakeTelegram(t *testing.T) (*telego.Bot, func() []sentMessage) {
+	t.Helper()
+
+	var (
+		mu   sync.Mutex
+		sent []sentMessage
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var params struct {
+			ChatID json.Number `json:"chat_id"`
+		}
+		_ = json.Unmarshal(body, &params)
+
+		mu.Lock()
+		sent = append(sent, sentMessage{chatID: params.ChatID.String(), body: string(body)})
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ok": true, "result": {"message_id": 1, "date": 0, "chat": {"id": 1, "type": "private"}}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	bot, err := telego.NewBot("123456:"+strings.Repeat("a", 35), telego.WithAPIServer(server.URL), telego.WithDiscardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bot, func() []sentMessage {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]sentMessage(nil), sent...)
+	}
+}
+
+// fakeGitHub returns a GraphQL client for a stand-in GitHub that has owner/name with a prerelease published
+// after its first release.
+func fakeGitHub(t *testing.T) *graphql.Client {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data": {
+			"r0": {"id": "R_1", "url": "https://github.com/owner/name", "name": "name", "owner": {"login": "owner"},
+				"releases": {"nodes": [
+					{"tagName": "v2.0.0-rc1", "id": "RE_2", "isPrerelease": true, "name": "Release candidate"},
+					{"tagName": "v1.0.0", "id": "RE_1"}]},
+				"refs": {"nodes": []}},
+			"rateLimit": {"cost": 1, "remaining": 4999, "resetAt": "2030-01-01T00:00:00Z"}}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	return graphql.NewClient(server.URL, server.Client())
+}
+
+func TestValidSignature(t *testing.T) {
+	body := []byte(`{"action": "published"}`)
+
+	cases := []struct {
+		name, secret, header string
+		want                 bool
+	}{
+		{"valid", testSecret, sign(testSecret, body), true},
+		{"other secret", testSecret, sign("other", body), false},
+		{"no prefix", testSecret, strings.TrimPrefix(sign(testSecret, body), signaturePrefix), false},
+		{"not hex", testSecret, signaturePrefix + "zz", false},
+		{"missing", testSecret, "", false},
+		{"no secret configured", "", sign("", body), false},
+	}
+
+	for _, c := range cases {
+		if got := validSignature(c.secret, c.header, body); got != c.want {
+			t.Errorf("%s: validSignature = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestReleaseWebhook(t *testing.T) {
+	ctx := context.Background()
+	logger := *zap.NewNop().Sugar()
+
+	db := memory.New(logger)
+	watched := repo.Repo{
+		RepoID:  "R_1",
+		Name:    "name",
+		Owner:   "owner",
+		Link:    "https://github.com/owner/name",
+		Release: repo.Release{CurrentReleaseTagName: "v1.0.0", CurrentReleaseID: "RE_1"},
+	}
+	for _, chatID := range []string{"1", "2"} {
+		if err := db.AddRepo(ctx, chatID, &watched); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.SetPreReleaseRetrieve(ctx, "2", "R_1", true); err != nil {
+		t.Fatal(err)
+	}
+
+	bot, sent := fakeTelegram(t)
+	behaviorHandler := behaviors.BehaviorHandler{Sender: behaviors.NewSender(bot), DB: db, GQLClient: fakeGitHub(t)}
+	webhook := &WebhookPath{}
+	handler := webhook.ServeHTTP(&behaviorHandler, logger, testSecret)
+
+	deliver := func(event, payload string, signature string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/githubWebhook", strings.NewReader(payload))
+		request.Header.Set(eventHeader, event)
+		request.Header.Set(signatureHeader, signature)
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+		webhook.announcing.Wait()
+		return recorder
+	}
+
+	// the payload only names the repo, whatever else it claims is read from GitHub
+	prerelease := `{"action": "published",
+		"release": {"node_id": "RE_9", "tag_name": "v9.9.9", "prerelease": false},
+		"repository": {"node_id": "R_1", "name": "name", "html_url": "https://example.com/phishing", "owner": {"login": "owner"}}}`
+
+	if got := deliver("release", prerelease, sign("other", []byte(prerelease))); got.Code != http.StatusUnauthorized {
+		t.Errorf("forged delivery got status %d, want %d", got.Code, http.StatusUnauthorized)
+	}
+	if len(sent()) != 0 {
+		t.Fatal("forged delivery sent notifications")
+	}
+
+	if got := deliver("release", prerelease, sign(testSecret, []byte(prerelease))); got.Code != http.StatusAccepted {
+		t.Fatalf("got status %d: %s", got.Code, got.Body)
+	}
+	got := sent()
+	if len(got) != 1 || got[0].chatID != "2" {
+		t.Fatalf("prerelease was sent as %v, want only to the chat that asked for prereleases", got)
+	}
+	if strings.Contains(got[0].body, "v9.9.9") || strings.Contains(got[0].body, "example.com") ||
+		!strings.Contains(got[0].body, "v2.0.0-rc1") || !strings.Contains(got[0].body, "https://github.com/owner/name") {
+		t.Errorf("notification was not built from GitHub's releases: %s", got[0].body)
+	}
+
+	repos, err := db.GetRepos(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repos[0].CurrentReleaseID != "RE_2" {
+		t.Errorf("last seen release is %q, want %q", repos[0].CurrentReleaseID, "RE_2")
+	}
+
+	// GitHub redelivers on timeouts, the same release must not be announced twice
+	deliver("release", prerelease, sign(testSecret, []byte(prerelease)))
+	if got := sent(); len(got) != 1 {
+		t.Errorf("redelivery sent %d more notifications", len(got)-1)
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	// chats pick their own time zone for digests, the container image has no zoneinfo
+	_ "time/tzdata"
+
 	"github.com/chofnar/release-bot/internal/server"
 )
 